@@ -0,0 +1,233 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// DefaultAsyncPollInterval is the initial interval at which the status of an asynchronous request is polled,
+// if the server doesn't specify one using the Retry-After header. The interval doubles after every poll, up to maxAsyncPollInterval.
+const DefaultAsyncPollInterval = time.Second
+
+const maxAsyncPollInterval = time.Minute
+
+// ErrAsyncInProgress is returned when a request was accepted for asynchronous processing by the server,
+// and the client was instructed not to wait for it to complete (RespondAsyncNoWait).
+// The request can be resumed later using BaseClient.ResumeAsync.
+var ErrAsyncInProgress = errors.New("FHIR request is being processed asynchronously")
+
+// AsyncHandle refers to a request that is being processed asynchronously by the FHIR server.
+// It can be persisted (e.g. as JSON) to resume waiting for the result later, using BaseClient.ResumeAsync.
+type AsyncHandle struct {
+	// StatusURL is the URL at which the status of the request can be polled (Content-Location of the 202 Accepted response).
+	StatusURL string `json:"statusUrl"`
+	// Unwrap indicates whether the result is the single entry of the batch-response Bundle returned by the server.
+	// It's false for batch and transaction requests, for which the result is the Bundle itself.
+	Unwrap bool `json:"unwrap"`
+}
+
+// RespondAsync asks the server to process the request asynchronously (Prefer: respond-async).
+// If the server accepts the request for asynchronous processing (202 Accepted), the client polls the status endpoint
+// until the request has completed, and then unmarshals the actual result into the request's target.
+// If the handle is not nil, it is populated when the server accepts the request, so waiting can be resumed (see BaseClient.ResumeAsync)
+// if it's interrupted (e.g. the context is cancelled). If the server processes the request synchronously, it's handled as usual.
+func RespondAsync(handle *AsyncHandle) Option {
+	return requestOption(func(s *requestSettings) {
+		s.async = true
+		s.asyncHandle = handle
+		s.asyncNoWait = false
+	})
+}
+
+// RespondAsyncNoWait is like RespondAsync, but doesn't wait for the request to complete:
+// when the server accepts the request for asynchronous processing, the handle is populated and ErrAsyncInProgress is returned.
+func RespondAsyncNoWait(handle *AsyncHandle) Option {
+	return requestOption(func(s *requestSettings) {
+		s.async = true
+		s.asyncHandle = handle
+		s.asyncNoWait = true
+	})
+}
+
+// ResumeAsync waits for the asynchronous request the handle refers to, to complete, and unmarshals the result into the target.
+func (d BaseClient) ResumeAsync(ctx context.Context, handle AsyncHandle, target any) error {
+	statusURL, err := url.Parse(handle.StatusURL)
+	if err != nil {
+		return fmt.Errorf("invalid async status URL: %w", err)
+	}
+	interval := d.config.AsyncPollInterval
+	if interval <= 0 {
+		interval = DefaultAsyncPollInterval
+	}
+	for {
+		var data []byte
+		status, err := d.pollStatus(ctx, statusURL, &data)
+		if err != nil {
+			return fmt.Errorf("FHIR async status request failed: %w", err)
+		}
+		if status.completed {
+			return unmarshalAsyncResult(data, handle.Unwrap, target)
+		}
+		delay := status.retryAfter
+		if delay == 0 {
+			delay = interval
+			interval = min(2*interval, maxAsyncPollInterval)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// CancelAsync cancels the asynchronous request the handle refers to, by sending a DELETE request to the status URL.
+func (d BaseClient) CancelAsync(ctx context.Context, handle AsyncHandle) error {
+	statusURL, err := url.Parse(handle.StatusURL)
+	if err != nil {
+		return fmt.Errorf("invalid async status URL: %w", err)
+	}
+	return d.DeleteWithContext(ctx, "", AtUrl(statusURL), withoutAsync())
+}
+
+// withoutAsync makes sure a request isn't handled asynchronously, even if RespondAsync is one of the default options.
+// It's used for requests to status URLs of asynchronous requests.
+func withoutAsync() Option {
+	return requestOption(func(s *requestSettings) {
+		s.async = false
+	})
+}
+
+// awaitAsync handles a 202 Accepted response to a request that asked for asynchronous processing.
+func (d BaseClient) awaitAsync(httpRequest *http.Request, httpResponse *http.Response, target any, settings requestSettings) error {
+	statusURL, err := parseContentLocation(d, httpResponse.Header)
+	if err != nil {
+		return fmt.Errorf("FHIR async request failed (%s %s): %w", httpRequest.Method, httpRequest.URL.String(), err)
+	}
+	handle := AsyncHandle{
+		StatusURL: statusURL.String(),
+		// Batch and transaction Bundles are POSTed to the base URL; their result is the response Bundle itself.
+		Unwrap: !(httpRequest.Method == http.MethodPost && strings.TrimSuffix(httpRequest.URL.Path, "/") == strings.TrimSuffix(d.baseURL.Path, "/")),
+	}
+	if settings.asyncHandle != nil {
+		*settings.asyncHandle = handle
+	}
+	if settings.asyncNoWait {
+		return ErrAsyncInProgress
+	}
+	return d.ResumeAsync(httpRequest.Context(), handle, target)
+}
+
+// unmarshalAsyncResult unmarshals the result of a completed asynchronous request into the target.
+// The server returns a batch-response Bundle, which (unless it's the result of a batch or transaction) contains a single entry with the actual response.
+func unmarshalAsyncResult(data []byte, unwrap bool, target any) error {
+	if unwrap {
+		var bundle fhir.Bundle
+		if err := detectCodec(data).Unmarshal(data, &bundle); err == nil && bundle.Type == fhir.BundleTypeBatchResponse {
+			if len(bundle.Entry) != 1 {
+				return fmt.Errorf("FHIR async response Bundle contains %d entries, expected 1", len(bundle.Entry))
+			}
+			entry := TransactionEntry{Response: bundle.Entry[0].Response}
+			if statusCode := entry.StatusCode(); statusCode < 200 || statusCode >= 300 {
+				if entry.Response != nil {
+					if err := checkForOperationOutcomeError(entry.Response.Outcome, true, statusCode); err != nil {
+						return err
+					}
+				}
+				if err := checkForOperationOutcomeError(bundle.Entry[0].Resource, true, statusCode); err != nil {
+					return err
+				}
+				return fmt.Errorf("FHIR async request failed (status=%d)", statusCode)
+			}
+			data = bundle.Entry[0].Resource
+		}
+	}
+	if target == nil || len(data) == 0 {
+		return nil
+	}
+	if bytesTarget, ok := target.(*[]byte); ok {
+		*bytesTarget = data
+		return nil
+	}
+	if err := detectCodec(data).Unmarshal(data, target); err != nil {
+		return fmt.Errorf("FHIR async response unmarshal failed: %w", err)
+	}
+	return nil
+}
+
+// asyncStatus is the result of polling the status URL of an asynchronous request.
+type asyncStatus struct {
+	completed  bool
+	progress   string
+	retryAfter time.Duration
+}
+
+// pollStatus polls the status URL of an asynchronous request (e.g. a bulk data export) once.
+// The request is in progress if the server responds with 202 Accepted; any other 2xx status means it has completed,
+// in which case the response body is stored in data.
+func (d BaseClient) pollStatus(ctx context.Context, statusURL *url.URL, data *[]byte) (asyncStatus, error) {
+	var headers Headers
+	var statusCode int
+	var body []byte
+	if err := d.ReadWithContext(ctx, statusURL.String(), &body, ResponseHeaders(&headers), ResponseStatusCode(&statusCode), withoutAsync()); err != nil {
+		return asyncStatus{}, err
+	}
+	if statusCode == http.StatusAccepted {
+		result := asyncStatus{progress: headers.Get("X-Progress")}
+		result.retryAfter, _ = retryAfterDelay(&http.Response{Header: headers.Header}, time.Now())
+		return result, nil
+	}
+	*data = body
+	return asyncStatus{completed: true}, nil
+}
+
+// parseContentLocation parses the Content-Location header of a response to an asynchronous request, which contains the status URL.
+// Relative URLs are resolved against the FHIR base URL.
+func parseContentLocation(client Client, headers http.Header) (*url.URL, error) {
+	location := headers.Get("Content-Location")
+	if location == "" {
+		return nil, errors.New("response doesn't contain a Content-Location header")
+	}
+	statusURL, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Location header: %w", err)
+	}
+	if !statusURL.IsAbs() {
+		baseURL := client.Path()
+		baseURL.Path = strings.TrimSuffix(baseURL.Path, "/") + "/"
+		statusURL = baseURL.ResolveReference(statusURL)
+	}
+	return statusURL, nil
+}
+
+// sleepContext waits for the given duration, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
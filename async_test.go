@@ -0,0 +1,180 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestRespondAsync(t *testing.T) {
+	baseURL, _ := url.Parse("http://example.com/fhir")
+	config := &Config{AsyncPollInterval: time.Millisecond}
+	patientID := "123"
+	acceptedResponse := func() *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusAccepted,
+			Header:     http.Header{"Content-Location": {"http://example.com/fhir/async/1"}},
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+		}
+	}
+	inProgressResponse := func() *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusAccepted,
+			Header:     http.Header{"X-Progress": {"busy"}},
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+		}
+	}
+	batchResponse := func(status string, resource any) *http.Response {
+		data, _ := json.Marshal(resource)
+		return createBundleResponse(fhir.Bundle{
+			Type: fhir.BundleTypeBatchResponse,
+			Entry: []fhir.BundleEntry{
+				{
+					Resource: data,
+					Response: &fhir.BundleEntryResponse{Status: status},
+				},
+			},
+		})
+	}
+
+	t.Run("waits for result and unwraps it", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				acceptedResponse(),
+				inProgressResponse(),
+				batchResponse("200 OK", fhir.Patient{ID: &patientID}),
+			},
+		}
+		client := New(baseURL, stub, config)
+		var handle AsyncHandle
+		var result fhir.Patient
+
+		err := client.Read("Patient/123", &result, RespondAsync(&handle))
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", *result.ID)
+		assert.Equal(t, AsyncHandle{StatusURL: "http://example.com/fhir/async/1", Unwrap: true}, handle)
+		require.Len(t, stub.requests, 3)
+		assert.Equal(t, "respond-async", stub.requests[0].Header.Get("Prefer"))
+		assert.Empty(t, stub.requests[1].Header.Get("Prefer"))
+		assert.Equal(t, "http://example.com/fhir/async/1", stub.requests[2].URL.String())
+	})
+	t.Run("failed request", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				acceptedResponse(),
+				batchResponse("404 Not Found", fhir.OperationOutcome{
+					Issue: []fhir.OperationOutcomeIssue{{Severity: fhir.IssueSeverityError, Code: fhir.IssueTypeNotFound}},
+				}),
+			},
+		}
+		client := New(baseURL, stub, config)
+
+		err := client.Read("Patient/123", new(fhir.Patient), RespondAsync(nil))
+
+		var outcomeErr OperationOutcomeError
+		require.ErrorAs(t, err, &outcomeErr)
+		assert.Equal(t, http.StatusNotFound, outcomeErr.HttpStatusCode)
+	})
+	t.Run("transaction result is not unwrapped", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				acceptedResponse(),
+				createBundleResponse(fhir.Bundle{
+					Type:  fhir.BundleTypeTransactionResponse,
+					Entry: []fhir.BundleEntry{{Response: &fhir.BundleEntryResponse{Status: "201 Created"}}},
+				}),
+			},
+		}
+		client := New(baseURL, stub, config)
+		transaction := NewTransaction()
+		entry := transaction.Create(fhir.Patient{})
+		var handle AsyncHandle
+
+		err := client.Transaction(transaction, RespondAsync(&handle))
+
+		require.NoError(t, err)
+		assert.False(t, handle.Unwrap)
+		assert.Equal(t, http.StatusCreated, entry.StatusCode())
+	})
+	t.Run("no wait, resumed later", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				acceptedResponse(),
+				batchResponse("200 OK", fhir.Patient{ID: &patientID}),
+			},
+		}
+		client := New(baseURL, stub, config)
+		var handle AsyncHandle
+
+		err := client.Read("Patient/123", new(fhir.Patient), RespondAsyncNoWait(&handle))
+		require.ErrorIs(t, err, ErrAsyncInProgress)
+		require.Len(t, stub.requests, 1)
+		persisted, _ := json.Marshal(handle)
+		var resumedHandle AsyncHandle
+		require.NoError(t, json.Unmarshal(persisted, &resumedHandle))
+		var result fhir.Patient
+		err = client.ResumeAsync(context.Background(), resumedHandle, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", *result.ID)
+	})
+	t.Run("server responds synchronously", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{createBundleResponse(fhir.Bundle{Type: fhir.BundleTypeSearchset})},
+		}
+		client := New(baseURL, stub, config)
+		var result fhir.Bundle
+
+		err := client.Search("Patient", url.Values{}, &result, RespondAsync(nil))
+
+		require.NoError(t, err)
+		assert.Equal(t, fhir.BundleTypeSearchset, result.Type)
+	})
+	t.Run("missing Content-Location", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{inProgressResponse()},
+		}
+		client := New(baseURL, stub, config)
+
+		err := client.Read("Patient/123", new(fhir.Patient), RespondAsync(nil))
+
+		assert.EqualError(t, err, "FHIR async request failed (GET http://example.com/fhir/Patient/123): response doesn't contain a Content-Location header")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{inProgressResponse()},
+		}
+		client := New(baseURL, stub, &Config{DefaultOptions: []Option{RespondAsync(nil)}})
+
+		err := client.CancelAsync(context.Background(), AsyncHandle{StatusURL: "http://example.com/fhir/async/1"})
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodDelete, stub.requests[0].Method)
+		assert.Equal(t, "http://example.com/fhir/async/1", stub.requests[0].URL.String())
+	})
+}
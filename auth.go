@@ -0,0 +1,391 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenRefreshMargin is the time before expiry at which a cached access token is refreshed.
+const DefaultTokenRefreshMargin = 30 * time.Second
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken string
+	TokenType   string
+	Scope       string
+	// ExpiresAt is the time at which the token expires. It is zero if the token server didn't specify an expiry.
+	ExpiresAt time.Time
+}
+
+// TokenSource acquires OAuth2 access tokens, e.g. from an authorization server's token endpoint.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+var _ HttpRequestDoer = &AuthenticatingDoer{}
+
+// NewAuthenticatingDoer creates an HttpRequestDoer that authenticates requests using access tokens acquired from the given TokenSource.
+// Tokens are cached, and refreshed shortly before they expire. If the server responds with 401 Unauthorized,
+// the token is refreshed and the request retried once.
+// The returned doer can be passed to New to create an authenticated FHIR client.
+func NewAuthenticatingDoer(doer HttpRequestDoer, tokenSource TokenSource) *AuthenticatingDoer {
+	return &AuthenticatingDoer{
+		doer:          doer,
+		tokenSource:   tokenSource,
+		RefreshMargin: DefaultTokenRefreshMargin,
+	}
+}
+
+// AuthenticatingDoer is an HttpRequestDoer that adds an access token to every request.
+type AuthenticatingDoer struct {
+	// RefreshMargin is the time before expiry at which the cached token is refreshed.
+	RefreshMargin time.Duration
+
+	doer        HttpRequestDoer
+	tokenSource TokenSource
+	// mux guards token, refreshMux makes sure only one request acquires a new token at a time.
+	mux        sync.Mutex
+	refreshMux sync.Mutex
+	token      *Token
+}
+
+func (a *AuthenticatingDoer) Do(httpRequest *http.Request) (*http.Response, error) {
+	if httpRequest.Body != nil && httpRequest.GetBody == nil {
+		// Buffer the body so the request can be replayed after a 401
+		body, err := io.ReadAll(httpRequest.Body)
+		_ = httpRequest.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		httpRequest.Body = io.NopCloser(bytes.NewReader(body))
+		httpRequest.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	token, err := a.getToken(httpRequest.Context(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire access token: %w", err)
+	}
+	httpResponse, err := a.doer.Do(withToken(httpRequest, token))
+	if err != nil || httpResponse.StatusCode != http.StatusUnauthorized {
+		return httpResponse, err
+	}
+	// Token might've been revoked or expired early: refresh and retry once
+	token, err = a.getToken(httpRequest.Context(), token)
+	if err != nil {
+		return httpResponse, nil
+	}
+	retryRequest := withToken(httpRequest, token)
+	if httpRequest.GetBody != nil {
+		if retryRequest.Body, err = httpRequest.GetBody(); err != nil {
+			return httpResponse, nil
+		}
+	}
+	_ = httpResponse.Body.Close()
+	return a.doer.Do(retryRequest)
+}
+
+// getToken returns the cached token, or acquires a new one if there's none, it's about to expire,
+// or it's the given rejected token. While a new token is being acquired, other requests use the cached token if it hasn't expired yet.
+func (a *AuthenticatingDoer) getToken(ctx context.Context, rejected *Token) (*Token, error) {
+	token := a.cachedToken()
+	if a.isFresh(token, rejected) {
+		return token, nil
+	}
+	if !a.refreshMux.TryLock() {
+		if token != nil && token != rejected && (token.ExpiresAt.IsZero() || time.Now().Before(token.ExpiresAt)) {
+			return token, nil
+		}
+		a.refreshMux.Lock()
+	}
+	defer a.refreshMux.Unlock()
+	// Another request might have acquired a new token in the meantime
+	if token := a.cachedToken(); a.isFresh(token, rejected) {
+		return token, nil
+	}
+	token, err := a.tokenSource.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.token = token
+	return token, nil
+}
+
+func (a *AuthenticatingDoer) cachedToken() *Token {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.token
+}
+
+// isFresh returns whether the token can be used without refreshing it.
+func (a *AuthenticatingDoer) isFresh(token *Token, rejected *Token) bool {
+	return token != nil && token != rejected &&
+		(token.ExpiresAt.IsZero() || time.Now().Add(a.RefreshMargin).Before(token.ExpiresAt))
+}
+
+func withToken(httpRequest *http.Request, token *Token) *http.Request {
+	result := httpRequest.Clone(httpRequest.Context())
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	result.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return result
+}
+
+var _ TokenSource = ClientCredentials{}
+
+// ClientCredentials is a TokenSource that acquires access tokens using the OAuth2 client credentials grant,
+// authenticating the client using its client secret (client_secret_basic).
+type ClientCredentials struct {
+	// TokenURL is the URL of the authorization server's token endpoint.
+	TokenURL string
+	ClientID string
+	// ClientSecret is the secret used to authenticate the client.
+	ClientSecret string
+	// Scopes are the scopes requested, e.g. system/Patient.read.
+	Scopes []string
+	// HttpClient is used to call the token endpoint. If nil, http.DefaultClient is used.
+	HttpClient HttpRequestDoer
+}
+
+func (c ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	return requestToken(ctx, c.HttpClient, c.TokenURL, form, func(r *http.Request) {
+		r.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	})
+}
+
+var _ TokenSource = BackendServices{}
+
+// BackendServices is a TokenSource that acquires access tokens as specified by SMART Backend Services:
+// the OAuth2 client credentials grant, authenticating the client using a JWT signed with its private key (private_key_jwt).
+// RSA keys are used with RS384, and ECDSA P-384 keys with ES384.
+type BackendServices struct {
+	// TokenURL is the URL of the authorization server's token endpoint. It can be discovered using DiscoverSMARTConfiguration.
+	TokenURL string
+	ClientID string
+	// Key is the private key used to sign the client assertion. It must be an *rsa.PrivateKey or *ecdsa.PrivateKey (P-384),
+	// or a crypto.Signer (e.g. backed by an HSM) with such a public key.
+	Key crypto.Signer
+	// KeyID is the identifier of the key (kid) in the client's JWK Set, as registered at the authorization server.
+	KeyID string
+	// Scopes are the scopes requested, e.g. system/Patient.read.
+	Scopes []string
+	// HttpClient is used to call the token endpoint. If nil, http.DefaultClient is used.
+	HttpClient HttpRequestDoer
+}
+
+func (b BackendServices) Token(ctx context.Context) (*Token, error) {
+	assertion, err := b.clientAssertion(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign client assertion: %w", err)
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+	}
+	if len(b.Scopes) > 0 {
+		form.Set("scope", strings.Join(b.Scopes, " "))
+	}
+	return requestToken(ctx, b.HttpClient, b.TokenURL, form, nil)
+}
+
+// clientAssertion creates the signed JWT the client authenticates with at the token endpoint.
+func (b BackendServices) clientAssertion(now time.Time) (string, error) {
+	if b.Key == nil {
+		return "", errors.New("no key configured")
+	}
+	var alg string
+	switch key := b.Key.Public().(type) {
+	case *rsa.PublicKey:
+		alg = "RS384"
+	case *ecdsa.PublicKey:
+		// SMART Backend Services only supports ES384, which requires a P-384 key
+		if key.Curve != elliptic.P384() {
+			return "", fmt.Errorf("unsupported ECDSA curve: %s (ES384 requires P-384)", key.Curve.Params().Name)
+		}
+		alg = "ES384"
+	default:
+		return "", fmt.Errorf("unsupported key type: %T", b.Key.Public())
+	}
+	header := map[string]string{
+		"alg": alg,
+		"typ": "JWT",
+	}
+	if b.KeyID != "" {
+		header["kid"] = b.KeyID
+	}
+	claims := map[string]any{
+		"iss": b.ClientID,
+		"sub": b.ClientID,
+		"aud": b.TokenURL,
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": newUUID(),
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha512.Sum384([]byte(signingInput))
+	signature, err := b.Key.Sign(rand.Reader, digest[:], crypto.SHA384)
+	if err != nil {
+		return "", err
+	}
+	if alg == "ES384" {
+		// crypto.Signer returns an ASN.1 DER signature for ECDSA, but JWS requires the raw R || S form
+		if signature, err = ecdsaASN1ToJWS(signature, b.Key.Public().(*ecdsa.PublicKey).Curve); err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func ecdsaASN1ToJWS(signature []byte, curve elliptic.Curve) ([]byte, error) {
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(signature, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	result := make([]byte, 2*size)
+	parsed.R.FillBytes(result[:size])
+	parsed.S.FillBytes(result[size:])
+	return result, nil
+}
+
+// requestToken requests an access token from the token endpoint using the given form parameters.
+func requestToken(ctx context.Context, httpClient HttpRequestDoer, tokenURL string, form url.Values, authenticate func(r *http.Request)) (*Token, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpRequest.Header.Set("Accept", "application/json")
+	if authenticate != nil {
+		authenticate(httpRequest)
+	}
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed (%s): %w", tokenURL, err)
+	}
+	defer httpResponse.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(httpResponse.Body, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("token response read failed (%s): %w", tokenURL, err)
+	}
+	var response struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int    `json:"expires_in"`
+		Scope            string `json:"scope"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(data, &response)
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 || response.Error != "" {
+		if response.Error != "" {
+			return nil, fmt.Errorf("token request failed (%s, status=%d): %s %s", tokenURL, httpResponse.StatusCode, response.Error, response.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token request failed (%s, status=%d)", tokenURL, httpResponse.StatusCode)
+	}
+	if response.AccessToken == "" {
+		return nil, fmt.Errorf("token response does not contain an access token (%s)", tokenURL)
+	}
+	result := &Token{
+		AccessToken: response.AccessToken,
+		TokenType:   response.TokenType,
+		Scope:       response.Scope,
+	}
+	if response.ExpiresIn > 0 {
+		result.ExpiresAt = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return result, nil
+}
+
+// SMARTConfiguration contains the SMART on FHIR configuration of a FHIR server, as published at .well-known/smart-configuration.
+type SMARTConfiguration struct {
+	Issuer                                     string   `json:"issuer,omitempty"`
+	JwksURI                                    string   `json:"jwks_uri,omitempty"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	GrantTypesSupported                        []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported,omitempty"`
+	Capabilities                               []string `json:"capabilities,omitempty"`
+}
+
+// DiscoverSMARTConfiguration retrieves the SMART on FHIR configuration from the FHIR server's .well-known/smart-configuration endpoint.
+// Its TokenEndpoint can be used to configure ClientCredentials or BackendServices.
+func DiscoverSMARTConfiguration(ctx context.Context, httpClient HttpRequestDoer, fhirBaseURL *url.URL) (*SMARTConfiguration, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	configURL := fhirBaseURL.JoinPath(".well-known", "smart-configuration")
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("SMART configuration request failed (%s): %w", configURL, err)
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SMART configuration request failed (%s, status=%d)", configURL, httpResponse.StatusCode)
+	}
+	var result SMARTConfiguration
+	if err := json.NewDecoder(io.LimitReader(httpResponse.Body, 1024*1024)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("SMART configuration unmarshal failed (%s): %w", configURL, err)
+	}
+	if result.TokenEndpoint == "" {
+		return nil, fmt.Errorf("SMART configuration does not contain a token endpoint (%s)", configURL)
+	}
+	return &result, nil
+}
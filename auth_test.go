@@ -0,0 +1,356 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCredentials_Token(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var capturedForm url.Values
+		var capturedClientID, capturedSecret string
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = r.ParseForm()
+			capturedForm = r.PostForm
+			capturedClientID, capturedSecret, _ = r.BasicAuth()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"token-1","token_type":"bearer","expires_in":300,"scope":"system/*.read"}`))
+		}))
+		defer tokenServer.Close()
+		tokenSource := fhirclient.ClientCredentials{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"system/*.read"},
+		}
+
+		token, err := tokenSource.Token(context.Background())
+
+		require.NoError(t, err)
+		assert.Equal(t, "token-1", token.AccessToken)
+		assert.Equal(t, "system/*.read", token.Scope)
+		assert.False(t, token.ExpiresAt.IsZero())
+		assert.Equal(t, "client_credentials", capturedForm.Get("grant_type"))
+		assert.Equal(t, "system/*.read", capturedForm.Get("scope"))
+		assert.Equal(t, "client", capturedClientID)
+		assert.Equal(t, "secret", capturedSecret)
+	})
+	t.Run("error response", func(t *testing.T) {
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"unknown client"}`))
+		}))
+		defer tokenServer.Close()
+
+		_, err := fhirclient.ClientCredentials{TokenURL: tokenServer.URL}.Token(context.Background())
+
+		assert.EqualError(t, err, fmt.Sprintf("token request failed (%s, status=400): invalid_client unknown client", tokenServer.URL))
+	})
+}
+
+func TestBackendServices_Token(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	for _, tc := range []struct {
+		alg    string
+		key    crypto.Signer
+		verify func(digest []byte, signature []byte) bool
+	}{
+		{
+			alg: "RS384",
+			key: rsaKey,
+			verify: func(digest []byte, signature []byte) bool {
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA384, digest, signature) == nil
+			},
+		},
+		{
+			alg: "ES384",
+			key: ecKey,
+			verify: func(digest []byte, signature []byte) bool {
+				if len(signature) != 96 {
+					return false
+				}
+				r := new(big.Int).SetBytes(signature[:48])
+				s := new(big.Int).SetBytes(signature[48:])
+				return ecdsa.Verify(&ecKey.PublicKey, digest, r, s)
+			},
+		},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			var capturedForm url.Values
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = r.ParseForm()
+				capturedForm = r.PostForm
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"token-1","token_type":"bearer","expires_in":300}`))
+			}))
+			defer tokenServer.Close()
+			tokenSource := fhirclient.BackendServices{
+				TokenURL: tokenServer.URL + "/token",
+				ClientID: "client",
+				Key:      tc.key,
+				KeyID:    "key-1",
+				Scopes:   []string{"system/Patient.read", "system/Task.write"},
+			}
+
+			token, err := tokenSource.Token(context.Background())
+
+			require.NoError(t, err)
+			assert.Equal(t, "token-1", token.AccessToken)
+			assert.Equal(t, "client_credentials", capturedForm.Get("grant_type"))
+			assert.Equal(t, "system/Patient.read system/Task.write", capturedForm.Get("scope"))
+			assert.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", capturedForm.Get("client_assertion_type"))
+			// Verify client assertion
+			parts := strings.Split(capturedForm.Get("client_assertion"), ".")
+			require.Len(t, parts, 3)
+			var header map[string]string
+			headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+			require.NoError(t, json.Unmarshal(headerJSON, &header))
+			assert.Equal(t, tc.alg, header["alg"])
+			assert.Equal(t, "key-1", header["kid"])
+			var claims map[string]interface{}
+			claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+			assert.Equal(t, "client", claims["iss"])
+			assert.Equal(t, "client", claims["sub"])
+			assert.Equal(t, tokenServer.URL+"/token", claims["aud"])
+			assert.NotEmpty(t, claims["jti"])
+			assert.NotEmpty(t, claims["exp"])
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha512.Sum384([]byte(parts[0] + "." + parts[1]))
+			assert.True(t, tc.verify(digest[:], signature), "invalid signature")
+		})
+	}
+	t.Run("ECDSA key not on P-384", func(t *testing.T) {
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, err := fhirclient.BackendServices{Key: ecKey}.Token(context.Background())
+
+		assert.EqualError(t, err, "unable to sign client assertion: unsupported ECDSA curve: P-256 (ES384 requires P-384)")
+	})
+	t.Run("unsupported key type", func(t *testing.T) {
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		_, err := fhirclient.BackendServices{Key: unsupportedSigner{ecKey}}.Token(context.Background())
+
+		assert.EqualError(t, err, "unable to sign client assertion: unsupported key type: string")
+	})
+}
+
+func TestAuthenticatingDoer(t *testing.T) {
+	t.Run("adds token and caches it", func(t *testing.T) {
+		tokenSource := &stubTokenSource{expiresIn: 300}
+		var authorizations []string
+		fhirServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+			_, _ = w.Write([]byte(`{"resourceType":"Resource","id":"123"}`))
+		}))
+		defer fhirServer.Close()
+		fhirBaseURL, _ := url.Parse(fhirServer.URL)
+		client := fhirclient.New(fhirBaseURL, fhirclient.NewAuthenticatingDoer(fhirServer.Client(), tokenSource), nil)
+
+		require.NoError(t, client.Read("Resource/123", new(Resource)))
+		require.NoError(t, client.Read("Resource/123", new(Resource)))
+
+		assert.Equal(t, []string{"Bearer token-1", "Bearer token-1"}, authorizations)
+		assert.Equal(t, int32(1), tokenSource.calls.Load())
+	})
+	t.Run("refreshes token before it expires", func(t *testing.T) {
+		tokenSource := &stubTokenSource{expiresIn: 10}
+		var authorizations []string
+		fhirServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer fhirServer.Close()
+		fhirBaseURL, _ := url.Parse(fhirServer.URL)
+		client := fhirclient.New(fhirBaseURL, fhirclient.NewAuthenticatingDoer(fhirServer.Client(), tokenSource), nil)
+
+		require.NoError(t, client.Delete("Resource/123"))
+		require.NoError(t, client.Delete("Resource/123"))
+
+		assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, authorizations)
+	})
+	t.Run("uses valid token while refreshing", func(t *testing.T) {
+		tokenSource := &blockingTokenSource{unblock: make(chan struct{}), refreshing: make(chan struct{})}
+		var mux sync.Mutex
+		var authorizations []string
+		doer := fhirclient.NewAuthenticatingDoer(handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			mux.Lock()
+			defer mux.Unlock()
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusNoContent)
+		}), tokenSource)
+		client := fhirclient.New(baseURL, doer, nil)
+		// Token-1 expires within the refresh margin, so the next request refreshes it
+		require.NoError(t, client.Delete("Resource/1"))
+		refreshed := make(chan error, 1)
+		go func() {
+			refreshed <- client.Delete("Resource/2")
+		}()
+		<-tokenSource.refreshing
+
+		err := client.Delete("Resource/3")
+
+		require.NoError(t, err)
+		close(tokenSource.unblock)
+		require.NoError(t, <-refreshed)
+		assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"}, authorizations)
+	})
+	t.Run("retries once on 401 with new token", func(t *testing.T) {
+		tokenSource := &stubTokenSource{expiresIn: 300}
+		var authorizations []string
+		var bodies []string
+		fhirServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			if r.Header.Get("Authorization") == "Bearer token-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+			_, _ = w.Write([]byte(`{"resourceType":"Resource","id":"123"}`))
+		}))
+		defer fhirServer.Close()
+		fhirBaseURL, _ := url.Parse(fhirServer.URL)
+		client := fhirclient.New(fhirBaseURL, fhirclient.NewAuthenticatingDoer(fhirServer.Client(), tokenSource), nil)
+		var result Resource
+
+		err := client.Create(Resource{}, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", result.Id)
+		assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, authorizations)
+		require.Len(t, bodies, 2)
+		assert.Equal(t, bodies[0], bodies[1])
+	})
+	t.Run("gives up after second 401", func(t *testing.T) {
+		tokenSource := &stubTokenSource{expiresIn: 300}
+		var calls int
+		fhirServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer fhirServer.Close()
+		fhirBaseURL, _ := url.Parse(fhirServer.URL)
+		client := fhirclient.New(fhirBaseURL, fhirclient.NewAuthenticatingDoer(fhirServer.Client(), tokenSource), nil)
+
+		err := client.Read("Resource/123", new(Resource))
+
+		assert.EqualError(t, err, fmt.Sprintf("FHIR request failed (GET %s/Resource/123, status=401)", fhirServer.URL))
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestDiscoverSMARTConfiguration(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /fhir/.well-known/smart-configuration", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"token_endpoint": "https://auth.example.com/token",
+				"grant_types_supported": ["client_credentials"],
+				"token_endpoint_auth_methods_supported": ["private_key_jwt"],
+				"token_endpoint_auth_signing_alg_values_supported": ["RS384", "ES384"]
+			}`))
+		})
+		httpServer := httptest.NewServer(mux)
+		defer httpServer.Close()
+		fhirBaseURL, _ := url.Parse(httpServer.URL + "/fhir")
+
+		config, err := fhirclient.DiscoverSMARTConfiguration(context.Background(), httpServer.Client(), fhirBaseURL)
+
+		require.NoError(t, err)
+		assert.Equal(t, "https://auth.example.com/token", config.TokenEndpoint)
+		assert.Equal(t, []string{"client_credentials"}, config.GrantTypesSupported)
+		assert.Equal(t, []string{"RS384", "ES384"}, config.TokenEndpointAuthSigningAlgValuesSupported)
+	})
+	t.Run("not found", func(t *testing.T) {
+		httpServer := httptest.NewServer(http.NotFoundHandler())
+		defer httpServer.Close()
+		fhirBaseURL, _ := url.Parse(httpServer.URL)
+
+		_, err := fhirclient.DiscoverSMARTConfiguration(context.Background(), httpServer.Client(), fhirBaseURL)
+
+		assert.EqualError(t, err, fmt.Sprintf("SMART configuration request failed (%s/.well-known/smart-configuration, status=404)", httpServer.URL))
+	})
+}
+
+type stubTokenSource struct {
+	expiresIn int
+	calls     atomic.Int32
+}
+
+func (s *stubTokenSource) Token(_ context.Context) (*fhirclient.Token, error) {
+	n := s.calls.Add(1)
+	return &fhirclient.Token{
+		AccessToken: fmt.Sprintf("token-%d", n),
+		TokenType:   "bearer",
+		ExpiresAt:   time.Now().Add(time.Duration(s.expiresIn) * time.Second),
+	}, nil
+}
+
+// blockingTokenSource returns a token that expires within the refresh margin, and blocks acquiring the next one until unblocked.
+type blockingTokenSource struct {
+	calls      atomic.Int32
+	refreshing chan struct{}
+	unblock    chan struct{}
+}
+
+func (s *blockingTokenSource) Token(_ context.Context) (*fhirclient.Token, error) {
+	n := s.calls.Add(1)
+	if n > 1 {
+		close(s.refreshing)
+		<-s.unblock
+	}
+	return &fhirclient.Token{
+		AccessToken: fmt.Sprintf("token-%d", n),
+		ExpiresAt:   time.Now().Add(10 * time.Second),
+	}, nil
+}
+
+type unsupportedSigner struct {
+	crypto.Signer
+}
+
+func (u unsupportedSigner) Public() crypto.PublicKey {
+	return "not a key"
+}
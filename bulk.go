@@ -0,0 +1,342 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// NdjsonMediaType is the media type of the NDJSON files produced by a bulk data export.
+const NdjsonMediaType = "application/fhir+ndjson"
+
+// DefaultBulkExportPollInterval is the interval at which the status of a bulk data export is polled,
+// if the server doesn't specify one using the Retry-After header.
+const DefaultBulkExportPollInterval = 5 * time.Second
+
+// BulkExportParams contains the (optional) parameters of a bulk data export.
+type BulkExportParams struct {
+	// Types limits the export to the given resource types (_type).
+	Types []string
+	// Since limits the export to resources that have been updated after the given time (_since).
+	Since time.Time
+	// TypeFilters limits the export to resources matching the given search queries, e.g. MedicationRequest?status=active (_typeFilter).
+	TypeFilters []string
+	// OutputFormat is the format of the output files (_outputFormat). If empty, the server's default (NDJSON) is used.
+	OutputFormat string
+}
+
+func (p BulkExportParams) query() url.Values {
+	query := url.Values{}
+	if len(p.Types) > 0 {
+		query.Set("_type", strings.Join(p.Types, ","))
+	}
+	if !p.Since.IsZero() {
+		query.Set("_since", p.Since.Format(time.RFC3339))
+	}
+	for _, typeFilter := range p.TypeFilters {
+		query.Add("_typeFilter", typeFilter)
+	}
+	if p.OutputFormat != "" {
+		query.Set("_outputFormat", p.OutputFormat)
+	}
+	return query
+}
+
+// BulkExportManifest is the manifest of a completed bulk data export, listing the output and error files.
+type BulkExportManifest struct {
+	// TransactionTime is the time at which the export was started by the server.
+	TransactionTime string `json:"transactionTime"`
+	// Request is the URL of the kick-off request.
+	Request string `json:"request"`
+	// RequiresAccessToken indicates whether the files must be downloaded using an access token.
+	RequiresAccessToken bool `json:"requiresAccessToken"`
+	// Output contains the files with the exported resources.
+	Output []BulkExportFile `json:"output"`
+	// Error contains the files with OperationOutcomes describing errors that occurred during the export.
+	Error []BulkExportFile `json:"error"`
+}
+
+// BulkExportFile is a file produced by a bulk data export.
+type BulkExportFile struct {
+	// Type is the resource type of the resources in the file.
+	Type string `json:"type"`
+	// URL is the location of the file.
+	URL string `json:"url"`
+	// Count is the number of resources in the file, if provided by the server.
+	Count int `json:"count,omitempty"`
+	// RequiresAccessToken indicates whether the file must be downloaded using an access token.
+	// It's copied from the manifest (BulkExportManifest.RequiresAccessToken).
+	RequiresAccessToken bool `json:"-"`
+}
+
+// BulkExportStatus is the status of a bulk data export.
+type BulkExportStatus struct {
+	// Completed indicates whether the export has completed. If so, the Manifest is set.
+	Completed bool
+	// Progress is the progress as indicated by the server (X-Progress header), e.g. "50% complete". It may be empty.
+	Progress string
+	// RetryAfter is the time the server asked the client to wait before polling again (Retry-After header). It's zero if not specified.
+	RetryAfter time.Duration
+	// Manifest is the manifest of the completed export.
+	Manifest *BulkExportManifest
+}
+
+// BulkExportJob is a bulk data export that has been kicked off on the FHIR server.
+type BulkExportJob struct {
+	// StatusURL is the URL at which the status of the export can be polled (Content-Location of the kick-off response).
+	StatusURL *url.URL
+	// PollInterval is the interval at which Wait polls the status, if the server doesn't specify one using the Retry-After header.
+	// If zero, DefaultBulkExportPollInterval is used.
+	PollInterval time.Duration
+	client       BaseClient
+}
+
+// BulkExport kicks off a bulk data export ($export) on the FHIR server, and returns the job which can be used to poll its status.
+// The path determines the level of the export: empty for a system-level export, "Patient" for all patients,
+// or "Group/<id>" for the patients in a group.
+func (d BaseClient) BulkExport(ctx context.Context, path string, params BulkExportParams, opts ...Option) (*BulkExportJob, error) {
+	exportPath := "$export"
+	if path = strings.Trim(path, "/"); path != "" {
+		exportPath = path + "/$export"
+	}
+	var headers Headers
+	var statusCode int
+	opts = append(opts, RequestHeaders(http.Header{"Prefer": {"respond-async"}}), ResponseHeaders(&headers), ResponseStatusCode(&statusCode))
+	for key, values := range params.query() {
+		for _, value := range values {
+			opts = append(opts, QueryParam(key, value))
+		}
+	}
+	if err := d.ReadWithContext(ctx, exportPath, nil, opts...); err != nil {
+		return nil, fmt.Errorf("bulk export kick-off failed: %w", err)
+	}
+	if statusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("bulk export kick-off failed: unexpected status code %d (expected 202)", statusCode)
+	}
+	statusURL, err := parseContentLocation(d, headers.Header)
+	if err != nil {
+		return nil, fmt.Errorf("bulk export kick-off failed: %w", err)
+	}
+	return &BulkExportJob{
+		StatusURL: statusURL,
+		client:    d,
+	}, nil
+}
+
+// ResumeBulkExport returns the job for a bulk data export that was kicked off earlier, given its status URL.
+// This allows polling an export after a restart of the application.
+func (d BaseClient) ResumeBulkExport(statusURL *url.URL) *BulkExportJob {
+	return &BulkExportJob{
+		StatusURL: statusURL,
+		client:    d,
+	}
+}
+
+// Status polls the status of the bulk data export once.
+func (j *BulkExportJob) Status(ctx context.Context) (*BulkExportStatus, error) {
+	var data []byte
+	status, err := j.client.pollStatus(ctx, j.StatusURL, &data)
+	if err != nil {
+		return nil, fmt.Errorf("bulk export status request failed: %w", err)
+	}
+	result := &BulkExportStatus{
+		Completed:  status.completed,
+		Progress:   status.progress,
+		RetryAfter: status.retryAfter,
+	}
+	if result.Completed {
+		result.Manifest = new(BulkExportManifest)
+		if err := json.Unmarshal(data, result.Manifest); err != nil {
+			return nil, fmt.Errorf("bulk export manifest unmarshal failed: %w", err)
+		}
+		for _, files := range [][]BulkExportFile{result.Manifest.Output, result.Manifest.Error} {
+			for i := range files {
+				files[i].RequiresAccessToken = result.Manifest.RequiresAccessToken
+			}
+		}
+	}
+	return result, nil
+}
+
+// Wait polls the status of the bulk data export until it has completed, and returns its manifest.
+// It waits as long as the server specifies using the Retry-After header, or PollInterval if the server doesn't specify it.
+// The progress function (if not nil) is called with the progress as indicated by the server, every time the status is polled.
+func (j *BulkExportJob) Wait(ctx context.Context, progress func(progress string)) (*BulkExportManifest, error) {
+	for {
+		status, err := j.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if status.Completed {
+			return status.Manifest, nil
+		}
+		if progress != nil {
+			progress(status.Progress)
+		}
+		delay := status.RetryAfter
+		if delay == 0 {
+			delay = j.PollInterval
+		}
+		if delay == 0 {
+			delay = DefaultBulkExportPollInterval
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Cancel cancels the bulk data export (or deletes its files, if it has already completed) by sending a DELETE request to the status URL.
+func (j *BulkExportJob) Cancel(ctx context.Context) error {
+	if err := j.client.DeleteWithContext(ctx, "", AtUrl(j.StatusURL), withoutAsync()); err != nil {
+		return fmt.Errorf("bulk export cancellation failed: %w", err)
+	}
+	return nil
+}
+
+// Download downloads the given output or error file of the bulk data export.
+// The file is streamed: unlike other requests, its size isn't limited by Config.MaxResponseSize. The caller must close the returned reader.
+// Like other requests, the file URL must be within the base URL hierarchy, unless Config.AllowOutsideBaseURLRequests is set.
+// If the file requires an access token (see BulkExportFile.RequiresAccessToken), it's downloaded using the client's HTTP client,
+// so it's authenticated the same way as FHIR requests. Otherwise, if the client uses an AuthenticatingDoer,
+// it's downloaded using the AuthenticatingDoer's underlying HttpRequestDoer, so no access token is sent.
+// Other HTTP clients are used as-is, so their transport settings (e.g. client certificates or proxy) still apply.
+func (j *BulkExportJob) Download(ctx context.Context, file BulkExportFile) (_ io.ReadCloser, err error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, file.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("bulk export download failed: %w", err)
+	}
+	client := j.client
+	if err := client.checkRequestURL(httpRequest.URL); err != nil {
+		return nil, fmt.Errorf("bulk export download failed: %w", err)
+	}
+	if !file.RequiresAccessToken {
+		client.httpClient = withoutCredentials(client.httpClient)
+	}
+	httpRequest.Header.Set("Accept", NdjsonMediaType)
+	record := client.startRequest(httpRequest)
+	defer func() {
+		if err != nil {
+			client.endRequest(record, err)
+		}
+	}()
+	httpResponse, attempts, err := client.doWithRetry(httpRequest, requestSettings{})
+	record.attempts = attempts
+	if err != nil {
+		return nil, withAttempts(fmt.Errorf("bulk export download failed (%s): %w", file.URL, err), attempts)
+	}
+	record.statusCode = httpResponse.StatusCode
+	if httpResponse.Body == nil {
+		httpResponse.Body = http.NoBody
+	}
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		_ = httpResponse.Body.Close()
+		return nil, withAttempts(fmt.Errorf("bulk export download failed (%s, status=%d)", file.URL, httpResponse.StatusCode), attempts)
+	}
+	// The request completes when the caller has read the file
+	record.responseBody = &bodyRecorder{ReadCloser: httpResponse.Body}
+	return &downloadBody{bodyRecorder: record.responseBody, end: func() {
+		client.endRequest(record, nil)
+	}}, nil
+}
+
+// downloadBody is the body of a downloaded file, which ends the request when it's closed.
+type downloadBody struct {
+	*bodyRecorder
+	once sync.Once
+	end  func()
+}
+
+func (b *downloadBody) Close() error {
+	err := b.bodyRecorder.Close()
+	b.once.Do(b.end)
+	return err
+}
+
+// withoutCredentials returns an HttpRequestDoer that doesn't add an access token to requests, for downloading files that don't require one.
+// Only an AuthenticatingDoer is unwrapped: other doers are returned as-is, to keep their transport settings.
+func withoutCredentials(doer HttpRequestDoer) HttpRequestDoer {
+	if authenticatingDoer, ok := doer.(*AuthenticatingDoer); ok {
+		return authenticatingDoer.doer
+	}
+	return doer
+}
+
+// DownloadResources downloads the given file of a bulk data export, and returns an iterator that yields its resources,
+// decoding them while the file is being downloaded. If an error occurs, it's yielded as the last element of the iterator.
+// Use fhir.OperationOutcome as T to read error files.
+func DownloadResources[T any](ctx context.Context, job *BulkExportJob, file BulkExportFile) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		body, err := job.Download(ctx, file)
+		if err != nil {
+			var zero T
+			yield(zero, err)
+			return
+		}
+		defer body.Close()
+		for resource, err := range ReadNDJSON[T](body) {
+			if !yield(resource, err) {
+				return
+			}
+		}
+	}
+}
+
+// ReadNDJSON returns an iterator that decodes the resources of an NDJSON stream into T, one by one.
+// If an error occurs, it's yielded as the last element of the iterator.
+func ReadNDJSON[T any](r io.Reader) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		decoder := json.NewDecoder(r)
+		for i := 1; ; i++ {
+			var resource T
+			if err := decoder.Decode(&resource); err != nil {
+				if !errors.Is(err, io.EOF) {
+					yield(resource, fmt.Errorf("invalid NDJSON (resource %d): %w", i, err))
+				}
+				return
+			}
+			if !yield(resource, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ExportErrors downloads the error files of a completed bulk data export, and returns the OperationOutcomes they contain.
+func (j *BulkExportJob) ExportErrors(ctx context.Context, manifest BulkExportManifest) ([]fhir.OperationOutcome, error) {
+	var result []fhir.OperationOutcome
+	for _, file := range manifest.Error {
+		for outcome, err := range DownloadResources[fhir.OperationOutcome](ctx, j, file) {
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, outcome)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,258 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestBaseClient_BulkExport(t *testing.T) {
+	baseURL, _ := url.Parse("http://example.com/fhir")
+	response := func(statusCode int, headers http.Header, body string) *http.Response {
+		if headers == nil {
+			headers = http.Header{}
+		}
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     headers,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+	}
+	const manifest = `{
+		"transactionTime": "2024-01-01T12:00:00Z",
+		"request": "http://example.com/fhir/Group/1/$export",
+		"requiresAccessToken": true,
+		"output": [{"type": "Patient", "url": "http://files.example.com/patients.ndjson", "count": 2}],
+		"error": [{"type": "OperationOutcome", "url": "http://files.example.com/errors.ndjson"}]
+	}`
+
+	t.Run("kick-off, poll and download", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				response(http.StatusAccepted, http.Header{"Content-Location": {"http://example.com/fhir/export-status/1"}}, ""),
+				response(http.StatusAccepted, http.Header{"X-Progress": {"50% complete"}, "Retry-After": {"0"}}, ""),
+				response(http.StatusOK, http.Header{"Content-Type": {"application/json"}}, manifest),
+				response(http.StatusOK, nil, `{"resourceType":"Patient","id":"1"}`+"\n"+`{"resourceType":"Patient","id":"2"}`+"\n"),
+				response(http.StatusOK, nil, `{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"processing"}]}`+"\n"),
+			},
+		}
+		config := DefaultConfig()
+		config.AllowOutsideBaseURLRequests = true
+		client := New(baseURL, stub, &config)
+		ctx := context.Background()
+
+		job, err := client.BulkExport(ctx, "Group/1", BulkExportParams{
+			Types: []string{"Patient", "Observation"},
+			Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+		require.NoError(t, err)
+		job.PollInterval = time.Millisecond
+		var progress []string
+		result, err := job.Wait(ctx, func(p string) {
+			progress = append(progress, p)
+		})
+		require.NoError(t, err)
+		var ids []string
+		for patient, err := range DownloadResources[fhir.Patient](ctx, job, result.Output[0]) {
+			require.NoError(t, err)
+			ids = append(ids, *patient.ID)
+		}
+		exportErrors, err := job.ExportErrors(ctx, *result)
+		require.NoError(t, err)
+
+		require.Len(t, stub.requests, 5)
+		kickOff := stub.requests[0]
+		assert.Equal(t, "http://example.com/fhir/Group/1/$export?_since=2024-01-01T00%3A00%3A00Z&_type=Patient%2CObservation", kickOff.URL.String())
+		assert.Equal(t, "respond-async", kickOff.Header.Get("Prefer"))
+		assert.Equal(t, "http://example.com/fhir/export-status/1", job.StatusURL.String())
+		assert.Equal(t, "http://example.com/fhir/export-status/1", stub.requests[1].URL.String())
+		assert.Equal(t, []string{"50% complete"}, progress)
+		assert.Equal(t, "2024-01-01T12:00:00Z", result.TransactionTime)
+		assert.Equal(t, []string{"1", "2"}, ids)
+		assert.Equal(t, NdjsonMediaType, stub.requests[3].Header.Get("Accept"))
+		require.Len(t, exportErrors, 1)
+		assert.Equal(t, fhir.IssueSeverityError, exportErrors[0].Issue[0].Severity)
+	})
+	t.Run("system-level export with relative Content-Location", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				response(http.StatusAccepted, http.Header{"Content-Location": {"export-status/1"}}, ""),
+			},
+		}
+		client := New(baseURL, stub, nil)
+
+		job, err := client.BulkExport(context.Background(), "", BulkExportParams{})
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/$export", stub.requests[0].URL.String())
+		assert.Equal(t, "http://example.com/fhir/export-status/1", job.StatusURL.String())
+	})
+	t.Run("kick-off not accepted", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusOK, nil, "")},
+		}
+		client := New(baseURL, stub, nil)
+
+		_, err := client.BulkExport(context.Background(), "Patient", BulkExportParams{})
+
+		assert.EqualError(t, err, "bulk export kick-off failed: unexpected status code 200 (expected 202)")
+	})
+	t.Run("export failed", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				response(http.StatusInternalServerError, nil, `{"resourceType":"OperationOutcome","issue":[{"severity":"fatal","code":"exception","diagnostics":"out of disk space"}]}`),
+			},
+		}
+		statusURL, _ := url.Parse("http://example.com/fhir/export-status/1")
+		job := New(baseURL, stub, nil).ResumeBulkExport(statusURL)
+
+		_, err := job.Wait(context.Background(), nil)
+
+		require.ErrorContains(t, err, "bulk export status request failed")
+		assert.ErrorAs(t, err, new(OperationOutcomeError))
+	})
+	t.Run("cancel", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusAccepted, nil, "")},
+		}
+		statusURL, _ := url.Parse("http://example.com/fhir/export-status/1")
+		job := New(baseURL, stub, nil).ResumeBulkExport(statusURL)
+
+		err := job.Cancel(context.Background())
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodDelete, stub.requests[0].Method)
+		assert.Equal(t, "http://example.com/fhir/export-status/1", stub.requests[0].URL.String())
+	})
+	t.Run("wait is cancelled", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusAccepted, nil, "")},
+		}
+		statusURL, _ := url.Parse("http://example.com/fhir/export-status/1")
+		job := New(baseURL, stub, nil).ResumeBulkExport(statusURL)
+		job.PollInterval = time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := job.Wait(ctx, nil)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("download failed", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusNotFound, nil, "")},
+		}
+		job := New(baseURL, stub, nil).ResumeBulkExport(nil)
+
+		_, err := job.Download(context.Background(), BulkExportFile{URL: "http://example.com/fhir/files/patients.ndjson", RequiresAccessToken: true})
+
+		assert.EqualError(t, err, "bulk export download failed (http://example.com/fhir/files/patients.ndjson, status=404)")
+	})
+	t.Run("download without access token", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusOK, nil, `{"resourceType":"Patient","id":"1"}`+"\n")},
+		}
+		job := New(baseURL, NewAuthenticatingDoer(stub, staticTokenSource{}), nil).ResumeBulkExport(nil)
+
+		body, err := job.Download(context.Background(), BulkExportFile{URL: "http://example.com/fhir/files/patients.ndjson"})
+
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+		require.Len(t, stub.requests, 1)
+		assert.Empty(t, stub.requests[0].Header.Get("Authorization"))
+	})
+	t.Run("download without access token uses configured HTTP client", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusOK, nil, `{"resourceType":"Patient","id":"1"}`+"\n")},
+		}
+		job := New(baseURL, stub, nil).ResumeBulkExport(nil)
+
+		body, err := job.Download(context.Background(), BulkExportFile{URL: "http://example.com/fhir/files/patients.ndjson"})
+
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("download with access token", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusOK, nil, `{"resourceType":"Patient","id":"1"}`+"\n")},
+		}
+		job := New(baseURL, NewAuthenticatingDoer(stub, staticTokenSource{}), nil).ResumeBulkExport(nil)
+
+		body, err := job.Download(context.Background(), BulkExportFile{URL: "http://example.com/fhir/files/patients.ndjson", RequiresAccessToken: true})
+
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+		require.Len(t, stub.requests, 1)
+		assert.Equal(t, "Bearer secret", stub.requests[0].Header.Get("Authorization"))
+	})
+	t.Run("download outside base URL", func(t *testing.T) {
+		stub := &requestsResponder{}
+		job := New(baseURL, stub, nil).ResumeBulkExport(nil)
+
+		_, err := job.Download(context.Background(), BulkExportFile{URL: "http://attacker.example.com/patients.ndjson", RequiresAccessToken: true})
+
+		assert.EqualError(t, err, "bulk export download failed: FHIR request URL is outside the base URL hierarchy: http://attacker.example.com/patients.ndjson")
+		assert.Empty(t, stub.requests)
+	})
+	t.Run("manifest requiresAccessToken is copied to files", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{response(http.StatusOK, nil, manifest)},
+		}
+		statusURL, _ := url.Parse("http://example.com/fhir/export-status/1")
+		job := New(baseURL, stub, nil).ResumeBulkExport(statusURL)
+
+		status, err := job.Status(context.Background())
+
+		require.NoError(t, err)
+		assert.True(t, status.Manifest.Output[0].RequiresAccessToken)
+		assert.True(t, status.Manifest.Error[0].RequiresAccessToken)
+	})
+}
+
+type staticTokenSource struct{}
+
+func (staticTokenSource) Token(_ context.Context) (*Token, error) {
+	return &Token{AccessToken: "secret", TokenType: "Bearer"}, nil
+}
+
+func TestReadNDJSON(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var ids []string
+		for patient, err := range ReadNDJSON[fhir.Patient](strings.NewReader(`{"resourceType":"Patient","id":"1"}` + "\n\n" + `{"resourceType":"Patient","id":"2"}`)) {
+			require.NoError(t, err)
+			ids = append(ids, *patient.ID)
+		}
+		assert.Equal(t, []string{"1", "2"}, ids)
+	})
+	t.Run("invalid line", func(t *testing.T) {
+		var lastErr error
+		for _, err := range ReadNDJSON[fhir.Patient](strings.NewReader(`{"resourceType":"Patient","id":"1"}` + "\n" + `{invalid`)) {
+			lastErr = err
+		}
+		assert.ErrorContains(t, lastErr, "invalid NDJSON (resource 2)")
+	})
+}
@@ -0,0 +1,438 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the maximum number of entries of the in-memory cache storage used if CacheConfig.Storage is nil.
+const DefaultCacheSize = 1000
+
+// CacheConfig configures caching of read responses, e.g. for reference data (Practitioner, Organization, ValueSet) that's read often.
+// Only reads (including version-specific reads) without query parameters are cached.
+// A cached response is used without contacting the server until its TTL expires, after which it's revalidated using a conditional read
+// (If-None-Match and If-Modified-Since); if the server responds with 304 Not Modified, the cached response is used again for another TTL.
+// Updating, patching or deleting a resource through the same client (directly or in a transaction/batch) removes it from the cache,
+// and prevents the responses of reads of the resource that were in flight at that time from being cached.
+// Since the resources affected by a conditional update, patch or delete aren't known, it causes all cached resources of the type to be revalidated.
+// A request with Cache-Control: no-cache is always revalidated, and a request with Cache-Control: no-store bypasses the cache.
+// Requests with their own Authorization or Accept header bypass the cache, since their responses might differ per principal or format.
+// Responses are cached regardless of the credentials the client's HttpRequestDoer adds, so don't share a CacheStorage between clients
+// authenticating as different principals.
+type CacheConfig struct {
+	// Storage stores the cached responses. If nil, an in-memory LRU cache of DefaultCacheSize entries is used.
+	Storage CacheStorage
+	// TTL contains how long responses are used without revalidation per resource type, e.g. {"Practitioner": time.Hour}.
+	TTL map[string]time.Duration
+	// DefaultTTL applies to resource types not in TTL. If zero, only resource types in TTL are cached.
+	DefaultTTL time.Duration
+}
+
+// CacheEntry is a cached response.
+type CacheEntry struct {
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"storedAt"`
+}
+
+// CacheStorage stores cached responses by key. Implementations must be safe for concurrent use.
+// Since a cache is best-effort, storage failures are treated as cache misses.
+type CacheStorage interface {
+	// Get returns the entry for the given key, or false if there is none.
+	Get(key string) (*CacheEntry, bool)
+	// Set stores the entry for the given key.
+	Set(key string, entry CacheEntry)
+	// Delete removes the entry for the given key, if it exists.
+	Delete(key string)
+}
+
+// responseCache caches responses for a BaseClient. It's shared by copies of the BaseClient.
+type responseCache struct {
+	config  CacheConfig
+	storage CacheStorage
+	mux     sync.Mutex
+	// invalidatedAt contains when the cached resources of a type (or all types, for the empty key) were last invalidated.
+	invalidatedAt map[string]time.Time
+	// reads contains the number of reads in flight per key, of which the responses might be stored.
+	reads map[string]int
+	// readInvalidatedAt contains when the entries of keys with reads in flight were last invalidated.
+	readInvalidatedAt map[string]time.Time
+}
+
+func newResponseCache(config CacheConfig) *responseCache {
+	result := &responseCache{
+		config:            config,
+		storage:           config.Storage,
+		invalidatedAt:     map[string]time.Time{},
+		reads:             map[string]int{},
+		readInvalidatedAt: map[string]time.Time{},
+	}
+	if result.storage == nil {
+		result.storage = NewMemoryCacheStorage(DefaultCacheSize)
+	}
+	return result
+}
+
+// doWithCache sends the request, using the cache for reads if configured. It invalidates cached resources on update, patch and delete.
+// It returns the same as doWithRetry; responses served from the cache take 0 attempts.
+func (d BaseClient) doWithCache(httpRequest *http.Request, settings requestSettings, record *requestRecord) (*http.Response, int, error) {
+	cache := d.cache
+	if cache == nil {
+		return d.doWithRetry(httpRequest, settings)
+	}
+	key := d.cacheKey(httpRequest.URL)
+	switch record.interaction {
+	case "update", "patch", "delete":
+		if record.resourceID == "" {
+			// Conditional interaction: the affected resources aren't known
+			key = ""
+		}
+		// Invalidate regardless of the outcome, since the resource might have been changed anyway
+		defer cache.invalidate(key, record.resourceType)
+		return d.doWithRetry(httpRequest, settings)
+	case "read", "vread":
+		// Responses are cached per client, in the client's format: skip requests that might have a different response,
+		// e.g. with per-request credentials (the client's own credentials are added by its HttpRequestDoer, after the cache).
+		if httpRequest.URL.RawQuery != "" || httpRequest.Header.Get("Authorization") != "" || !slices.Equal(httpRequest.Header.Values("Accept"), []string{d.codec().MediaType()}) {
+			return d.doWithRetry(httpRequest, settings)
+		}
+	default:
+		return d.doWithRetry(httpRequest, settings)
+	}
+	ttl := cache.ttl(record.resourceType)
+	cacheControl := httpRequest.Header.Get("Cache-Control")
+	if ttl <= 0 || strings.Contains(cacheControl, "no-store") {
+		return d.doWithRetry(httpRequest, settings)
+	}
+
+	entry, cached := cache.storage.Get(key)
+	if cached && !strings.Contains(cacheControl, "no-cache") && time.Now().Before(entry.StoredAt.Add(ttl)) && !cache.invalidated(record.resourceType, entry.StoredAt) {
+		return entry.response(httpRequest), 0, nil
+	}
+	// Revalidate the cached entry, unless the caller made the request conditional itself
+	conditional := cached && httpRequest.Header.Get("If-None-Match") == "" && httpRequest.Header.Get("If-Modified-Since") == ""
+	if conditional {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			httpRequest.Header.Set("If-None-Match", etag)
+		} else if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			httpRequest.Header.Set("If-Modified-Since", lastModified)
+		} else {
+			conditional = false
+		}
+	}
+	// The response isn't stored if the resource is invalidated while it's being read, since it might be stale
+	requestedAt := time.Now()
+	defer cache.startRead(key)()
+	httpResponse, attempts, err := d.doWithRetry(httpRequest, settings)
+	if err != nil {
+		return httpResponse, attempts, err
+	}
+	switch {
+	case conditional && httpResponse.StatusCode == http.StatusNotModified:
+		if httpResponse.Body != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(httpResponse.Body, 64*1024))
+			_ = httpResponse.Body.Close()
+		}
+		// A 304 response contains the headers that would have been sent in a 200 response, which might have been updated
+		for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+			if value := httpResponse.Header.Get(name); value != "" {
+				entry.Header.Set(name, value)
+			}
+		}
+		entry.StoredAt = time.Now()
+		cache.store(key, record.resourceType, *entry, requestedAt)
+		return entry.response(httpRequest), attempts, nil
+	case httpResponse.StatusCode == http.StatusOK && !strings.Contains(httpResponse.Header.Get("Cache-Control"), "no-store"):
+		if httpResponse.Body == nil {
+			return httpResponse, attempts, nil
+		}
+		data, err := io.ReadAll(io.LimitReader(httpResponse.Body, int64(d.config.MaxResponseSize+1)))
+		_ = httpResponse.Body.Close()
+		if err != nil {
+			return nil, attempts, err
+		}
+		httpResponse.Body = io.NopCloser(bytes.NewReader(data))
+		if len(data) <= d.config.MaxResponseSize {
+			cache.store(key, record.resourceType, CacheEntry{
+				Header:   httpResponse.Header.Clone(),
+				Body:     data,
+				StoredAt: time.Now(),
+			}, requestedAt)
+		}
+	}
+	return httpResponse, attempts, nil
+}
+
+// ttl returns how long responses for resources of the given type are used without revalidation. Zero means they aren't cached.
+func (c *responseCache) ttl(resourceType string) time.Duration {
+	if ttl, ok := c.config.TTL[resourceType]; ok {
+		return ttl
+	}
+	return c.config.DefaultTTL
+}
+
+// cachesRead returns whether a read of the given path (relative to the base URL, or absolute) is served through the cache.
+func (d BaseClient) cachesRead(path string) bool {
+	if d.cache == nil {
+		return false
+	}
+	path = strings.TrimPrefix(path, d.baseURL.String())
+	if strings.Contains(path, "?") {
+		return false
+	}
+	interaction, resourceType, _ := describeInteraction(http.MethodGet, path)
+	return (interaction == "read" || interaction == "vread") && d.cache.ttl(resourceType) > 0
+}
+
+// invalidate removes the resource at the given path (relative to the base URL, optionally with a query) from the cache.
+func (d BaseClient) invalidate(path string) {
+	if d.cache == nil {
+		return
+	}
+	path, _, _ = strings.Cut(path, "?")
+	_, resourceType, resourceID := describeInteraction(http.MethodDelete, path)
+	key := ""
+	if resourceID != "" {
+		key = d.cacheKey(d.Path(path))
+	}
+	d.cache.invalidate(key, resourceType)
+}
+
+// invalidate removes the entry with the given key from the cache. If the key is empty (e.g. a conditional update of which the
+// affected resource isn't known), all cached resources of the type (or of all types, if empty) are revalidated before they're used again.
+func (c *responseCache) invalidate(key string, resourceType string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if key != "" {
+		c.storage.Delete(key)
+		if c.reads[key] > 0 {
+			c.readInvalidatedAt[key] = time.Now()
+		}
+		return
+	}
+	c.invalidatedAt[resourceType] = time.Now()
+}
+
+// invalidated returns whether an entry of the given resource type, stored at the given time, was invalidated by invalidating its resource type.
+func (c *responseCache) invalidated(resourceType string, storedAt time.Time) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.invalidatedSince(resourceType, storedAt)
+}
+
+// invalidatedSince returns whether the resource type (or all types) was invalidated at or after the given time. The caller must hold the lock.
+func (c *responseCache) invalidatedSince(resourceType string, since time.Time) bool {
+	for _, key := range []string{resourceType, ""} {
+		if invalidatedAt, ok := c.invalidatedAt[key]; ok && !since.After(invalidatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// startRead registers a read of the given key, so that invalidating the key while the read is in flight prevents storing its response.
+// It returns a function that must be called when the read is done.
+func (c *responseCache) startRead(key string) func() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.reads[key]++
+	return func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		c.reads[key]--
+		if c.reads[key] == 0 {
+			delete(c.reads, key)
+			delete(c.readInvalidatedAt, key)
+		}
+	}
+}
+
+// store stores the entry for a read that was sent at the given time,
+// unless the key or resource type was invalidated since then (the response might be stale).
+func (c *responseCache) store(key string, resourceType string, entry CacheEntry, requestedAt time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if invalidatedAt, ok := c.readInvalidatedAt[key]; ok && !requestedAt.After(invalidatedAt) {
+		return
+	}
+	if c.invalidatedSince(resourceType, requestedAt) {
+		return
+	}
+	c.storage.Set(key, entry)
+}
+
+// response returns the cached entry as HTTP response to the given request.
+func (e CacheEntry) response(httpRequest *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       httpRequest,
+	}
+}
+
+// cacheKey returns the cache key for the resource at the given URL: the client's media type (since responses are cached in that format),
+// followed by the URL without query and trailing slash.
+func (d BaseClient) cacheKey(resourceURL *url.URL) string {
+	return d.codec().MediaType() + " " + resourceURL.Scheme + "://" + resourceURL.Host + strings.TrimSuffix(resourceURL.Path, "/")
+}
+
+var _ CacheStorage = &MemoryCacheStorage{}
+
+// MemoryCacheStorage is an in-memory CacheStorage, which evicts the least recently used entries when it's full.
+type MemoryCacheStorage struct {
+	maxEntries int
+	mux        sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// NewMemoryCacheStorage creates an in-memory CacheStorage that holds at most maxEntries entries.
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	return &MemoryCacheStorage{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+func (m *MemoryCacheStorage) Get(key string) (*CacheEntry, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(element)
+	entry := element.Value.(*memoryCacheItem).entry
+	entry.Header = entry.Header.Clone()
+	return &entry, true
+}
+
+func (m *MemoryCacheStorage) Set(key string, entry CacheEntry) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryCacheItem).entry = entry
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (m *MemoryCacheStorage) Delete(key string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if element, ok := m.entries[key]; ok {
+		m.order.Remove(element)
+		delete(m.entries, key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (m *MemoryCacheStorage) Len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.order.Len()
+}
+
+var _ CacheStorage = &FileCacheStorage{}
+
+// FileCacheStorage is a CacheStorage that stores entries as files in a directory, so they survive restarts of the application.
+// Entries aren't evicted; the directory should be cleaned up externally if needed.
+type FileCacheStorage struct {
+	dir string
+}
+
+// NewFileCacheStorage creates a CacheStorage that stores entries in the given directory, which is created if it doesn't exist.
+func NewFileCacheStorage(dir string) (*FileCacheStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileCacheStorage{dir: dir}, nil
+}
+
+func (f *FileCacheStorage) Get(key string) (*CacheEntry, bool) {
+	data, err := os.ReadFile(f.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (f *FileCacheStorage) Set(key string, entry CacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// Write to a temporary file first, so concurrent readers never see a partially written entry
+	file, err := os.CreateTemp(f.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), f.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+}
+
+func (f *FileCacheStorage) Delete(key string) {
+	_ = os.Remove(f.path(key))
+}
+
+func (f *FileCacheStorage) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(hash[:])+".json")
+}
@@ -0,0 +1,341 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// cachingServer is a FHIR server stub that supports conditional reads, and records the requests it receives.
+type cachingServer struct {
+	mux          sync.Mutex
+	requests     []*http.Request
+	etag         string
+	lastModified string
+}
+
+func (s *cachingServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests = append(s.requests, r)
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if s.lastModified != "" {
+		w.Header().Set("Last-Modified", s.lastModified)
+		if r.Header.Get("If-Modified-Since") == s.lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+	switch r.Method {
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		_, _ = w.Write([]byte(`{"resourceType":"Bundle","type":"transaction-response","entry":[{"response":{"status":"200 OK"}}]}`))
+	default:
+		_, _ = w.Write([]byte(`{"resourceType":"Practitioner","id":"1"}`))
+	}
+}
+
+func (s *cachingServer) requestCount() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.requests)
+}
+
+func (s *cachingServer) lastRequest() *http.Request {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+func TestCache(t *testing.T) {
+	setup := func(config fhirclient.CacheConfig) (fhirclient.Client, *cachingServer) {
+		server := &cachingServer{etag: `W/"1"`}
+		return fhirclient.New(baseURL, handlerDoer(server.handle), &fhirclient.Config{Cache: &config}), server
+	}
+	read := func(t *testing.T, client fhirclient.Client, path string, opts ...fhirclient.Option) {
+		var practitioner fhir.Practitioner
+		require.NoError(t, client.Read(path, &practitioner, opts...))
+		require.Equal(t, "1", *practitioner.ID)
+	}
+
+	t.Run("cached response is used within TTL", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{TTL: map[string]time.Duration{"Practitioner": time.Hour}})
+
+		read(t, client, "Practitioner/1")
+		read(t, client, "Practitioner/1")
+
+		assert.Equal(t, 1, server.requestCount())
+		assert.Empty(t, server.lastRequest().Header.Get("Cache-Control"))
+	})
+	t.Run("resource types without TTL aren't cached", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{TTL: map[string]time.Duration{"Organization": time.Hour}})
+
+		read(t, client, "Practitioner/1")
+		read(t, client, "Practitioner/1")
+
+		assert.Equal(t, 2, server.requestCount())
+		assert.Equal(t, "no-cache", server.lastRequest().Header.Get("Cache-Control"))
+	})
+	t.Run("default TTL", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+
+		read(t, client, "Practitioner/1")
+		read(t, client, "Practitioner/1")
+
+		assert.Equal(t, 1, server.requestCount())
+	})
+	t.Run("reads with query parameters aren't cached", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+
+		read(t, client, "Practitioner/1", fhirclient.QueryParam("_elements", "id"))
+		read(t, client, "Practitioner/1", fhirclient.QueryParam("_elements", "id"))
+
+		assert.Equal(t, 2, server.requestCount())
+	})
+	t.Run("expired entry is revalidated using If-None-Match", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: 20 * time.Millisecond})
+		read(t, client, "Practitioner/1")
+		time.Sleep(30 * time.Millisecond)
+
+		read(t, client, "Practitioner/1")
+
+		require.Equal(t, 2, server.requestCount())
+		assert.Equal(t, `W/"1"`, server.lastRequest().Header.Get("If-None-Match"))
+		t.Run("304 refreshes the entry", func(t *testing.T) {
+			read(t, client, "Practitioner/1")
+
+			assert.Equal(t, 2, server.requestCount())
+		})
+	})
+	t.Run("expired entry is revalidated using If-Modified-Since", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: 20 * time.Millisecond})
+		server.etag = ""
+		server.lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+		read(t, client, "Practitioner/1")
+		time.Sleep(30 * time.Millisecond)
+
+		read(t, client, "Practitioner/1")
+
+		require.Equal(t, 2, server.requestCount())
+		assert.Equal(t, server.lastModified, server.lastRequest().Header.Get("If-Modified-Since"))
+	})
+	t.Run("changed resource replaces the entry", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: 20 * time.Millisecond})
+		read(t, client, "Practitioner/1")
+		time.Sleep(30 * time.Millisecond)
+		server.etag = `W/"2"`
+
+		read(t, client, "Practitioner/1")
+		time.Sleep(30 * time.Millisecond)
+		read(t, client, "Practitioner/1")
+
+		require.Equal(t, 3, server.requestCount())
+		assert.Equal(t, `W/"2"`, server.lastRequest().Header.Get("If-None-Match"))
+	})
+	t.Run("Cache-Control", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+		read(t, client, "Practitioner/1")
+
+		read(t, client, "Practitioner/1", fhirclient.RequestHeaders(map[string][]string{"Cache-Control": {"no-cache"}}))
+		assert.Equal(t, 2, server.requestCount())
+		assert.Equal(t, `W/"1"`, server.lastRequest().Header.Get("If-None-Match"))
+
+		read(t, client, "Practitioner/1", fhirclient.RequestHeaders(map[string][]string{"Cache-Control": {"no-store"}}))
+		assert.Equal(t, 3, server.requestCount())
+		assert.Empty(t, server.lastRequest().Header.Get("If-None-Match"))
+	})
+	t.Run("invalidated on update, patch and delete", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+		modifications := map[string]func() error{
+			"update": func() error {
+				return client.Update("Practitioner/1", fhir.Practitioner{ID: ptr("1")}, nil)
+			},
+			"patch": func() error {
+				return client.Patch("Practitioner/1", fhirclient.JSONPatch{{Op: "replace", Path: "/active", Value: true}}, nil)
+			},
+			"delete": func() error {
+				return client.Delete("Practitioner/1")
+			},
+			"transaction": func() error {
+				tx := fhirclient.NewTransaction()
+				tx.Update("Practitioner/1", fhir.Practitioner{ID: ptr("1")})
+				return client.Transaction(tx)
+			},
+		}
+		for name, modify := range modifications {
+			t.Run(name, func(t *testing.T) {
+				read(t, client, "Practitioner/1")
+				count := server.requestCount()
+
+				require.NoError(t, modify())
+				read(t, client, "Practitioner/1")
+
+				assert.Equal(t, count+2, server.requestCount())
+				assert.Empty(t, server.lastRequest().Header.Get("If-None-Match"))
+			})
+		}
+	})
+	t.Run("read in flight during update isn't cached", func(t *testing.T) {
+		server := &cachingServer{etag: `W/"1"`}
+		received := make(chan struct{}, 1)
+		unblock := make(chan struct{})
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && server.requestCount() == 0 {
+				received <- struct{}{}
+				<-unblock
+			}
+			server.handle(w, r)
+		}), &fhirclient.Config{Cache: &fhirclient.CacheConfig{DefaultTTL: time.Hour}})
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Read("Practitioner/1", new(fhir.Practitioner))
+		}()
+		<-received
+
+		require.NoError(t, client.Update("Practitioner/1", fhir.Practitioner{ID: ptr("1")}, nil))
+		close(unblock)
+		require.NoError(t, <-done)
+		read(t, client, "Practitioner/1")
+
+		assert.Equal(t, 3, server.requestCount())
+		assert.Empty(t, server.lastRequest().Header.Get("If-None-Match"))
+	})
+	t.Run("conditional update, patch and delete revalidate the resource type", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+		criteria := fhirclient.SearchCriteria(url.Values{"identifier": {"http://example.com|1"}})
+		modifications := map[string]func() error{
+			"update": func() error {
+				return client.Update("Practitioner", fhir.Practitioner{}, nil, criteria)
+			},
+			"patch": func() error {
+				return client.Patch("Practitioner", fhirclient.JSONPatch{{Op: "replace", Path: "/active", Value: true}}, nil, criteria)
+			},
+			"delete": func() error {
+				return client.Delete("Practitioner", criteria)
+			},
+			"transaction": func() error {
+				tx := fhirclient.NewTransaction()
+				tx.ConditionalDelete("Practitioner", url.Values{"identifier": {"http://example.com|1"}})
+				return client.Transaction(tx)
+			},
+		}
+		for name, modify := range modifications {
+			t.Run(name, func(t *testing.T) {
+				read(t, client, "Practitioner/1")
+				read(t, client, "Organization/1")
+				count := server.requestCount()
+
+				require.NoError(t, modify())
+				read(t, client, "Practitioner/1")
+				read(t, client, "Organization/1")
+
+				assert.Equal(t, count+2, server.requestCount(), "only the Practitioner should be revalidated")
+				assert.Equal(t, `W/"1"`, server.requests[count+1].Header.Get("If-None-Match"))
+				assert.Equal(t, "/fhir/Practitioner/1", server.requests[count+1].URL.Path)
+			})
+		}
+	})
+	t.Run("requests with own Authorization or Accept header aren't cached", func(t *testing.T) {
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour})
+		read(t, client, "Practitioner/1")
+
+		read(t, client, "Practitioner/1", fhirclient.RequestHeaders(http.Header{"Authorization": {"Bearer other-user"}}))
+		read(t, client, "Practitioner/1", fhirclient.RequestHeaders(http.Header{"Accept": {"application/json"}}))
+
+		assert.Equal(t, 3, server.requestCount())
+		assert.Empty(t, server.lastRequest().Header.Get("If-None-Match"))
+	})
+	t.Run("entries are per format", func(t *testing.T) {
+		storage := fhirclient.NewMemoryCacheStorage(10)
+		server := &cachingServer{etag: `W/"1"`}
+		config := fhirclient.CacheConfig{Storage: storage, DefaultTTL: time.Hour}
+		jsonClient := fhirclient.New(baseURL, handlerDoer(server.handle), &fhirclient.Config{Cache: &config})
+		xmlClient := fhirclient.New(baseURL, handlerDoer(server.handle), &fhirclient.Config{Cache: &config, Codec: fhirclient.XMLCodec})
+
+		read(t, jsonClient, "Practitioner/1")
+		read(t, xmlClient, "Practitioner/1")
+
+		assert.Equal(t, 2, server.requestCount())
+		assert.Equal(t, 2, storage.Len())
+	})
+	t.Run("file storage", func(t *testing.T) {
+		storage, err := fhirclient.NewFileCacheStorage(t.TempDir())
+		require.NoError(t, err)
+		client, server := setup(fhirclient.CacheConfig{DefaultTTL: time.Hour, Storage: storage})
+		read(t, client, "Practitioner/1")
+
+		// New client with the same storage, e.g. after a restart
+		otherClient := fhirclient.New(baseURL, handlerDoer(server.handle), &fhirclient.Config{Cache: &fhirclient.CacheConfig{DefaultTTL: time.Hour, Storage: storage}})
+		read(t, otherClient, "Practitioner/1")
+
+		assert.Equal(t, 1, server.requestCount())
+	})
+}
+
+func TestMemoryCacheStorage(t *testing.T) {
+	storage := fhirclient.NewMemoryCacheStorage(2)
+	storage.Set("a", fhirclient.CacheEntry{Body: []byte("a")})
+	storage.Set("b", fhirclient.CacheEntry{Body: []byte("b")})
+	// Use a, so b is the least recently used entry
+	_, _ = storage.Get("a")
+
+	storage.Set("c", fhirclient.CacheEntry{Body: []byte("c")})
+
+	assert.Equal(t, 2, storage.Len())
+	_, ok := storage.Get("b")
+	assert.False(t, ok)
+	entry, ok := storage.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "a", string(entry.Body))
+	storage.Delete("a")
+	_, ok = storage.Get("a")
+	assert.False(t, ok)
+}
+
+func TestFileCacheStorage(t *testing.T) {
+	storage, err := fhirclient.NewFileCacheStorage(t.TempDir())
+	require.NoError(t, err)
+	key := (&url.URL{Scheme: "http", Host: "example.com", Path: "/fhir/Practitioner/1"}).String()
+	storedAt := time.Now().UTC().Truncate(time.Second)
+
+	storage.Set(key, fhirclient.CacheEntry{Header: http.Header{"Etag": {`W/"1"`}}, Body: []byte("{}"), StoredAt: storedAt})
+
+	entry, ok := storage.Get(key)
+	require.True(t, ok)
+	assert.Equal(t, `W/"1"`, entry.Header.Get("ETag"))
+	assert.Equal(t, "{}", string(entry.Body))
+	assert.True(t, storedAt.Equal(entry.StoredAt))
+	storage.Delete(key)
+	_, ok = storage.Get(key)
+	assert.False(t, ok)
+}
@@ -0,0 +1,279 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrNotSupportedByServer is returned when Config.CheckInteractions or Config.CheckSearchParams is enabled,
+// and the FHIR server's CapabilityStatement indicates it doesn't support the requested interaction or search parameter.
+var ErrNotSupportedByServer = errors.New("not supported by FHIR server")
+
+// maxGetSearchQueryLength is the maximum length of an encoded search query for which GET is used,
+// when the search method is selected automatically (Config.AutoSearchMethod).
+// Longer queries use POST, to avoid exceeding URL length limits of servers and proxies.
+const maxGetSearchQueryLength = 2000
+
+// Capabilities describes what a FHIR server supports, as derived from its CapabilityStatement.
+type Capabilities struct {
+	// FhirVersion is the FHIR version the server implements, e.g. 4.0.1.
+	FhirVersion string
+	// Formats are the mime types the server supports, e.g. json or application/fhir+json.
+	Formats []string
+	// Resources contains the capabilities per resource type.
+	Resources map[string]ResourceCapabilities
+	// Interactions are the system-level interactions the server supports, e.g. transaction, batch or search-system.
+	Interactions []string
+	// SearchParams are the search parameters the server supports for all resource types, mapped to their type.
+	SearchParams map[string]string
+	// Operations are the names of the system-level operations the server supports, e.g. validate.
+	Operations []string
+}
+
+// ResourceCapabilities describes what a FHIR server supports for a specific resource type.
+type ResourceCapabilities struct {
+	// Interactions are the interactions the server supports for the resource type, e.g. read, search-type or create.
+	Interactions []string
+	// SearchParams are the search parameters the server supports for the resource type, mapped to their type (e.g. token or reference).
+	SearchParams map[string]string
+	// Operations are the names of the operations the server supports for the resource type, e.g. everything.
+	Operations []string
+}
+
+// SupportsInteraction returns true if the server supports the given interaction (e.g. read or search-type) for the given resource type.
+// If resourceType is empty, it checks system-level interactions (e.g. transaction).
+func (c Capabilities) SupportsInteraction(resourceType string, interaction string) bool {
+	if resourceType == "" {
+		return slices.Contains(c.Interactions, interaction)
+	}
+	resource, ok := c.Resources[resourceType]
+	return ok && slices.Contains(resource.Interactions, interaction)
+}
+
+// SupportsOperation returns true if the server supports the given operation (e.g. everything, without $) for the given resource type,
+// or at system level.
+func (c Capabilities) SupportsOperation(resourceType string, operation string) bool {
+	operation = strings.TrimPrefix(operation, "$")
+	if slices.Contains(c.Operations, operation) {
+		return true
+	}
+	resource, ok := c.Resources[resourceType]
+	return ok && slices.Contains(resource.Operations, operation)
+}
+
+// SupportsSearchParam returns true if the server supports the given search parameter for the given resource type.
+// Modifiers (e.g. name:exact) and chains (e.g. subject.name) are ignored: only the parameter itself is checked.
+// Search result parameters (e.g. _count and _include) and parameters common to all resources (e.g. _id) are always considered supported.
+func (c Capabilities) SupportsSearchParam(resourceType string, name string) bool {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, ":")
+	if slices.Contains(commonSearchParams, name) {
+		return true
+	}
+	if _, ok := c.SearchParams[name]; ok {
+		return true
+	}
+	resource, ok := c.Resources[resourceType]
+	if !ok {
+		return false
+	}
+	_, ok = resource.SearchParams[name]
+	return ok
+}
+
+// commonSearchParams are search parameters that apply to all resources, and search result parameters.
+var commonSearchParams = []string{
+	"_id", "_lastUpdated", "_tag", "_profile", "_security", "_source", "_text", "_content", "_list", "_has", "_type", "_query", "_filter",
+	"_sort", "_count", "_include", "_revinclude", "_summary", "_total", "_elements", "_contained", "_containedType", "_format", "_pretty",
+}
+
+// Capabilities fetches the FHIR server's CapabilityStatement (/metadata) and returns the capabilities derived from it.
+// The result is cached for the lifetime of the client, so only the first call performs an HTTP request.
+func (d BaseClient) Capabilities(ctx context.Context) (*Capabilities, error) {
+	if d.capabilities == nil {
+		return d.fetchCapabilities(ctx)
+	}
+	d.capabilities.mux.Lock()
+	defer d.capabilities.mux.Unlock()
+	if d.capabilities.value != nil {
+		return d.capabilities.value, nil
+	}
+	result, err := d.fetchCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.capabilities.value = result
+	return result, nil
+}
+
+func (d BaseClient) fetchCapabilities(ctx context.Context) (*Capabilities, error) {
+	var data []byte
+	if err := d.ReadWithContext(ctx, "metadata", &data); err != nil {
+		return nil, fmt.Errorf("unable to fetch CapabilityStatement: %w", err)
+	}
+	return parseCapabilities(data)
+}
+
+// parseCapabilities parses a CapabilityStatement. It doesn't use fhir.CapabilityStatement,
+// because that fails on codes it doesn't know (e.g. resource types introduced in later FHIR versions).
+func parseCapabilities(data []byte) (*Capabilities, error) {
+	type searchParam struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	type named struct {
+		Name string `json:"name"`
+	}
+	type interaction struct {
+		Code string `json:"code"`
+	}
+	var statement struct {
+		ResourceType string   `json:"resourceType"`
+		FhirVersion  string   `json:"fhirVersion"`
+		Format       []string `json:"format"`
+		Rest         []struct {
+			Mode     string `json:"mode"`
+			Resource []struct {
+				Type        string        `json:"type"`
+				Interaction []interaction `json:"interaction"`
+				SearchParam []searchParam `json:"searchParam"`
+				Operation   []named       `json:"operation"`
+			} `json:"resource"`
+			Interaction []interaction `json:"interaction"`
+			SearchParam []searchParam `json:"searchParam"`
+			Operation   []named       `json:"operation"`
+		} `json:"rest"`
+	}
+	if err := detectCodec(data).Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("invalid CapabilityStatement: %w", err)
+	}
+	if statement.ResourceType != "CapabilityStatement" {
+		return nil, fmt.Errorf("invalid CapabilityStatement: unexpected resourceType %q", statement.ResourceType)
+	}
+	result := &Capabilities{
+		FhirVersion:  statement.FhirVersion,
+		Formats:      statement.Format,
+		Resources:    map[string]ResourceCapabilities{},
+		SearchParams: map[string]string{},
+	}
+	for _, rest := range statement.Rest {
+		if rest.Mode != "server" {
+			continue
+		}
+		for _, curr := range rest.Interaction {
+			result.Interactions = append(result.Interactions, curr.Code)
+		}
+		for _, curr := range rest.SearchParam {
+			result.SearchParams[curr.Name] = curr.Type
+		}
+		for _, curr := range rest.Operation {
+			result.Operations = append(result.Operations, curr.Name)
+		}
+		for _, resource := range rest.Resource {
+			capabilities := ResourceCapabilities{
+				SearchParams: map[string]string{},
+			}
+			for _, curr := range resource.Interaction {
+				capabilities.Interactions = append(capabilities.Interactions, curr.Code)
+			}
+			for _, curr := range resource.SearchParam {
+				capabilities.SearchParams[curr.Name] = curr.Type
+			}
+			for _, curr := range resource.Operation {
+				capabilities.Operations = append(capabilities.Operations, curr.Name)
+			}
+			result.Resources[resource.Type] = capabilities
+		}
+	}
+	return result, nil
+}
+
+// capabilitiesCache caches the capabilities of the FHIR server. It's shared by copies of the BaseClient.
+type capabilitiesCache struct {
+	mux   sync.Mutex
+	value *Capabilities
+}
+
+// checkInteraction returns an error if interaction checks are enabled, and the server doesn't support the interaction.
+// For search interactions, the search parameters are validated as well if search parameter checks are enabled.
+func (d BaseClient) checkInteraction(ctx context.Context, resourceType string, interaction string, query url.Values) error {
+	checkSearchParams := d.config.CheckSearchParams && interaction == "search-type"
+	if !d.config.CheckInteractions && !checkSearchParams {
+		return nil
+	}
+	capabilities, err := d.Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if d.config.CheckInteractions && !capabilities.SupportsInteraction(resourceType, interaction) {
+		if resourceType == "" {
+			return fmt.Errorf("%s: %w", interaction, ErrNotSupportedByServer)
+		}
+		return fmt.Errorf("%s %s: %w", interaction, resourceType, ErrNotSupportedByServer)
+	}
+	if !checkSearchParams {
+		return nil
+	}
+	if resource := capabilities.Resources[resourceType]; len(resource.SearchParams) == 0 && len(capabilities.SearchParams) == 0 {
+		// Server doesn't declare search parameters, so we can't validate them
+		return nil
+	}
+	var unsupported []string
+	for name := range query {
+		if !capabilities.SupportsSearchParam(resourceType, name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		slices.Sort(unsupported)
+		return fmt.Errorf("search parameter(s) %s for %s: %w", strings.Join(unsupported, ", "), resourceType, ErrNotSupportedByServer)
+	}
+	return nil
+}
+
+// checkPathInteraction checks the interaction for a path, if the path refers to a resource type (e.g. Patient/123).
+// Other paths (e.g. metadata, operations or absolute URLs) are not checked.
+func (d BaseClient) checkPathInteraction(ctx context.Context, path string, interaction string) error {
+	if !d.config.CheckInteractions {
+		return nil
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	resourceType, _, _ := strings.Cut(segments[0], "?")
+	if resourceType == "" || !unicode.IsUpper(rune(resourceType[0])) || strings.Contains(path, "$") || strings.Contains(path, "://") {
+		return nil
+	}
+	if interaction == "read" && len(segments) == 4 && segments[2] == "_history" {
+		interaction = "vread"
+	}
+	return d.checkInteraction(ctx, resourceType, interaction, nil)
+}
+
+// usePostSearch determines whether to search using POST.
+// If the search method is selected automatically, GET is used unless the query is too long for a URL.
+func (d BaseClient) usePostSearch(query url.Values) bool {
+	if d.config.AutoSearchMethod {
+		return len(query.Encode()) > maxGetSearchQueryLength
+	}
+	return d.config.UsePostSearch
+}
@@ -0,0 +1,230 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const capabilityStatementJSON = `{
+	"resourceType": "CapabilityStatement",
+	"fhirVersion": "4.0.1",
+	"format": ["json"],
+	"rest": [{
+		"mode": "server",
+		"interaction": [{"code": "transaction"}],
+		"searchParam": [{"name": "_lastUpdated", "type": "date"}],
+		"operation": [{"name": "validate"}],
+		"resource": [
+			{
+				"type": "Resource",
+				"interaction": [{"code": "read"}, {"code": "search-type"}, {"code": "create"}],
+				"searchParam": [{"name": "identifier", "type": "token"}, {"name": "subject", "type": "reference"}],
+				"operation": [{"name": "everything"}]
+			},
+			{
+				"type": "SomeFutureResource",
+				"interaction": [{"code": "read"}]
+			}
+		]
+	}]
+}`
+
+func capabilityStatementResponse() *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     map[string][]string{"Content-Type": {fhirclient.FhirJsonMediaType}},
+		Body:       io.NopCloser(bytes.NewReader([]byte(capabilityStatementJSON))),
+	}
+}
+
+func TestBaseClient_Capabilities(t *testing.T) {
+	t.Run("fetched once and cached", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{capabilityStatementResponse()},
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		capabilities, err := client.Capabilities(context.Background())
+		require.NoError(t, err)
+		_, err = client.Capabilities(context.Background())
+		require.NoError(t, err)
+
+		require.Len(t, stub.requests, 1)
+		assert.Equal(t, "http://example.com/fhir/metadata", stub.requests[0].URL.String())
+		assert.Equal(t, "4.0.1", capabilities.FhirVersion)
+		assert.True(t, capabilities.SupportsInteraction("Resource", "read"))
+		assert.False(t, capabilities.SupportsInteraction("Resource", "delete"))
+		assert.False(t, capabilities.SupportsInteraction("Patient", "read"))
+		assert.True(t, capabilities.SupportsInteraction("SomeFutureResource", "read"))
+		assert.True(t, capabilities.SupportsInteraction("", "transaction"))
+		assert.False(t, capabilities.SupportsInteraction("", "batch"))
+		assert.True(t, capabilities.SupportsOperation("Resource", "$everything"))
+		assert.True(t, capabilities.SupportsOperation("Patient", "validate"))
+		assert.False(t, capabilities.SupportsOperation("Patient", "everything"))
+		assert.True(t, capabilities.SupportsSearchParam("Resource", "identifier"))
+		assert.True(t, capabilities.SupportsSearchParam("Resource", "identifier:of-type"))
+		assert.True(t, capabilities.SupportsSearchParam("Resource", "subject.name"))
+		assert.True(t, capabilities.SupportsSearchParam("Resource", "_lastUpdated"))
+		assert.True(t, capabilities.SupportsSearchParam("Resource", "_count"))
+		assert.False(t, capabilities.SupportsSearchParam("Resource", "name"))
+	})
+	t.Run("not a CapabilityStatement", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(Resource{Id: "123"}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		_, err := client.Capabilities(context.Background())
+
+		assert.EqualError(t, err, `invalid CapabilityStatement: unexpected resourceType "Resource"`)
+	})
+}
+
+func TestConfig_CheckInteractions(t *testing.T) {
+	newClient := func(responses ...*http.Response) (*fhirclient.BaseClient, *requestsResponder) {
+		stub := &requestsResponder{
+			responses: append([]*http.Response{capabilityStatementResponse()}, responses...),
+		}
+		return fhirclient.New(baseURL, stub, &fhirclient.Config{CheckInteractions: true}), stub
+	}
+	t.Run("supported read", func(t *testing.T) {
+		client, stub := newClient(okResponse(Resource{Id: "123"}))
+
+		err := client.Read("Resource/123", new(Resource))
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 2)
+	})
+	t.Run("unsupported delete", func(t *testing.T) {
+		client, stub := newClient()
+
+		err := client.Delete("Resource/123")
+
+		require.ErrorIs(t, err, fhirclient.ErrNotSupportedByServer)
+		assert.EqualError(t, err, "delete Resource: not supported by FHIR server")
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("unsupported resource type", func(t *testing.T) {
+		client, _ := newClient()
+
+		err := client.Create(map[string]interface{}{"resourceType": "Patient"}, nil)
+
+		require.ErrorIs(t, err, fhirclient.ErrNotSupportedByServer)
+	})
+	t.Run("unsupported system interaction", func(t *testing.T) {
+		client, _ := newClient()
+
+		err := client.Transaction(fhirclient.NewBatch())
+
+		assert.EqualError(t, err, "batch: not supported by FHIR server")
+	})
+	t.Run("paths that don't refer to a resource type aren't checked", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{okResponse(Resource{Id: "123"})},
+		}
+		client := fhirclient.New(baseURL, stub, &fhirclient.Config{CheckInteractions: true})
+
+		err := client.Read("Patient/$everything", new(Resource))
+
+		require.NoError(t, err)
+		require.Len(t, stub.requests, 1)
+		assert.Equal(t, "http://example.com/fhir/Patient/$everything", stub.requests[0].URL.String())
+	})
+	t.Run("search parameters aren't checked", func(t *testing.T) {
+		client, stub := newClient(okResponse(Resource{Id: "123"}))
+
+		err := client.Search("Resource", url.Values{"name": {"John"}}, new(Resource))
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 2)
+	})
+}
+
+func TestConfig_CheckSearchParams(t *testing.T) {
+	newClient := func(responses ...*http.Response) (*fhirclient.BaseClient, *requestsResponder) {
+		stub := &requestsResponder{
+			responses: append([]*http.Response{capabilityStatementResponse()}, responses...),
+		}
+		return fhirclient.New(baseURL, stub, &fhirclient.Config{CheckSearchParams: true}), stub
+	}
+	t.Run("supported search parameters", func(t *testing.T) {
+		client, stub := newClient(okResponse(Resource{Id: "123"}))
+
+		err := client.Search("Resource", url.Values{"identifier": {"sys|123"}, "_count": {"10"}}, new(Resource))
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 2)
+	})
+	t.Run("unsupported search parameters", func(t *testing.T) {
+		client, stub := newClient()
+
+		err := client.Search("Resource", url.Values{"name": {"John"}, "birthdate": {"2000"}, "identifier": {"sys|123"}}, new(Resource))
+
+		assert.EqualError(t, err, "search parameter(s) birthdate, name for Resource: not supported by FHIR server")
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("interactions aren't checked", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{okResponse(Resource{Id: "123"})},
+		}
+		client := fhirclient.New(baseURL, stub, &fhirclient.Config{CheckSearchParams: true})
+
+		err := client.Delete("Resource/123")
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 1)
+	})
+}
+
+func TestConfig_AutoSearchMethod(t *testing.T) {
+	newClient := func() (*fhirclient.BaseClient, *requestsResponder) {
+		stub := &requestsResponder{
+			responses: []*http.Response{okResponse(Resource{Id: "123"})},
+		}
+		return fhirclient.New(baseURL, stub, &fhirclient.Config{AutoSearchMethod: true, UsePostSearch: true}), stub
+	}
+	t.Run("search using GET", func(t *testing.T) {
+		client, stub := newClient()
+
+		err := client.Search("Resource", url.Values{"identifier": {"sys|123"}, "_count": {"10"}}, new(Resource))
+
+		require.NoError(t, err)
+		require.Len(t, stub.requests, 1)
+		assert.Equal(t, http.MethodGet, stub.requests[0].Method)
+		assert.Equal(t, "http://example.com/fhir/Resource?_count=10&identifier=sys%7C123", stub.requests[0].URL.String())
+	})
+	t.Run("search using POST when query is long", func(t *testing.T) {
+		client, stub := newClient()
+
+		err := client.Search("Resource", url.Values{"identifier": {strings.Repeat("a", 3000)}}, new(Resource))
+
+		require.NoError(t, err)
+		require.Len(t, stub.requests, 1)
+		assert.Equal(t, http.MethodPost, stub.requests[0].Method)
+		assert.Equal(t, "http://example.com/fhir/Resource/_search", stub.requests[0].URL.String())
+	})
+}
@@ -26,6 +26,11 @@ import (
 	"slices"
 	"strings"
 	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const FhirJsonMediaType = "application/fhir+json"
@@ -36,6 +41,15 @@ type Client interface {
 	// ReadWithContext reads a resource at the given path from the FHIR server and unmarshals it into the target.
 	// Options can be used to, e.g., add query parameters to the request.
 	ReadWithContext(ctx context.Context, path string, target any, opts ...Option) error
+	// VRead is like VReadWithContext, but uses the default context.
+	VRead(resourceType string, id string, versionID string, target any, opts ...Option) error
+	// VReadWithContext reads a specific version of a resource from the FHIR server and unmarshals it into the target.
+	VReadWithContext(ctx context.Context, resourceType string, id string, versionID string, target any, opts ...Option) error
+	// History is like HistoryWithContext, but uses the default context.
+	History(scope HistoryScope, params HistoryParams, opts ...Option) (*HistoryBundle, error)
+	// HistoryWithContext retrieves the first page of the history of the resources in the given scope (instance, type or system level).
+	// Use Paginate with the Bundle of the result to retrieve the subsequent pages.
+	HistoryWithContext(ctx context.Context, scope HistoryScope, params HistoryParams, opts ...Option) (*HistoryBundle, error)
 	// Search is like SearchWithContext, but uses the default context.
 	Search(resourceType string, query url.Values, target any, opts ...Option) error
 	// SearchWithContext searches for resources by POST on the FHIR server and unmarshals the result into the target.
@@ -51,10 +65,26 @@ type Client interface {
 	// UpdateWithContext updates the resource at the given path on the FHIR server.
 	// The response is unmarshaled into the result.
 	UpdateWithContext(ctx context.Context, path string, resource any, result any, opts ...Option) error
+	// Patch is like PatchWithContext, but uses the default context.
+	Patch(path string, patch any, result any, opts ...Option) error
+	// PatchWithContext patches the resource at the given path on the FHIR server.
+	// The patch can be a JSONPatch (sent as application/json-patch+json), or a FHIRPathPatch or Parameters resource (FHIRPath Patch).
+	// The response is unmarshaled into the result.
+	PatchWithContext(ctx context.Context, path string, patch any, result any, opts ...Option) error
 	// Delete deletes the resource at the given path on the FHIR server.
 	Delete(path string, opts ...Option) error
 	// DeleteWithContext deletes the resource at the given path on the FHIR server.
 	DeleteWithContext(ctx context.Context, path string, opts ...Option) error
+	// Transaction is like TransactionWithContext, but uses the default context.
+	Transaction(transaction *TransactionBuilder, opts ...Option) error
+	// TransactionWithContext executes the given transaction or batch Bundle by POSTing it to the FHIR server's base URL.
+	// The entries of the response Bundle are mapped back to the entries of the transaction.
+	TransactionWithContext(ctx context.Context, transaction *TransactionBuilder, opts ...Option) error
+	// Operation is like OperationWithContext, but uses the default context.
+	Operation(path string, name string, parameters *Parameters, target any, opts ...Option) error
+	// OperationWithContext invokes the operation with the given name at the given path (empty for system level, resource type or Type/id) on the FHIR server,
+	// and unmarshals the result into the target.
+	OperationWithContext(ctx context.Context, path string, name string, parameters *Parameters, target any, opts ...Option) error
 	// Path returns the full URL for the given path.
 	Path(path ...string) *url.URL
 }
@@ -77,16 +107,25 @@ func New(fhirBaseURL *url.URL, httpClient HttpRequestDoer, config *Config) *Base
 	} else {
 		cfg = DefaultConfig()
 	}
-	return &BaseClient{
-		baseURL:    fhirBaseURL,
-		httpClient: httpClient,
-		config:     cfg,
+	result := &BaseClient{
+		baseURL:      fhirBaseURL,
+		httpClient:   httpClient,
+		config:       cfg,
+		capabilities: &capabilitiesCache{},
+	}
+	result.telemetry = newTelemetry(cfg)
+	if cfg.RateLimit != nil {
+		result.rateLimiter = newRateLimiter(*cfg.RateLimit)
 	}
+	if cfg.Cache != nil {
+		result.cache = newResponseCache(*cfg.Cache)
+	}
+	return result
 }
 
 type Config struct {
 	// Non2xxStatusHandler is called when a non-2xx status code is returned by the FHIR server.
-	// Its primary use is logging.
+	// To log requests, use Logging instead, which redacts personal information.
 	Non2xxStatusHandler func(response *http.Response, responseBody []byte)
 	// MaxResponseSize is the maximum size of a response body in bytes that will be read.
 	MaxResponseSize int
@@ -97,6 +136,39 @@ type Config struct {
 	// AllowOutsideBaseURLRequests can be set to allow FHIR requests to URLs outside the hierarchy of the FHIR base URL.
 	// It is disabled by default to prevent SSRF attacks.
 	AllowOutsideBaseURLRequests bool
+	// RetryPolicy configures automatic retries of failed requests (e.g. 429 Too Many Requests or 503 Service Unavailable).
+	// If nil, requests are not retried.
+	RetryPolicy *RetryPolicy
+	// CheckInteractions makes the client use the FHIR server's CapabilityStatement (see BaseClient.Capabilities)
+	// to reject interactions the server doesn't support before sending the request (ErrNotSupportedByServer).
+	CheckInteractions bool
+	// CheckSearchParams makes the client use the FHIR server's CapabilityStatement (see BaseClient.Capabilities)
+	// to reject searches with parameters the server doesn't support before sending the request (ErrNotSupportedByServer).
+	CheckSearchParams bool
+	// AutoSearchMethod makes searches use GET, unless the query is too long for a URL, in which case POST is used (UsePostSearch is then ignored).
+	// Since a CapabilityStatement doesn't specify which search methods the server supports, the method is selected by query length only.
+	AutoSearchMethod bool
+	// AsyncPollInterval is the initial interval at which the status of asynchronous requests (see RespondAsync) is polled,
+	// if the server doesn't specify one using the Retry-After header. If zero, DefaultAsyncPollInterval is used.
+	AsyncPollInterval time.Duration
+	// Codec is the format in which resources are sent to the FHIR server and requested from it, e.g. XMLCodec.
+	// If nil, JSONCodec is used.
+	Codec Codec
+	// RateLimit limits the rate and concurrency of the requests sent to the FHIR server. If nil, requests aren't limited.
+	RateLimit *RateLimit
+	// TracerProvider is used to create a span for every request. If nil, the global OpenTelemetry TracerProvider is used.
+	// Tests can use an in-memory exporter (go.opentelemetry.io/otel/sdk/trace/tracetest) to inspect the spans.
+	TracerProvider trace.TracerProvider
+	// MeterProvider is used to record request duration and size histograms. If nil, the global OpenTelemetry MeterProvider is used.
+	// Tests can use a ManualReader (go.opentelemetry.io/otel/sdk/metric) to inspect the metrics.
+	MeterProvider metric.MeterProvider
+	// Propagator injects the trace context into request headers. If nil, the global OpenTelemetry TextMapPropagator is used.
+	Propagator propagation.TextMapPropagator
+	// Cache enables caching of read responses. If nil, responses aren't cached.
+	Cache *CacheConfig
+	// Logging enables logging of the requests sent to the FHIR server, with redaction of personal information.
+	// If nil, requests aren't logged.
+	Logging *LoggingConfig
 }
 
 func DefaultConfig() Config {
@@ -112,9 +184,13 @@ var _ Client = &BaseClient{}
 
 // BaseClient is a basic FHIR client that can read, create and update resources.
 type BaseClient struct {
-	baseURL    *url.URL
-	httpClient HttpRequestDoer
-	config     Config
+	baseURL      *url.URL
+	httpClient   HttpRequestDoer
+	config       Config
+	capabilities *capabilitiesCache
+	rateLimiter  *rateLimiter
+	telemetry    *telemetry
+	cache        *responseCache
 }
 
 func (d BaseClient) Path(path ...string) *url.URL {
@@ -123,6 +199,9 @@ func (d BaseClient) Path(path ...string) *url.URL {
 
 func (d BaseClient) ReadWithContext(ctx context.Context, path string, target any, opts ...Option) error {
 	opts = append(d.config.DefaultOptions, opts...)
+	if err := d.checkPathInteraction(ctx, path, "read"); err != nil {
+		return err
+	}
 	absUrl, _ := url.Parse(path)
 	if absUrl.IsAbs() {
 		opts = append([]Option{AtUrl(absUrl)}, opts...)
@@ -133,7 +212,10 @@ func (d BaseClient) ReadWithContext(ctx context.Context, path string, target any
 	if err != nil {
 		return err
 	}
-	setHeaderValueIfNotPresent(&httpRequest.Header, "Cache-Control", "no-cache")
+	if !d.cachesRead(path) {
+		// Make sure intermediate caches don't return stale resources; the client's own cache revalidates itself
+		setHeaderValueIfNotPresent(&httpRequest.Header, "Cache-Control", "no-cache")
+	}
 	return d.doRequest(httpRequest, target, opts...)
 }
 
@@ -143,15 +225,22 @@ func (d BaseClient) Read(path string, target any, opts ...Option) error {
 
 func (d BaseClient) SearchWithContext(ctx context.Context, resourceType string, query url.Values, target any, opts ...Option) error {
 	opts = append(d.config.DefaultOptions, opts...)
+	if resourceType != "" {
+		if err := d.checkInteraction(ctx, resourceType, "search-type", query); err != nil {
+			return err
+		}
+	}
 	var httpRequest *http.Request
 	var err error
-	if d.config.UsePostSearch {
+	if d.usePostSearch(query) {
 		opts = append([]Option{AtPath(resourceType + "/_search")}, opts...)
 		httpRequest, err = http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL.String(), strings.NewReader(query.Encode()))
 		if err != nil {
 			return err
 		}
 		httpRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// Searching using POST doesn't have side effects, so it's safe to retry
+		opts = append(opts, AllowRetry())
 	} else {
 		opts = append([]Option{AtPath(resourceType)}, opts...)
 		searchURL := *d.baseURL
@@ -180,13 +269,20 @@ func (d BaseClient) CreateWithContext(ctx context.Context, resource any, result
 	if err != nil {
 		return err
 	}
+	if err := d.checkInteraction(ctx, desc.Type, "create", nil); err != nil {
+		return err
+	}
+	data, err := d.codec().Marshal(json.RawMessage(desc.Data))
+	if err != nil {
+		return err
+	}
 	opts = append([]Option{AtPath(desc.Type)}, opts...)
-	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL.String(), io.NopCloser(bytes.NewReader(desc.Data)))
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL.String(), io.NopCloser(bytes.NewReader(data)))
 	if err != nil {
 		return err
 	}
 
-	httpRequest.Header.Set("Content-Type", FhirJsonMediaType)
+	httpRequest.Header.Set("Content-Type", d.codec().MediaType())
 	return d.doRequest(httpRequest, result, opts...)
 }
 
@@ -196,7 +292,10 @@ func (d BaseClient) Create(resource any, result any, opts ...Option) error {
 
 func (d BaseClient) UpdateWithContext(ctx context.Context, path string, resource any, result any, opts ...Option) error {
 	opts = append(d.config.DefaultOptions, opts...)
-	data, err := json.Marshal(resource)
+	if err := d.checkPathInteraction(ctx, path, "update"); err != nil {
+		return err
+	}
+	data, err := d.codec().Marshal(resource)
 	if err != nil {
 		return err
 	}
@@ -205,7 +304,7 @@ func (d BaseClient) UpdateWithContext(ctx context.Context, path string, resource
 	if err != nil {
 		return err
 	}
-	httpRequest.Header.Set("Content-Type", FhirJsonMediaType)
+	httpRequest.Header.Set("Content-Type", d.codec().MediaType())
 	return d.doRequest(httpRequest, result, opts...)
 }
 
@@ -213,12 +312,69 @@ func (d BaseClient) Update(path string, resource any, result any, opts ...Option
 	return d.UpdateWithContext(context.Background(), path, resource, result, opts...)
 }
 
+func (d BaseClient) PatchWithContext(ctx context.Context, path string, patch any, result any, opts ...Option) error {
+	opts = append(d.config.DefaultOptions, opts...)
+	if err := d.checkPathInteraction(ctx, path, "patch"); err != nil {
+		return err
+	}
+	data, mediaType, err := marshalPatch(patch, d.codec())
+	if err != nil {
+		return err
+	}
+	opts = append([]Option{AtPath(path)}, opts...)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPatch, d.baseURL.String(), io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", mediaType)
+	return d.doRequest(httpRequest, result, opts...)
+}
+
+func (d BaseClient) Patch(path string, patch any, result any, opts ...Option) error {
+	return d.PatchWithContext(context.Background(), path, patch, result, opts...)
+}
+
+// UpdateIfUnchanged is like UpdateIfUnchangedWithContext, but uses the default context.
+func (d BaseClient) UpdateIfUnchanged(resource any, result any, opts ...Option) error {
+	return d.UpdateIfUnchangedWithContext(context.Background(), resource, result, opts...)
+}
+
+// UpdateIfUnchangedWithContext updates the resource on the FHIR server, but only if it hasn't been changed since it was read (optimistic locking).
+// The path is derived from the resource's resourceType and id, and the If-Match header from its meta.versionId.
+// If the resource has been changed on the FHIR server in the meantime, a VersionConflictError is returned.
+func (d BaseClient) UpdateIfUnchangedWithContext(ctx context.Context, resource any, result any, opts ...Option) error {
+	desc, err := DescribeResource(resource)
+	if err != nil {
+		return err
+	}
+	var version struct {
+		ID   string `json:"id"`
+		Meta struct {
+			VersionID string `json:"versionId"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(desc.Data, &version); err != nil {
+		return fmt.Errorf("invalid resource of type %T: %w", resource, err)
+	}
+	if version.ID == "" {
+		return fmt.Errorf("id not present in resource of type %T", resource)
+	}
+	if version.Meta.VersionID == "" {
+		return fmt.Errorf("meta.versionId not present in resource of type %T", resource)
+	}
+	opts = append([]Option{IfMatch(VersionETag(version.Meta.VersionID))}, opts...)
+	return d.UpdateWithContext(ctx, desc.Type+"/"+version.ID, json.RawMessage(desc.Data), result, opts...)
+}
+
 func (d BaseClient) Delete(path string, opts ...Option) error {
 	return d.DeleteWithContext(context.Background(), path, opts...)
 }
 
 func (d BaseClient) DeleteWithContext(ctx context.Context, path string, opts ...Option) error {
 	opts = append(d.config.DefaultOptions, opts...)
+	if err := d.checkPathInteraction(ctx, path, "delete"); err != nil {
+		return err
+	}
 	opts = append([]Option{AtPath(path)}, opts...)
 	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodDelete, d.baseURL.String(), nil)
 	if err != nil {
@@ -227,14 +383,76 @@ func (d BaseClient) DeleteWithContext(ctx context.Context, path string, opts ...
 	return d.doRequest(httpRequest, nil, opts...)
 }
 
-func (d BaseClient) doRequest(httpRequest *http.Request, target any, opts ...Option) error {
-	addHeaderValueIfNotPresent(&httpRequest.Header, "Accept", FhirJsonMediaType)
+func (d BaseClient) Transaction(transaction *TransactionBuilder, opts ...Option) error {
+	return d.TransactionWithContext(context.Background(), transaction, opts...)
+}
+
+func (d BaseClient) TransactionWithContext(ctx context.Context, transaction *TransactionBuilder, opts ...Option) error {
+	opts = append(d.config.DefaultOptions, opts...)
+	bundle, err := transaction.Bundle()
+	if err != nil {
+		return err
+	}
+	for i, entry := range bundle.Entry {
+		if entry.FullUrl == nil && entry.Request.Method == fhir.HTTPVerbPUT && !strings.Contains(entry.Request.Url, "?") {
+			// The fullUrl of an updated resource is its absolute URL
+			fullUrl := d.Path(entry.Request.Url).String()
+			bundle.Entry[i].FullUrl = &fullUrl
+		}
+	}
+	if err := d.checkInteraction(ctx, "", bundle.Type.Code(), nil); err != nil {
+		return err
+	}
+	data, err := d.codec().Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL.String(), io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", d.codec().MediaType())
+	var response fhir.Bundle
+	err = d.doRequest(httpRequest, &response, opts...)
+	for _, entry := range bundle.Entry {
+		if entry.Request == nil {
+			continue
+		}
+		switch entry.Request.Method {
+		case fhir.HTTPVerbPUT, fhir.HTTPVerbPATCH, fhir.HTTPVerbDELETE:
+			d.invalidate(entry.Request.Url)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return transaction.mapResponse(response)
+}
+
+// checkRequestURL prevents SSRF attacks by ensuring that the request URL is within the base URL hierarchy,
+// unless Config.AllowOutsideBaseURLRequests is set.
+func (d BaseClient) checkRequestURL(requestURL *url.URL) error {
+	if !d.config.AllowOutsideBaseURLRequests && !strings.HasPrefix(requestURL.String(), d.baseURL.String()) {
+		return fmt.Errorf("FHIR request URL is outside the base URL hierarchy: %s", requestURL.String())
+	}
+	return nil
+}
+
+func (d BaseClient) doRequest(httpRequest *http.Request, target any, opts ...Option) (err error) {
+	addHeaderValueIfNotPresent(&httpRequest.Header, "Accept", d.codec().MediaType())
+	var settings requestSettings
 	// Execute pre-request options
 	for _, opt := range opts {
-		if fn, ok := opt.(PreRequestOption); ok {
+		switch fn := opt.(type) {
+		case PreRequestOption:
 			fn(d, httpRequest)
+		case requestOption:
+			fn(&settings)
 		}
 	}
+	if settings.async {
+		addHeaderValueIfNotPresent(&httpRequest.Header, "Prefer", "respond-async")
+	}
 	// recreate HTTP request in case URL, body or method was edited by one of the options
 	newHttpRequest, err := http.NewRequestWithContext(httpRequest.Context(), httpRequest.Method, httpRequest.URL.String(), httpRequest.Body)
 	if err != nil {
@@ -243,16 +461,23 @@ func (d BaseClient) doRequest(httpRequest *http.Request, target any, opts ...Opt
 	newHttpRequest.Header = httpRequest.Header
 	*httpRequest = *newHttpRequest
 
-	if !d.config.AllowOutsideBaseURLRequests {
-		// Prevent SSRF attacks by ensuring that the request URL is within the base URL hierarchy.
-		if !strings.HasPrefix(httpRequest.URL.String(), d.baseURL.String()) {
-			return fmt.Errorf("FHIR request URL is outside the base URL hierarchy: %s", httpRequest.URL.String())
-		}
+	if err := d.checkRequestURL(httpRequest.URL); err != nil {
+		return err
 	}
 
-	httpResponse, err := d.httpClient.Do(httpRequest)
+	record := d.startRequest(httpRequest)
+	defer func() {
+		d.endRequest(record, err)
+	}()
+	httpResponse, attempts, err := d.doWithCache(httpRequest, settings, record)
+	record.attempts = attempts
 	if err != nil {
-		return fmt.Errorf("FHIR request failed (%s %s): %w", httpRequest.Method, httpRequest.URL.String(), err)
+		return withAttempts(fmt.Errorf("FHIR request failed (%s %s): %w", httpRequest.Method, httpRequest.URL.String(), err), attempts)
+	}
+	record.statusCode = httpResponse.StatusCode
+	if httpResponse.Body != nil {
+		// Close the body before any early return, since it holds the request's in-flight slot (if rate limited)
+		defer httpResponse.Body.Close()
 	}
 	for _, opt := range opts {
 		if fn, ok := opt.(PostRequestOption); ok {
@@ -263,20 +488,23 @@ func (d BaseClient) doRequest(httpRequest *http.Request, target any, opts ...Opt
 	}
 	var data []byte
 	if httpResponse.Body != nil {
-		defer httpResponse.Body.Close()
 		data, err = io.ReadAll(io.LimitReader(httpResponse.Body, int64(d.config.MaxResponseSize+1)))
+		// Release the in-flight slot (if rate limited) before handling the response,
+		// since asynchronous status polls and post-parse options (e.g. ResolveRef) send requests of their own.
+		_ = httpResponse.Body.Close()
 		if err != nil {
 			return fmt.Errorf("FHIR response read failed (%s %s): %w", httpRequest.Method, httpRequest.URL.String(), err)
 		}
 	}
+	record.response = data
 	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
 		if d.config.Non2xxStatusHandler != nil {
 			d.config.Non2xxStatusHandler(httpResponse, data)
 		}
-		if err = checkForOperationOutcomeError(data, true, httpResponse.StatusCode); err != nil {
-			return err
+		if err = checkForOperationOutcomeError(data, true, httpResponse.StatusCode); err == nil {
+			err = fmt.Errorf("FHIR request failed (%s %s, status=%d)", httpRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode)
 		}
-		return fmt.Errorf("FHIR request failed (%s %s, status=%d)", httpRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode)
+		return withAttempts(classifyStatusError(httpRequest, httpResponse.StatusCode, err), attempts)
 	}
 	if len(data) > d.config.MaxResponseSize {
 		return fmt.Errorf("FHIR response exceeds max. safety limit of %d bytes (%s %s, status=%d)", d.config.MaxResponseSize, httpRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode)
@@ -284,12 +512,16 @@ func (d BaseClient) doRequest(httpRequest *http.Request, target any, opts ...Opt
 	if err = checkForOperationOutcomeError(data, false, httpResponse.StatusCode); err != nil {
 		return err
 	}
-	if target != nil {
+	if settings.async && httpResponse.StatusCode == http.StatusAccepted {
+		if err := d.awaitAsync(httpRequest, httpResponse, target, settings); err != nil {
+			return err
+		}
+	} else if target != nil {
 		switch target.(type) {
 		case *[]byte:
 			*target.(*[]byte) = data
 		default:
-			err = json.Unmarshal(data, target)
+			err = detectCodec(data).Unmarshal(data, target)
 			if err != nil {
 				return fmt.Errorf("FHIR response unmarshal failed (%s %s, status=%d): %w", httpRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode, err)
 			}
@@ -339,6 +571,19 @@ type ResourceDescription struct {
 
 type Option any
 
+// requestOption is an option that configures how the client executes the request, rather than the request itself.
+type requestOption func(s *requestSettings)
+
+// requestSettings contains the settings for executing a request, as configured by requestOptions.
+type requestSettings struct {
+	allowRetry  bool
+	async       bool
+	asyncNoWait bool
+	asyncHandle *AsyncHandle
+	// operationGET is only used by OperationWithContext
+	operationGET bool
+}
+
 // PreRequestOption is an option that processes the HTTP request before it is sent.
 type PreRequestOption func(client Client, r *http.Request)
 
@@ -398,7 +643,9 @@ func ResponseHeaders(headers *Headers) PostRequestOption {
 	return func(_ Client, r *http.Response) error {
 		var result Headers
 		result.Header = r.Header
-		if len(r.Header["ETag"]) > 0 {
+		if etag := r.Header.Get("ETag"); etag != "" {
+			result.ETag = etag
+		} else if len(r.Header["ETag"]) > 0 {
 			result.ETag = r.Header["ETag"][0]
 		}
 		result.ContentType = r.Header.Get("Content-Type")
@@ -119,6 +119,122 @@ func (mr *MockClientMockRecorder) DeleteWithContext(ctx, path any, opts ...any)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWithContext", reflect.TypeOf((*MockClient)(nil).DeleteWithContext), varargs...)
 }
 
+// History mocks base method.
+func (m *MockClient) History(scope fhirclient.HistoryScope, params fhirclient.HistoryParams, opts ...fhirclient.Option) (*fhirclient.HistoryBundle, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{scope, params}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "History", varargs...)
+	ret0, _ := ret[0].(*fhirclient.HistoryBundle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockClientMockRecorder) History(scope, params any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{scope, params}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockClient)(nil).History), varargs...)
+}
+
+// HistoryWithContext mocks base method.
+func (m *MockClient) HistoryWithContext(ctx context.Context, scope fhirclient.HistoryScope, params fhirclient.HistoryParams, opts ...fhirclient.Option) (*fhirclient.HistoryBundle, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, scope, params}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HistoryWithContext", varargs...)
+	ret0, _ := ret[0].(*fhirclient.HistoryBundle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HistoryWithContext indicates an expected call of HistoryWithContext.
+func (mr *MockClientMockRecorder) HistoryWithContext(ctx, scope, params any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, scope, params}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HistoryWithContext", reflect.TypeOf((*MockClient)(nil).HistoryWithContext), varargs...)
+}
+
+// Operation mocks base method.
+func (m *MockClient) Operation(path, name string, parameters *fhirclient.Parameters, target any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{path, name, parameters, target}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Operation", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Operation indicates an expected call of Operation.
+func (mr *MockClientMockRecorder) Operation(path, name, parameters, target any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{path, name, parameters, target}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Operation", reflect.TypeOf((*MockClient)(nil).Operation), varargs...)
+}
+
+// OperationWithContext mocks base method.
+func (m *MockClient) OperationWithContext(ctx context.Context, path, name string, parameters *fhirclient.Parameters, target any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, path, name, parameters, target}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "OperationWithContext", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OperationWithContext indicates an expected call of OperationWithContext.
+func (mr *MockClientMockRecorder) OperationWithContext(ctx, path, name, parameters, target any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, path, name, parameters, target}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OperationWithContext", reflect.TypeOf((*MockClient)(nil).OperationWithContext), varargs...)
+}
+
+// Patch mocks base method.
+func (m *MockClient) Patch(path string, patch, result any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{path, patch, result}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Patch", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Patch indicates an expected call of Patch.
+func (mr *MockClientMockRecorder) Patch(path, patch, result any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{path, patch, result}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockClient)(nil).Patch), varargs...)
+}
+
+// PatchWithContext mocks base method.
+func (m *MockClient) PatchWithContext(ctx context.Context, path string, patch, result any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, path, patch, result}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PatchWithContext", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PatchWithContext indicates an expected call of PatchWithContext.
+func (mr *MockClientMockRecorder) PatchWithContext(ctx, path, patch, result any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, path, patch, result}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchWithContext", reflect.TypeOf((*MockClient)(nil).PatchWithContext), varargs...)
+}
+
 // Path mocks base method.
 func (m *MockClient) Path(path ...string) *url.URL {
 	m.ctrl.T.Helper()
@@ -213,6 +329,44 @@ func (mr *MockClientMockRecorder) SearchWithContext(ctx, resourceType, query, ta
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchWithContext", reflect.TypeOf((*MockClient)(nil).SearchWithContext), varargs...)
 }
 
+// Transaction mocks base method.
+func (m *MockClient) Transaction(transaction *fhirclient.TransactionBuilder, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{transaction}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Transaction", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Transaction indicates an expected call of Transaction.
+func (mr *MockClientMockRecorder) Transaction(transaction any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{transaction}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transaction", reflect.TypeOf((*MockClient)(nil).Transaction), varargs...)
+}
+
+// TransactionWithContext mocks base method.
+func (m *MockClient) TransactionWithContext(ctx context.Context, transaction *fhirclient.TransactionBuilder, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, transaction}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "TransactionWithContext", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TransactionWithContext indicates an expected call of TransactionWithContext.
+func (mr *MockClientMockRecorder) TransactionWithContext(ctx, transaction any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, transaction}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransactionWithContext", reflect.TypeOf((*MockClient)(nil).TransactionWithContext), varargs...)
+}
+
 // Update mocks base method.
 func (m *MockClient) Update(path string, resource, result any, opts ...fhirclient.Option) error {
 	m.ctrl.T.Helper()
@@ -251,6 +405,44 @@ func (mr *MockClientMockRecorder) UpdateWithContext(ctx, path, resource, result
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateWithContext", reflect.TypeOf((*MockClient)(nil).UpdateWithContext), varargs...)
 }
 
+// VRead mocks base method.
+func (m *MockClient) VRead(resourceType, id, versionID string, target any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{resourceType, id, versionID, target}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "VRead", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VRead indicates an expected call of VRead.
+func (mr *MockClientMockRecorder) VRead(resourceType, id, versionID, target any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{resourceType, id, versionID, target}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VRead", reflect.TypeOf((*MockClient)(nil).VRead), varargs...)
+}
+
+// VReadWithContext mocks base method.
+func (m *MockClient) VReadWithContext(ctx context.Context, resourceType, id, versionID string, target any, opts ...fhirclient.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, resourceType, id, versionID, target}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "VReadWithContext", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VReadWithContext indicates an expected call of VReadWithContext.
+func (mr *MockClientMockRecorder) VReadWithContext(ctx, resourceType, id, versionID, target any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, resourceType, id, versionID, target}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VReadWithContext", reflect.TypeOf((*MockClient)(nil).VReadWithContext), varargs...)
+}
+
 // MockHttpRequestDoer is a mock of HttpRequestDoer interface.
 type MockHttpRequestDoer struct {
 	ctrl     *gomock.Controller
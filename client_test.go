@@ -475,6 +475,16 @@ func TestResponseHeaders(t *testing.T) {
 		assert.Equal(t, "2020-01-02 15:04:05 +0000 UTC", actual.LastModified.String())
 		assert.Equal(t, "123456789", actual.ETag)
 	})
+	t.Run("canonical ETag header", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("ETag", `W/"2"`)
+		var actual fhirclient.Headers
+
+		err := fhirclient.ResponseHeaders(&actual)(nil, &http.Response{Header: header})
+
+		require.NoError(t, err)
+		assert.Equal(t, `W/"2"`, actual.ETag)
+	})
 }
 
 var _ json.Marshaler = &Resource{}
@@ -0,0 +1,93 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const FhirXmlMediaType = "application/fhir+xml"
+
+// Codec encodes and decodes FHIR resources in a specific format (e.g. JSON or XML).
+// The client uses it to encode request bodies, and sets the Accept header to its media type.
+// Responses are decoded in the format they were returned in, regardless of the configured codec.
+type Codec interface {
+	// MediaType returns the media type of the format, e.g. application/fhir+json.
+	MediaType() string
+	// Marshal encodes the given resource. Besides FHIR resource models, it accepts resources in JSON ([]byte or json.RawMessage).
+	Marshal(resource any) ([]byte, error)
+	// Unmarshal decodes the given data into the target.
+	Unmarshal(data []byte, target any) error
+}
+
+var (
+	// JSONCodec encodes and decodes FHIR resources as JSON (application/fhir+json). It's the default codec.
+	JSONCodec Codec = jsonCodec{}
+	// XMLCodec encodes and decodes FHIR resources as XML (application/fhir+xml).
+	// It converts from and to the same Go models as JSONCodec, by mapping the XML representation to the JSON representation.
+	XMLCodec Codec = xmlCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string {
+	return FhirJsonMediaType
+}
+
+func (jsonCodec) Marshal(resource any) ([]byte, error) {
+	switch r := resource.(type) {
+	case []byte:
+		return r, nil
+	case json.RawMessage:
+		return r, nil
+	}
+	return json.Marshal(resource)
+}
+
+func (jsonCodec) Unmarshal(data []byte, target any) error {
+	return json.Unmarshal(data, target)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) MediaType() string {
+	return FhirXmlMediaType
+}
+
+func (xmlCodec) Marshal(resource any) ([]byte, error) {
+	return marshalXML(resource)
+}
+
+func (xmlCodec) Unmarshal(data []byte, target any) error {
+	return unmarshalXML(data, target)
+}
+
+// codec returns the codec configured for the client, or JSONCodec if none is configured.
+func (d BaseClient) codec() Codec {
+	if d.config.Codec != nil {
+		return d.config.Codec
+	}
+	return JSONCodec
+}
+
+// detectCodec returns the codec for the format the data is in: XMLCodec if it looks like XML, JSONCodec otherwise.
+func detectCodec(data []byte) Codec {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '<' {
+		return XMLCodec
+	}
+	return JSONCodec
+}
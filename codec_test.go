@@ -0,0 +1,177 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+const patientXML = `<Patient xmlns="http://hl7.org/fhir">` +
+	`<id value="123"/>` +
+	`<text><status value="generated"/><div xmlns="http://www.w3.org/1999/xhtml"><p>John <b>Doe</b></p></div></text>` +
+	`<extension url="http://example.com/ext"><valueString value="foo &amp; bar"/></extension>` +
+	`<identifier><system value="http://example.com/mrn"/><value value="1"/></identifier>` +
+	`<active value="true"/>` +
+	`<name id="n1"><family value="Doe"/><given value="John"/><given value="Jack"/></name>` +
+	`<gender value="male"/>` +
+	`<multipleBirthInteger value="2"/>` +
+	`</Patient>`
+
+func xmlResponse(statusCode int, data string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Header: map[string][]string{
+			"Content-Type": {fhirclient.FhirXmlMediaType},
+		},
+		Body: io.NopCloser(bytes.NewReader([]byte(data))),
+	}
+}
+
+func TestXMLCodec(t *testing.T) {
+	t.Run("unmarshal", func(t *testing.T) {
+		var patient fhir.Patient
+
+		err := fhirclient.XMLCodec.Unmarshal([]byte(patientXML), &patient)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", *patient.ID)
+		assert.Equal(t, `<div xmlns="http://www.w3.org/1999/xhtml"><p>John <b>Doe</b></p></div>`, patient.Text.Div)
+		require.Len(t, patient.Extension, 1)
+		assert.Equal(t, "http://example.com/ext", patient.Extension[0].Url)
+		assert.Equal(t, "foo & bar", *patient.Extension[0].ValueString)
+		require.Len(t, patient.Identifier, 1)
+		assert.Equal(t, "1", *patient.Identifier[0].Value)
+		assert.True(t, *patient.Active)
+		require.Len(t, patient.Name, 1)
+		assert.Equal(t, "n1", *patient.Name[0].ID)
+		assert.Equal(t, []string{"John", "Jack"}, patient.Name[0].Given)
+		assert.Equal(t, fhir.AdministrativeGenderMale, *patient.Gender)
+		assert.Equal(t, 2, *patient.MultipleBirthInteger)
+	})
+	t.Run("marshal", func(t *testing.T) {
+		var patient fhir.Patient
+		require.NoError(t, fhirclient.XMLCodec.Unmarshal([]byte(patientXML), &patient))
+
+		data, err := fhirclient.XMLCodec.Marshal(patient)
+
+		require.NoError(t, err)
+		assert.Equal(t, patientXML, string(data))
+	})
+	t.Run("marshal JSON resource", func(t *testing.T) {
+		data, err := fhirclient.XMLCodec.Marshal([]byte(`{"resourceType":"Patient","id":"1","_birthDate":{"extension":[{"url":"http://example.com/ext","valueBoolean":true}]}}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, `<Patient xmlns="http://hl7.org/fhir"><id value="1"/><birthDate><extension url="http://example.com/ext"><valueBoolean value="true"/></extension></birthDate></Patient>`, string(data))
+	})
+	t.Run("marshal non-resource", func(t *testing.T) {
+		_, err := fhirclient.XMLCodec.Marshal(map[string]string{"id": "1"})
+
+		assert.EqualError(t, err, "resourceType not present in resource of type map[string]string")
+	})
+	t.Run("Bundle with nested resources", func(t *testing.T) {
+		bundle := fhir.Bundle{
+			Type: fhir.BundleTypeSearchset,
+			Entry: []fhir.BundleEntry{
+				{Resource: json.RawMessage(`{"resourceType":"Patient","id":"1","active":true}`)},
+				{Resource: json.RawMessage(`{"resourceType":"Observation","id":"2","status":"final","code":{"text":"BP"},"valueQuantity":{"value":120.5}}`)},
+			},
+		}
+		data, err := fhirclient.XMLCodec.Marshal(bundle)
+		require.NoError(t, err)
+
+		var result fhir.Bundle
+		err = fhirclient.XMLCodec.Unmarshal(data, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, fhir.BundleTypeSearchset, result.Type)
+		require.Len(t, result.Entry, 2)
+		assert.JSONEq(t, `{"resourceType":"Patient","id":"1","active":true}`, string(result.Entry[0].Resource))
+		var observation fhir.Observation
+		require.NoError(t, json.Unmarshal(result.Entry[1].Resource, &observation))
+		assert.Equal(t, 120.5, *observation.ValueQuantity.Value)
+	})
+	t.Run("unmarshal into json.RawMessage", func(t *testing.T) {
+		var result json.RawMessage
+
+		err := fhirclient.XMLCodec.Unmarshal([]byte(`<Patient xmlns="http://hl7.org/fhir"><active value="false"/></Patient>`), &result)
+
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"resourceType":"Patient","active":false}`, string(result))
+	})
+	t.Run("invalid XML", func(t *testing.T) {
+		var patient fhir.Patient
+
+		err := fhirclient.XMLCodec.Unmarshal([]byte(`<Patient>`), &patient)
+
+		assert.ErrorContains(t, err, "invalid FHIR XML")
+	})
+}
+
+func TestBaseClient_XML(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		stub := &requestResponder{
+			response: xmlResponse(http.StatusOK, patientXML),
+		}
+		client := fhirclient.New(baseURL, stub, &fhirclient.Config{Codec: fhirclient.XMLCodec})
+
+		var patient fhir.Patient
+		err := client.Read("Patient/123", &patient)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", *patient.ID)
+		assert.Equal(t, fhirclient.FhirXmlMediaType, stub.request.Header.Get("Accept"))
+	})
+	t.Run("create", func(t *testing.T) {
+		stub := &requestResponder{
+			response: xmlResponse(http.StatusCreated, patientXML),
+		}
+		client := fhirclient.New(baseURL, stub, &fhirclient.Config{Codec: fhirclient.XMLCodec})
+
+		var result fhir.Patient
+		err := client.Create(fhir.Patient{Active: ptr(true)}, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, fhirclient.FhirXmlMediaType, stub.request.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(stub.request.Body)
+		assert.Equal(t, `<Patient xmlns="http://hl7.org/fhir"><active value="true"/></Patient>`, string(body))
+		assert.Equal(t, "123", *result.ID)
+	})
+	t.Run("OperationOutcome", func(t *testing.T) {
+		stub := &requestResponder{
+			response: xmlResponse(http.StatusBadRequest, `<OperationOutcome xmlns="http://hl7.org/fhir">`+
+				`<issue><severity value="error"/><code value="invalid"/><diagnostics value="Invalid resource"/></issue>`+
+				`</OperationOutcome>`),
+		}
+		client := fhirclient.New(baseURL, stub, &fhirclient.Config{Codec: fhirclient.XMLCodec})
+
+		err := client.Read("Patient/123", new(fhir.Patient))
+
+		var outcome fhirclient.OperationOutcomeError
+		require.True(t, errors.As(err, &outcome))
+		assert.Equal(t, http.StatusBadRequest, outcome.HttpStatusCode)
+		assert.Equal(t, "OperationOutcome, issues: [invalid error] Invalid resource", outcome.Error())
+	})
+}
@@ -0,0 +1,117 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// IfNoneExist makes a create conditional: the FHIR server only creates the resource if no resource matches the given search criteria.
+// If multiple resources match, the server responds with 412 Precondition Failed, which is returned as MultipleMatchesError.
+func IfNoneExist(criteria url.Values) PreRequestOption {
+	return func(_ Client, r *http.Request) {
+		r.Header.Set("If-None-Exist", criteria.Encode())
+	}
+}
+
+// SearchCriteria makes an update or delete conditional: instead of the resource at the given path,
+// the FHIR server updates or deletes the resource matching the given search criteria.
+// The path passed to Update or Delete should then be the resource type, e.g.:
+//
+//	client.Update("Patient", patient, &result, SearchCriteria(url.Values{"identifier": {"http://example.com|123"}}))
+//
+// If multiple resources match, the server responds with 412 Precondition Failed, which is returned as MultipleMatchesError.
+func SearchCriteria(criteria url.Values) PreRequestOption {
+	return func(_ Client, r *http.Request) {
+		q := r.URL.Query()
+		for key, values := range criteria {
+			for _, value := range values {
+				q.Add(key, value)
+			}
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+// IfMatch makes an update, patch or delete conditional on the current version of the resource:
+// the FHIR server only performs the interaction if the resource's ETag matches the given ETag (e.g. W/"1").
+// If the resource has been changed in the meantime, the server responds with 409 Conflict or 412 Precondition Failed,
+// which is returned as VersionConflictError.
+func IfMatch(etag string) PreRequestOption {
+	return func(_ Client, r *http.Request) {
+		r.Header.Set("If-Match", etag)
+	}
+}
+
+// VersionETag returns the (weak) ETag for the given resource version, e.g. W/"1" for version 1.
+func VersionETag(versionID string) string {
+	return fmt.Sprintf(`W/"%s"`, versionID)
+}
+
+// MultipleMatchesError is returned when a conditional create, update or delete failed,
+// because the search criteria matched multiple resources (412 Precondition Failed).
+// The error returned by the FHIR server (e.g. OperationOutcomeError) can be retrieved using errors.As.
+type MultipleMatchesError struct {
+	Err error
+}
+
+func (e MultipleMatchesError) Error() string {
+	return fmt.Sprintf("conditional request matched multiple resources: %s", e.Err)
+}
+
+func (e MultipleMatchesError) Unwrap() error {
+	return e.Err
+}
+
+// VersionConflictError is returned when an update, patch or delete with If-Match failed,
+// because the resource has been changed on the FHIR server (409 Conflict or 412 Precondition Failed).
+// The error returned by the FHIR server (e.g. OperationOutcomeError) can be retrieved using errors.As.
+type VersionConflictError struct {
+	Err error
+}
+
+func (e VersionConflictError) Error() string {
+	return fmt.Sprintf("resource version conflict: %s", e.Err)
+}
+
+func (e VersionConflictError) Unwrap() error {
+	return e.Err
+}
+
+// classifyStatusError converts the error for a non-2xx response into a more specific error, if applicable.
+func classifyStatusError(httpRequest *http.Request, statusCode int, err error) error {
+	if httpRequest.Header.Get("If-Match") != "" && (statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed) {
+		return VersionConflictError{Err: err}
+	}
+	if statusCode == http.StatusPreconditionFailed && isConditionalRequest(httpRequest) {
+		return MultipleMatchesError{Err: err}
+	}
+	return err
+}
+
+// isConditionalRequest returns true if the request is a conditional create, update or delete.
+func isConditionalRequest(httpRequest *http.Request) bool {
+	if httpRequest.Header.Get("If-None-Exist") != "" {
+		return true
+	}
+	switch httpRequest.Method {
+	case http.MethodPut, http.MethodDelete, http.MethodPatch:
+		return httpRequest.URL.RawQuery != ""
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfNoneExist(t *testing.T) {
+	stub := &requestResponder{
+		response: okResponse(Resource{Id: "123"}),
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	err := client.CreateWithContext(context.Background(), Resource{}, new(Resource), fhirclient.IfNoneExist(url.Values{"identifier": {"sys|123"}}))
+
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/fhir/Resource", stub.request.URL.String())
+	assert.Equal(t, "identifier=sys%7C123", stub.request.Header.Get("If-None-Exist"))
+}
+
+func TestSearchCriteria(t *testing.T) {
+	t.Run("conditional update", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(Resource{Id: "123"}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		err := client.UpdateWithContext(context.Background(), "Resource", Resource{}, new(Resource), fhirclient.SearchCriteria(url.Values{"identifier": {"sys|123"}}))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodPut, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Resource?identifier=sys%7C123", stub.request.URL.String())
+	})
+	t.Run("conditional delete", func(t *testing.T) {
+		stub := &requestResponder{
+			response: &http.Response{StatusCode: http.StatusNoContent},
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		err := client.DeleteWithContext(context.Background(), "Resource", fhirclient.SearchCriteria(url.Values{"identifier": {"sys|123"}}))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodDelete, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Resource?identifier=sys%7C123", stub.request.URL.String())
+	})
+}
+
+func TestMultipleMatchesError(t *testing.T) {
+	preconditionFailed := func() *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusPreconditionFailed,
+			Header:     map[string][]string{"Content-Type": {fhirclient.FhirJsonMediaType}},
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"multiple-matches","diagnostics":"multiple matches"}]}`))),
+		}
+	}
+	t.Run("conditional create", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{response: preconditionFailed()}, nil)
+
+		err := client.Create(Resource{}, new(Resource), fhirclient.IfNoneExist(url.Values{"identifier": {"sys|123"}}))
+
+		var multipleMatchesErr fhirclient.MultipleMatchesError
+		require.ErrorAs(t, err, &multipleMatchesErr)
+		var operationOutcomeErr fhirclient.OperationOutcomeError
+		require.ErrorAs(t, err, &operationOutcomeErr)
+		assert.Equal(t, http.StatusPreconditionFailed, operationOutcomeErr.HttpStatusCode)
+		assert.EqualError(t, err, "conditional request matched multiple resources: OperationOutcome, issues: [multiple-matches error] multiple matches")
+	})
+	t.Run("conditional update", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{response: preconditionFailed()}, nil)
+
+		err := client.Update("Resource", Resource{}, new(Resource), fhirclient.SearchCriteria(url.Values{"identifier": {"sys|123"}}))
+
+		assert.True(t, errors.As(err, new(fhirclient.MultipleMatchesError)))
+	})
+	t.Run("conditional delete", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{response: &http.Response{StatusCode: http.StatusPreconditionFailed}}, nil)
+
+		err := client.Delete("Resource", fhirclient.SearchCriteria(url.Values{"identifier": {"sys|123"}}))
+
+		assert.True(t, errors.As(err, new(fhirclient.MultipleMatchesError)))
+		assert.EqualError(t, err, "conditional request matched multiple resources: FHIR request failed (DELETE http://example.com/fhir/Resource?identifier=sys%7C123, status=412)")
+	})
+	t.Run("non-conditional request", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{response: preconditionFailed()}, nil)
+
+		err := client.Update("Resource/123", Resource{}, new(Resource))
+
+		assert.False(t, errors.As(err, new(fhirclient.MultipleMatchesError)))
+		assert.IsType(t, fhirclient.OperationOutcomeError{}, err)
+	})
+}
+
+func TestIfMatch(t *testing.T) {
+	stub := &requestResponder{
+		response: okResponse(Resource{Id: "123"}),
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	err := client.Update("Resource/123", Resource{}, new(Resource), fhirclient.IfMatch(fhirclient.VersionETag("2")))
+
+	require.NoError(t, err)
+	assert.Equal(t, `W/"2"`, stub.request.Header.Get("If-Match"))
+}
+
+func TestBaseClient_UpdateIfUnchanged(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(Resource{Id: "123"}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		var result Resource
+
+		err := client.UpdateIfUnchanged(map[string]interface{}{
+			"resourceType": "Resource",
+			"id":           "123",
+			"meta":         map[string]interface{}{"versionId": "3"},
+		}, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", result.Id)
+		assert.Equal(t, http.MethodPut, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Resource/123", stub.request.URL.String())
+		assert.Equal(t, `W/"3"`, stub.request.Header.Get("If-Match"))
+		requestBody, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `{"resourceType":"Resource","id":"123","meta":{"versionId":"3"}}`, string(requestBody))
+	})
+	t.Run("version conflict", func(t *testing.T) {
+		for _, statusCode := range []int{http.StatusConflict, http.StatusPreconditionFailed} {
+			stub := &requestResponder{
+				response: &http.Response{StatusCode: statusCode},
+			}
+			client := fhirclient.New(baseURL, stub, nil)
+
+			err := client.UpdateIfUnchangedWithContext(context.Background(), map[string]interface{}{
+				"resourceType": "Resource",
+				"id":           "123",
+				"meta":         map[string]interface{}{"versionId": "3"},
+			}, nil)
+
+			var conflictErr fhirclient.VersionConflictError
+			require.ErrorAs(t, err, &conflictErr)
+			assert.False(t, errors.As(err, new(fhirclient.MultipleMatchesError)))
+		}
+	})
+	t.Run("resource without id", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		err := client.UpdateIfUnchanged(map[string]interface{}{"resourceType": "Resource"}, nil)
+
+		assert.EqualError(t, err, "id not present in resource of type map[string]interface {}")
+	})
+	t.Run("resource without version", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		err := client.UpdateIfUnchanged(map[string]interface{}{"resourceType": "Resource", "id": "123"}, nil)
+
+		assert.EqualError(t, err, "meta.versionId not present in resource of type map[string]interface {}")
+	})
+}
@@ -0,0 +1,204 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// HistoryScope determines which resources History returns the history of.
+type HistoryScope struct {
+	// ResourceType is the type of the resources. If empty, the history of all resources on the server is returned.
+	ResourceType string
+	// ID is the ID of the resource. If empty, the history of all resources of ResourceType is returned.
+	ID string
+}
+
+// SystemHistory returns the scope for the history of all resources on the server.
+func SystemHistory() HistoryScope {
+	return HistoryScope{}
+}
+
+// TypeHistory returns the scope for the history of all resources of the given type.
+func TypeHistory(resourceType string) HistoryScope {
+	return HistoryScope{ResourceType: resourceType}
+}
+
+// InstanceHistory returns the scope for the history of a single resource.
+func InstanceHistory(resourceType string, id string) HistoryScope {
+	return HistoryScope{ResourceType: resourceType, ID: id}
+}
+
+// path returns the path of the history interaction, relative to the base URL.
+func (s HistoryScope) path() (string, string, error) {
+	switch {
+	case s.ResourceType == "" && s.ID != "":
+		return "", "", errors.New("history scope with ID must have a resource type")
+	case s.ResourceType == "":
+		return "_history", "history-system", nil
+	case s.ID == "":
+		return s.ResourceType + "/_history", "history-type", nil
+	default:
+		return s.ResourceType + "/" + s.ID + "/_history", "history-instance", nil
+	}
+}
+
+// HistoryParams contains the parameters of a history interaction.
+type HistoryParams struct {
+	// Since only includes versions created at or after the given instant (_since).
+	Since time.Time
+	// At only includes versions that were current at some point during the given time (_at).
+	// Use AtPrecision to specify a partial date, e.g. a year.
+	At time.Time
+	// AtPrecision is the Go time layout At is formatted with, e.g. "2006" for a year. If empty, At is formatted as instant.
+	AtPrecision string
+	// Count is the number of versions per page (_count). If zero, the server's default is used.
+	Count int
+}
+
+func (p HistoryParams) query() url.Values {
+	result := url.Values{}
+	if !p.Since.IsZero() {
+		result.Set("_since", p.Since.Format(time.RFC3339Nano))
+	}
+	if !p.At.IsZero() {
+		layout := p.AtPrecision
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		result.Set("_at", p.At.Format(layout))
+	}
+	if p.Count > 0 {
+		result.Set("_count", strconv.Itoa(p.Count))
+	}
+	return result
+}
+
+// HistoryBundle is a page of a history Bundle, of which the entries are parsed into the version they describe.
+// Use Paginate with its Bundle to page through the history, and NewHistoryBundle to parse the subsequent pages.
+type HistoryBundle struct {
+	// Bundle is the history Bundle.
+	Bundle fhir.Bundle
+	// Entries are the versions in the Bundle, newest first.
+	Entries []HistoryEntry
+}
+
+// HistoryEntry is a single version of a resource in a history Bundle.
+type HistoryEntry struct {
+	// FullURL is the fullUrl of the entry, if present.
+	FullURL string
+	// Resource is the version of the resource. It's empty if the version was a delete.
+	Resource json.RawMessage
+	// Method is the HTTP method of the request that created the version (e.g. PUT for an update).
+	Method fhir.HTTPVerb
+	// URL is the URL of the request that created the version.
+	URL string
+	// Status is the status of the response to the request that created the version, e.g. "201 Created".
+	Status string
+	// StatusCode is the HTTP status code in Status, or 0 if it's not present.
+	StatusCode int
+	// ETag is the ETag of the version, if present.
+	ETag string
+	// LastModified is the time the version was created, if present.
+	LastModified *time.Time
+}
+
+// IsDelete returns whether the version was created by deleting the resource.
+func (e HistoryEntry) IsDelete() bool {
+	return e.Method == fhir.HTTPVerbDELETE
+}
+
+// Unmarshal unmarshals the version of the resource into the target.
+func (e HistoryEntry) Unmarshal(target any) error {
+	if len(e.Resource) == 0 {
+		return errors.New("history entry has no resource")
+	}
+	return json.Unmarshal(e.Resource, target)
+}
+
+// NewHistoryBundle parses the entries of a history Bundle.
+func NewHistoryBundle(bundle fhir.Bundle) *HistoryBundle {
+	result := &HistoryBundle{Bundle: bundle}
+	for _, entry := range bundle.Entry {
+		historyEntry := HistoryEntry{Resource: entry.Resource}
+		if entry.FullUrl != nil {
+			historyEntry.FullURL = *entry.FullUrl
+		}
+		if entry.Request != nil {
+			historyEntry.Method = entry.Request.Method
+			historyEntry.URL = entry.Request.Url
+		}
+		if entry.Response != nil {
+			historyEntry.Status = entry.Response.Status
+			historyEntry.StatusCode = parseEntryStatus(entry.Response.Status)
+			if entry.Response.Etag != nil {
+				historyEntry.ETag = *entry.Response.Etag
+			}
+			if entry.Response.LastModified != nil {
+				if lastModified, err := time.Parse(time.RFC3339Nano, *entry.Response.LastModified); err == nil {
+					historyEntry.LastModified = &lastModified
+				}
+			}
+		}
+		result.Entries = append(result.Entries, historyEntry)
+	}
+	return result
+}
+
+// VReadWithContext reads a specific version of a resource from the FHIR server and unmarshals it into the target.
+func (d BaseClient) VReadWithContext(ctx context.Context, resourceType string, id string, versionID string, target any, opts ...Option) error {
+	return d.ReadWithContext(ctx, resourceType+"/"+id+"/_history/"+versionID, target, opts...)
+}
+
+func (d BaseClient) VRead(resourceType string, id string, versionID string, target any, opts ...Option) error {
+	return d.VReadWithContext(context.Background(), resourceType, id, versionID, target, opts...)
+}
+
+// HistoryWithContext retrieves the first page of the history of the resources in the given scope.
+func (d BaseClient) HistoryWithContext(ctx context.Context, scope HistoryScope, params HistoryParams, opts ...Option) (*HistoryBundle, error) {
+	opts = append(d.config.DefaultOptions, opts...)
+	path, interaction, err := scope.path()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkInteraction(ctx, scope.ResourceType, interaction, nil); err != nil {
+		return nil, err
+	}
+	opts = append([]Option{AtPath(path)}, opts...)
+	historyURL := *d.baseURL
+	historyURL.RawQuery = params.query().Encode()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, historyURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var bundle fhir.Bundle
+	if err := d.doRequest(httpRequest, &bundle, opts...); err != nil {
+		return nil, err
+	}
+	return NewHistoryBundle(bundle), nil
+}
+
+func (d BaseClient) History(scope HistoryScope, params HistoryParams, opts ...Option) (*HistoryBundle, error) {
+	return d.HistoryWithContext(context.Background(), scope, params, opts...)
+}
@@ -0,0 +1,156 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestBaseClient_VRead(t *testing.T) {
+	stub := &requestResponder{
+		response: okResponse(fhir.Patient{ID: ptr("1"), Meta: &fhir.Meta{VersionId: ptr("2")}}),
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	var patient fhir.Patient
+	err := client.VReadWithContext(context.Background(), "Patient", "1", "2", &patient)
+
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/fhir/Patient/1/_history/2", stub.request.URL.String())
+	assert.Equal(t, "2", *patient.Meta.VersionId)
+}
+
+func TestBaseClient_History(t *testing.T) {
+	historyBundle := fhir.Bundle{
+		Type: fhir.BundleTypeHistory,
+		Entry: []fhir.BundleEntry{
+			{
+				FullUrl:  ptr("http://example.com/fhir/Patient/1"),
+				Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbDELETE, Url: "Patient/1"},
+				Response: &fhir.BundleEntryResponse{Status: "204 No Content", Etag: ptr(`W/"3"`), LastModified: ptr("2024-01-03T10:00:00Z")},
+			},
+			{
+				FullUrl:  ptr("http://example.com/fhir/Patient/1"),
+				Resource: json.RawMessage(`{"resourceType":"Patient","id":"1","active":false,"meta":{"versionId":"2"}}`),
+				Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbPUT, Url: "Patient/1"},
+				Response: &fhir.BundleEntryResponse{Status: "200 OK", Etag: ptr(`W/"2"`)},
+			},
+			{
+				FullUrl:  ptr("http://example.com/fhir/Patient/1"),
+				Resource: json.RawMessage(`{"resourceType":"Patient","id":"1","active":true,"meta":{"versionId":"1"}}`),
+				Request:  &fhir.BundleEntryRequest{Method: fhir.HTTPVerbPOST, Url: "Patient"},
+				Response: &fhir.BundleEntryResponse{Status: "201"},
+			},
+		},
+	}
+
+	t.Run("instance", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(historyBundle)}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		result, err := client.HistoryWithContext(context.Background(), fhirclient.InstanceHistory("Patient", "1"), fhirclient.HistoryParams{})
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/Patient/1/_history", stub.request.URL.String())
+		assert.Equal(t, fhir.BundleTypeHistory, result.Bundle.Type)
+		require.Len(t, result.Entries, 3)
+		deleted := result.Entries[0]
+		assert.True(t, deleted.IsDelete())
+		assert.Equal(t, "http://example.com/fhir/Patient/1", deleted.FullURL)
+		assert.Equal(t, http.StatusNoContent, deleted.StatusCode)
+		assert.Equal(t, `W/"3"`, deleted.ETag)
+		assert.Equal(t, time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC), *deleted.LastModified)
+		assert.EqualError(t, deleted.Unmarshal(new(fhir.Patient)), "history entry has no resource")
+		updated := result.Entries[1]
+		assert.Equal(t, fhir.HTTPVerbPUT, updated.Method)
+		assert.Equal(t, "Patient/1", updated.URL)
+		assert.Equal(t, "200 OK", updated.Status)
+		var patient fhir.Patient
+		require.NoError(t, updated.Unmarshal(&patient))
+		assert.False(t, *patient.Active)
+		assert.Equal(t, http.StatusCreated, result.Entries[2].StatusCode)
+		assert.Nil(t, result.Entries[2].LastModified)
+	})
+	t.Run("type with parameters", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(historyBundle)}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		_, err := client.HistoryWithContext(context.Background(), fhirclient.TypeHistory("Patient"), fhirclient.HistoryParams{
+			Since:       time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC),
+			At:          time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			AtPrecision: "2006",
+			Count:       10,
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, "/fhir/Patient/_history", stub.request.URL.Path)
+		assert.Equal(t, "2024-01-01T12:30:00Z", stub.request.URL.Query().Get("_since"))
+		assert.Equal(t, "2023", stub.request.URL.Query().Get("_at"))
+		assert.Equal(t, "10", stub.request.URL.Query().Get("_count"))
+	})
+	t.Run("system", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(historyBundle)}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		_, err := client.History(fhirclient.SystemHistory(), fhirclient.HistoryParams{}, fhirclient.RequestHeaders(http.Header{"X-Custom": {"value"}}))
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/_history", stub.request.URL.String())
+		assert.Equal(t, "value", stub.request.Header.Get("X-Custom"))
+	})
+	t.Run("ID without resource type", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		_, err := client.HistoryWithContext(context.Background(), fhirclient.HistoryScope{ID: "1"}, fhirclient.HistoryParams{})
+
+		assert.EqualError(t, err, "history scope with ID must have a resource type")
+	})
+	t.Run("paginate", func(t *testing.T) {
+		firstPage := fhir.Bundle{
+			Type:  fhir.BundleTypeHistory,
+			Entry: historyBundle.Entry[:2],
+			Link:  []fhir.BundleLink{{Relation: "next", Url: "http://example.com/fhir/Patient/1/_history?page=2"}},
+		}
+		secondPage := fhir.Bundle{
+			Type:  fhir.BundleTypeHistory,
+			Entry: historyBundle.Entry[2:],
+		}
+		stub := &requestsResponder{responses: []*http.Response{okResponse(firstPage), okResponse(secondPage)}}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		first, err := client.HistoryWithContext(context.Background(), fhirclient.InstanceHistory("Patient", "1"), fhirclient.HistoryParams{Count: 2})
+		require.NoError(t, err)
+		var entries []fhirclient.HistoryEntry
+		err = fhirclient.Paginate(context.Background(), client, first.Bundle, func(bundle *fhir.Bundle) (bool, error) {
+			entries = append(entries, fhirclient.NewHistoryBundle(*bundle).Entries...)
+			return true, nil
+		})
+
+		require.NoError(t, err)
+		assert.Len(t, entries, 3)
+		assert.Equal(t, http.MethodGet, stub.requests[1].Method)
+		assert.Equal(t, "http://example.com/fhir/Patient/1/_history?page=2", stub.requests[1].URL.String())
+	})
+}
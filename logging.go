@@ -0,0 +1,277 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RedactedValue replaces redacted query parameter values and body fields in logs.
+const RedactedValue = "REDACTED"
+
+// DefaultRedactedQueryParameters are the search parameters that are redacted from logged URLs if LoggingConfig.RedactedQueryParameters is nil.
+var DefaultRedactedQueryParameters = []string{
+	"identifier", "name", "family", "given", "phonetic", "birthdate", "death-date",
+	"address", "address-city", "address-postalcode", "address-state", "address-country",
+	"telecom", "phone", "email",
+}
+
+// DefaultRedactedPaths are the body fields that are redacted from logged bodies if LoggingConfig.RedactedPaths is nil.
+var DefaultRedactedPaths = []string{
+	"*.text", "*.identifier", "*.note",
+	"Patient.name", "Patient.telecom", "Patient.address", "Patient.birthDate", "Patient.contact", "Patient.photo",
+	"Person.name", "Person.telecom", "Person.address", "Person.birthDate", "Person.photo",
+	"RelatedPerson.name", "RelatedPerson.telecom", "RelatedPerson.address", "RelatedPerson.birthDate", "RelatedPerson.photo",
+	"Binary.data", "DocumentReference.content.attachment.data",
+}
+
+// LoggingConfig configures logging of the requests sent to the FHIR server.
+// Every request is logged with its method, URL, status, duration, sizes and OperationOutcome issues (severity and code, since diagnostics might contain personal information).
+// Successful requests are logged at info level, requests that failed with a 4xx or 5xx status at warn level, and other failures at error level.
+type LoggingConfig struct {
+	// Logger is the logger to log to. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// RedactedQueryParameters are the query parameters whose values are replaced with RedactedValue in logged URLs and errors.
+	// Modifiers and chains are taken into account: "name" also redacts "name:exact" and "subject:Patient.name".
+	// If nil, DefaultRedactedQueryParameters is used.
+	RedactedQueryParameters []string
+	// LogBodies enables logging of request and response bodies.
+	// Only FHIR resources are logged; other bodies (e.g. JSON Patch documents) are omitted, since they can't be redacted.
+	LogBodies bool
+	// RedactedPaths are the fields that are replaced with RedactedValue in logged bodies, as FHIR paths (e.g. Patient.birthDate).
+	// The path starts with the resource type, or * to match any resource type. Resources nested in Bundles, Parameters and contained resources are redacted as well.
+	// Choice types are specified with [x], e.g. Patient.deceased[x].
+	// If nil, DefaultRedactedPaths is used.
+	RedactedPaths []string
+}
+
+// logRequest logs the request, if logging is enabled.
+func (d BaseClient) logRequest(record *requestRecord, err error) {
+	config := d.config.Logging
+	if config == nil {
+		return
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx := record.request.Context()
+	level := slog.LevelInfo
+	if record.statusCode >= 400 {
+		level = slog.LevelWarn
+	} else if err != nil {
+		level = slog.LevelError
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	redactedParameters := config.RedactedQueryParameters
+	if redactedParameters == nil {
+		redactedParameters = DefaultRedactedQueryParameters
+	}
+	requestURL := redactURL(record.request.URL, redactedParameters)
+	attrs := []slog.Attr{
+		slog.String("method", record.request.Method),
+		slog.String("url", requestURL),
+	}
+	if record.interaction != "" {
+		attrs = append(attrs, slog.String("interaction", record.interaction))
+	}
+	if record.resourceType != "" {
+		attrs = append(attrs, slog.String("resource_type", record.resourceType))
+	}
+	if record.statusCode != 0 {
+		attrs = append(attrs, slog.Int("status", record.statusCode))
+	}
+	attrs = append(attrs, slog.Duration("duration", time.Since(record.start)))
+	if record.attempts > 1 {
+		attrs = append(attrs, slog.Int("attempts", record.attempts))
+	}
+	if record.requestBody != nil {
+		attrs = append(attrs, slog.Int64("request_size", record.requestBody.size()))
+	}
+	if record.statusCode != 0 {
+		attrs = append(attrs, slog.Int64("response_size", record.responseSize()))
+	}
+	if summary := summarizeOperationOutcome(record.response); summary != "" {
+		attrs = append(attrs, slog.String("operation_outcome", summary))
+	}
+	// OperationOutcome errors are summarized above, since their diagnostics might contain personal information
+	var outcome OperationOutcomeError
+	if err != nil && !errors.As(err, &outcome) {
+		attrs = append(attrs, slog.String("error", strings.ReplaceAll(err.Error(), record.request.URL.String(), requestURL)))
+	}
+	if config.LogBodies {
+		redactedPaths := config.RedactedPaths
+		if redactedPaths == nil {
+			redactedPaths = DefaultRedactedPaths
+		}
+		if record.requestBody != nil {
+			if body, ok := redactBody(record.requestBody.captured(), redactedPaths); ok {
+				attrs = append(attrs, slog.String("request_body", body))
+			}
+		}
+		if body, ok := redactBody(record.response, redactedPaths); ok {
+			attrs = append(attrs, slog.String("response_body", body))
+		}
+	}
+	logger.LogAttrs(ctx, level, "FHIR request", attrs...)
+}
+
+// redactURL returns the URL with the values of the given query parameters replaced with RedactedValue.
+func redactURL(u *url.URL, redactedParameters []string) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	redacted := false
+	for name, values := range query {
+		if isRedactedParameter(name, redactedParameters) {
+			for i := range values {
+				values[i] = RedactedValue
+			}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	result := *u
+	result.RawQuery = query.Encode()
+	return result.String()
+}
+
+// isRedactedParameter checks whether the query parameter must be redacted, taking modifiers (name:exact) and chains (subject:Patient.name) into account.
+func isRedactedParameter(name string, redactedParameters []string) bool {
+	for _, part := range strings.Split(name, ".") {
+		part, _, _ = strings.Cut(part, ":")
+		for _, redacted := range redactedParameters {
+			if part == redacted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// summarizeOperationOutcome returns the severity and code of the issues if the data is an OperationOutcome, e.g. "error/not-found".
+func summarizeOperationOutcome(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	var outcome struct {
+		ResourceType string `json:"resourceType"`
+		Issue        []struct {
+			Severity string `json:"severity"`
+			Code     string `json:"code"`
+		} `json:"issue"`
+	}
+	if detectCodec(data).Unmarshal(data, &outcome) != nil || outcome.ResourceType != "OperationOutcome" {
+		return ""
+	}
+	var issues []string
+	for _, issue := range outcome.Issue {
+		issues = append(issues, issue.Severity+"/"+issue.Code)
+	}
+	return strings.Join(issues, ", ")
+}
+
+// redactBody returns the body as JSON with the given paths redacted. It returns false if the body isn't a FHIR resource.
+func redactBody(data []byte, redactedPaths []string) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	var raw json.RawMessage
+	if err := detectCodec(data).Unmarshal(data, &raw); err != nil {
+		return "", false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var resource map[string]any
+	if err := decoder.Decode(&resource); err != nil {
+		return "", false
+	}
+	if _, ok := resource["resourceType"].(string); !ok {
+		return "", false
+	}
+	var paths [][]string
+	for _, path := range redactedPaths {
+		paths = append(paths, strings.Split(path, "."))
+	}
+	redactResources(resource, paths)
+	result, err := json.Marshal(resource)
+	if err != nil {
+		return "", false
+	}
+	return string(result), true
+}
+
+// redactResources redacts the paths in every resource in the given JSON value.
+func redactResources(node any, paths [][]string) {
+	switch n := node.(type) {
+	case map[string]any:
+		if resourceType, ok := n["resourceType"].(string); ok {
+			for _, path := range paths {
+				if len(path) > 1 && (path[0] == resourceType || path[0] == "*") {
+					redactPath(n, path[1:])
+				}
+			}
+		}
+		for _, value := range n {
+			redactResources(value, paths)
+		}
+	case []any:
+		for _, value := range n {
+			redactResources(value, paths)
+		}
+	}
+}
+
+func redactPath(node any, path []string) {
+	switch n := node.(type) {
+	case []any:
+		for _, value := range n {
+			redactPath(value, path)
+		}
+	case map[string]any:
+		for key, value := range n {
+			name := strings.TrimPrefix(key, "_")
+			if !matchesPathSegment(name, path[0]) {
+				continue
+			}
+			if len(path) == 1 {
+				n[key] = RedactedValue
+			} else {
+				redactPath(value, path[1:])
+			}
+		}
+	}
+}
+
+// matchesPathSegment checks whether the JSON property name matches the FHIR path segment, which might be a choice type (value[x]).
+func matchesPathSegment(name string, segment string) bool {
+	if prefix, ok := strings.CutSuffix(segment, "[x]"); ok {
+		return strings.HasPrefix(name, prefix) && len(name) > len(prefix) && name[len(prefix)] >= 'A' && name[len(prefix)] <= 'Z'
+	}
+	return name == segment
+}
@@ -0,0 +1,159 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestLogging(t *testing.T) {
+	const patientJSON = `{"resourceType":"Patient","id":"1","name":[{"family":"Doe"}],"birthDate":"1980-01-01","_birthDate":{"extension":[{"url":"http://example.com","valueString":"x"}]},"gender":"male","deceasedBoolean":false}`
+	handler := handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			_, _ = io.ReadAll(r.Body)
+		}
+		w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+		switch r.URL.Path {
+		case "/fhir/Patient/missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"not-found","diagnostics":"Patient Doe not found"}]}`))
+		case "/fhir/Patient/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/fhir/Patient":
+			if r.Method == http.MethodPost {
+				_, _ = w.Write([]byte(patientJSON))
+				return
+			}
+			bundle, _ := json.Marshal(fhir.Bundle{Entry: []fhir.BundleEntry{{Resource: json.RawMessage(patientJSON)}}})
+			_, _ = w.Write(bundle)
+		default:
+			_, _ = w.Write([]byte(patientJSON))
+		}
+	})
+	setup := func(config fhirclient.LoggingConfig) (fhirclient.Client, func() map[string]any) {
+		buf := new(bytes.Buffer)
+		config.Logger = slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		client := fhirclient.New(baseURL, handler, &fhirclient.Config{Logging: &config})
+		return client, func() map[string]any {
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+			return entry
+		}
+	}
+
+	t.Run("successful request", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{})
+
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+
+		entry := logged()
+		assert.Equal(t, "INFO", entry["level"])
+		assert.Equal(t, "FHIR request", entry["msg"])
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "http://example.com/fhir/Patient/1", entry["url"])
+		assert.Equal(t, "read", entry["interaction"])
+		assert.Equal(t, "Patient", entry["resource_type"])
+		assert.Equal(t, float64(http.StatusOK), entry["status"])
+		assert.Equal(t, float64(len(patientJSON)), entry["response_size"])
+		assert.Contains(t, entry, "duration")
+		assert.NotContains(t, entry, "response_body")
+	})
+	t.Run("query parameters are redacted", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{})
+
+		err := client.Search("Patient", url.Values{
+			"name:exact":                {"Doe"},
+			"birthdate":                 {"1980-01-01"},
+			"general-practitioner.name": {"Smith"},
+			"gender":                    {"male"},
+		}, new(fhir.Bundle))
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/Patient?birthdate=REDACTED&gender=male&general-practitioner.name=REDACTED&name%3Aexact=REDACTED", logged()["url"])
+	})
+	t.Run("custom redacted query parameters", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{RedactedQueryParameters: []string{"gender"}})
+
+		require.NoError(t, client.Search("Patient", url.Values{"gender": {"male"}, "name": {"Doe"}}, new(fhir.Bundle)))
+
+		assert.Equal(t, "http://example.com/fhir/Patient?gender=REDACTED&name=Doe", logged()["url"])
+	})
+	t.Run("failed request", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{})
+
+		err := client.Read("Patient/error", new(fhir.Patient), fhirclient.QueryParam("identifier", "123"))
+
+		require.Error(t, err)
+		entry := logged()
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, float64(http.StatusInternalServerError), entry["status"])
+		assert.Equal(t, "FHIR request failed (GET http://example.com/fhir/Patient/error?identifier=REDACTED, status=500)", entry["error"])
+	})
+	t.Run("OperationOutcome", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{})
+
+		err := client.Read("Patient/missing", new(fhir.Patient))
+
+		require.Error(t, err)
+		entry := logged()
+		assert.Equal(t, float64(http.StatusNotFound), entry["status"])
+		assert.Equal(t, "error/not-found", entry["operation_outcome"])
+		// Diagnostics might contain personal information
+		assert.NotContains(t, entry, "error")
+	})
+	t.Run("bodies are redacted", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{LogBodies: true})
+
+		err := client.Create(json.RawMessage(patientJSON), new(fhir.Patient))
+
+		require.NoError(t, err)
+		entry := logged()
+		expected := `{"resourceType":"Patient","id":"1","name":"REDACTED","birthDate":"REDACTED","_birthDate":"REDACTED","gender":"male","deceasedBoolean":false}`
+		assert.JSONEq(t, expected, entry["request_body"].(string))
+		assert.JSONEq(t, expected, entry["response_body"].(string))
+		assert.Equal(t, float64(len(patientJSON)), entry["request_size"])
+	})
+	t.Run("nested resources and choice types are redacted", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{LogBodies: true, RedactedPaths: []string{"*.deceased[x]", "Patient.name.family"}})
+
+		require.NoError(t, client.Search("Patient", nil, new(fhir.Bundle)))
+
+		var bundle fhir.Bundle
+		require.NoError(t, json.Unmarshal([]byte(logged()["response_body"].(string)), &bundle))
+		assert.JSONEq(t, `{"resourceType":"Patient","id":"1","name":[{"family":"REDACTED"}],"birthDate":"1980-01-01","_birthDate":{"extension":[{"url":"http://example.com","valueString":"x"}]},"gender":"male","deceasedBoolean":"REDACTED"}`, string(bundle.Entry[0].Resource))
+	})
+	t.Run("non-resource bodies are omitted", func(t *testing.T) {
+		client, logged := setup(fhirclient.LoggingConfig{LogBodies: true})
+
+		err := client.Patch("Patient/1", fhirclient.JSONPatch{{Op: "replace", Path: "/birthDate", Value: "1980-01-01"}}, new(fhir.Patient))
+
+		require.NoError(t, err)
+		entry := logged()
+		assert.NotContains(t, entry, "request_body")
+		assert.Contains(t, entry, "response_body")
+	})
+}
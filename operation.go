@@ -0,0 +1,380 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// OperationUsingGET invokes the operation using GET, with the parameters as query parameters.
+// This is only possible for operations that don't affect state, and only if all parameters have a primitive value.
+func OperationUsingGET() Option {
+	return requestOption(func(s *requestSettings) {
+		s.operationGET = true
+	})
+}
+
+// Operation is like OperationWithContext, but uses the default context.
+func (d BaseClient) Operation(path string, name string, parameters *Parameters, target any, opts ...Option) error {
+	return d.OperationWithContext(context.Background(), path, name, parameters, target, opts...)
+}
+
+// OperationWithContext invokes the operation with the given name (e.g. $validate) on the FHIR server and unmarshals the result into the target.
+// The path determines the level of the operation: empty for system level (e.g. $export), a resource type for type level (e.g. ValueSet/$expand),
+// or a resource type and ID for instance level (e.g. Patient/1/$everything).
+// The parameters (which may be nil) are POSTed as Parameters resource, unless OperationUsingGET is specified.
+// If the operation returns Parameters with a single resource named "return", that resource is unmarshalled into the target,
+// unless the target is *Parameters or *fhir.Parameters.
+func (d BaseClient) OperationWithContext(ctx context.Context, path string, name string, parameters *Parameters, target any, opts ...Option) error {
+	opts = append(d.config.DefaultOptions, opts...)
+	if parameters == nil {
+		parameters = NewParameters()
+	}
+	var settings requestSettings
+	for _, opt := range opts {
+		if fn, ok := opt.(requestOption); ok {
+			fn(&settings)
+		}
+	}
+	operationPath := strings.TrimSuffix(path, "/") + "/$" + strings.TrimPrefix(name, "$")
+	opts = append([]Option{AtPath(strings.TrimPrefix(operationPath, "/"))}, opts...)
+	var httpRequest *http.Request
+	if settings.operationGET {
+		operationURL := *d.baseURL
+		query, err := parameters.query()
+		if err != nil {
+			return fmt.Errorf("operation %s: %w", name, err)
+		}
+		operationURL.RawQuery = query
+		httpRequest, err = http.NewRequestWithContext(ctx, http.MethodGet, operationURL.String(), nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		body, err := parameters.Parameters()
+		if err != nil {
+			return fmt.Errorf("operation %s: %w", name, err)
+		}
+		data, err := d.codec().Marshal(body)
+		if err != nil {
+			return err
+		}
+		httpRequest, err = http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL.String(), io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			return err
+		}
+		httpRequest.Header.Set("Content-Type", d.codec().MediaType())
+	}
+	var data []byte
+	if err := d.doRequest(httpRequest, &data, opts...); err != nil {
+		return err
+	}
+	if err := unmarshalOperationResult(data, target); err != nil {
+		return fmt.Errorf("FHIR operation result unmarshal failed (%s %s): %w", httpRequest.Method, httpRequest.URL.String(), err)
+	}
+	return nil
+}
+
+// unmarshalOperationResult unmarshals the result of an operation into the target, unwrapping a single "return" resource.
+func unmarshalOperationResult(data []byte, target any) error {
+	if target == nil || len(data) == 0 {
+		return nil
+	}
+	codec := detectCodec(data)
+	switch t := target.(type) {
+	case *[]byte:
+		*t = data
+		return nil
+	case *fhir.Parameters:
+		return codec.Unmarshal(data, t)
+	case *Parameters:
+		var result fhir.Parameters
+		if err := codec.Unmarshal(data, &result); err != nil {
+			return err
+		}
+		*t = *ParametersOf(result)
+		return nil
+	}
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := codec.Unmarshal(data, &resource); err != nil {
+		return err
+	}
+	if resource.ResourceType == "Parameters" {
+		var result fhir.Parameters
+		if err := codec.Unmarshal(data, &result); err != nil {
+			return err
+		}
+		if len(result.Parameter) == 1 && result.Parameter[0].Name == "return" && len(result.Parameter[0].Resource) > 0 {
+			// Resources in Parameters are always JSON, since the XML codec converts them
+			return json.Unmarshal(result.Parameter[0].Resource, target)
+		}
+	}
+	return codec.Unmarshal(data, target)
+}
+
+// Parameters builds and reads Parameters resources, used as input and output of operations.
+// Builder methods add a parameter and return the Parameters, so they can be chained.
+// Errors (e.g. invalid resources) are returned by the Parameters method.
+type Parameters struct {
+	parameters []fhir.ParametersParameter
+	err        error
+}
+
+// NewParameters creates an empty Parameters builder.
+func NewParameters() *Parameters {
+	return &Parameters{}
+}
+
+// ParametersOf creates Parameters from a Parameters resource, e.g. to read the result of an operation.
+func ParametersOf(parameters fhir.Parameters) *Parameters {
+	return &Parameters{parameters: parameters.Parameter}
+}
+
+// Parameters returns the Parameters resource, or the first error that occurred while building it.
+func (p *Parameters) Parameters() (fhir.Parameters, error) {
+	if p.err != nil {
+		return fhir.Parameters{}, p.err
+	}
+	return fhir.Parameters{Parameter: append([]fhir.ParametersParameter(nil), p.parameters...)}, nil
+}
+
+// Add adds a parameter with the given name. The value is specified as ParametersParameter with the applicable value[x] field set,
+// e.g. fhir.ParametersParameter{ValueCode: &status}.
+func (p *Parameters) Add(name string, value fhir.ParametersParameter) *Parameters {
+	value.Name = name
+	p.parameters = append(p.parameters, value)
+	return p
+}
+
+// String adds a parameter with a string value.
+func (p *Parameters) String(name string, value string) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueString: &value})
+}
+
+// Code adds a parameter with a code value.
+func (p *Parameters) Code(name string, value string) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueCode: &value})
+}
+
+// URI adds a parameter with a uri value.
+func (p *Parameters) URI(name string, value string) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueUri: &value})
+}
+
+// Canonical adds a parameter with a canonical value, e.g. the URL of a ValueSet.
+func (p *Parameters) Canonical(name string, value string) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueCanonical: &value})
+}
+
+// Boolean adds a parameter with a boolean value.
+func (p *Parameters) Boolean(name string, value bool) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueBoolean: &value})
+}
+
+// Integer adds a parameter with an integer value.
+func (p *Parameters) Integer(name string, value int) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueInteger: &value})
+}
+
+// Decimal adds a parameter with a decimal value.
+func (p *Parameters) Decimal(name string, value float64) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueDecimal: &value})
+}
+
+// Date adds a parameter with a date value. Use DateTime if the time is relevant.
+func (p *Parameters) Date(name string, value time.Time) *Parameters {
+	date := value.Format(time.DateOnly)
+	return p.Add(name, fhir.ParametersParameter{ValueDate: &date})
+}
+
+// DateTime adds a parameter with a dateTime value.
+func (p *Parameters) DateTime(name string, value time.Time) *Parameters {
+	dateTime := value.Format(time.RFC3339)
+	return p.Add(name, fhir.ParametersParameter{ValueDateTime: &dateTime})
+}
+
+// Coding adds a parameter with a Coding value.
+func (p *Parameters) Coding(name string, value fhir.Coding) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueCoding: &value})
+}
+
+// Identifier adds a parameter with an Identifier value.
+func (p *Parameters) Identifier(name string, value fhir.Identifier) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueIdentifier: &value})
+}
+
+// Reference adds a parameter with a Reference value.
+func (p *Parameters) Reference(name string, value fhir.Reference) *Parameters {
+	return p.Add(name, fhir.ParametersParameter{ValueReference: &value})
+}
+
+// Resource adds a parameter containing the given resource.
+func (p *Parameters) Resource(name string, resource any) *Parameters {
+	desc, err := DescribeResource(resource)
+	if err != nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("parameter %s: %w", name, err)
+		}
+		return p
+	}
+	return p.Add(name, fhir.ParametersParameter{Resource: desc.Data})
+}
+
+// Part adds a multi-part parameter, of which the parts are the given parameters.
+func (p *Parameters) Part(name string, parts *Parameters) *Parameters {
+	if parts.err != nil && p.err == nil {
+		p.err = fmt.Errorf("parameter %s: %w", name, parts.err)
+	}
+	return p.Add(name, fhir.ParametersParameter{Part: append([]fhir.ParametersParameter(nil), parts.parameters...)})
+}
+
+// Value returns the first parameter with the given name.
+func (p *Parameters) Value(name string) (fhir.ParametersParameter, bool) {
+	for _, parameter := range p.parameters {
+		if parameter.Name == name {
+			return parameter, true
+		}
+	}
+	return fhir.ParametersParameter{}, false
+}
+
+// Values returns all parameters with the given name.
+func (p *Parameters) Values(name string) []fhir.ParametersParameter {
+	var result []fhir.ParametersParameter
+	for _, parameter := range p.parameters {
+		if parameter.Name == name {
+			result = append(result, parameter)
+		}
+	}
+	return result
+}
+
+// StringValue returns the primitive value (e.g. string, code, uri or dateTime) of the first parameter with the given name, formatted as string.
+func (p *Parameters) StringValue(name string) (string, bool) {
+	parameter, ok := p.Value(name)
+	if !ok {
+		return "", false
+	}
+	return primitiveValue(parameter)
+}
+
+// BooleanValue returns the boolean value of the first parameter with the given name.
+func (p *Parameters) BooleanValue(name string) (bool, bool) {
+	parameter, ok := p.Value(name)
+	if !ok || parameter.ValueBoolean == nil {
+		return false, false
+	}
+	return *parameter.ValueBoolean, true
+}
+
+// IntegerValue returns the integer (integer, positiveInt or unsignedInt) value of the first parameter with the given name.
+func (p *Parameters) IntegerValue(name string) (int, bool) {
+	parameter, ok := p.Value(name)
+	if !ok {
+		return 0, false
+	}
+	for _, value := range []*int{parameter.ValueInteger, parameter.ValuePositiveInt, parameter.ValueUnsignedInt} {
+		if value != nil {
+			return *value, true
+		}
+	}
+	return 0, false
+}
+
+// ResourceValue unmarshals the resource of the first parameter with the given name into the target.
+// It returns false if there is no such parameter, or it doesn't contain a resource.
+func (p *Parameters) ResourceValue(name string, target any) (bool, error) {
+	parameter, ok := p.Value(name)
+	if !ok || len(parameter.Resource) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(parameter.Resource, target); err != nil {
+		return true, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// PartValue returns the parts of the first multi-part parameter with the given name.
+func (p *Parameters) PartValue(name string) (*Parameters, bool) {
+	parameter, ok := p.Value(name)
+	if !ok || len(parameter.Part) == 0 {
+		return nil, false
+	}
+	return &Parameters{parameters: parameter.Part}, true
+}
+
+// PartValues returns the parts of all multi-part parameters with the given name, e.g. the designations returned by CodeSystem/$lookup.
+func (p *Parameters) PartValues(name string) []*Parameters {
+	var result []*Parameters
+	for _, parameter := range p.Values(name) {
+		result = append(result, &Parameters{parameters: parameter.Part})
+	}
+	return result
+}
+
+// query encodes the parameters as query, which requires all parameters to have a primitive value.
+func (p *Parameters) query() (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	query := url.Values{}
+	for _, parameter := range p.parameters {
+		value, ok := primitiveValue(parameter)
+		if !ok {
+			return "", fmt.Errorf("parameter %s can't be sent using GET, since it doesn't have a primitive value", parameter.Name)
+		}
+		query.Add(parameter.Name, value)
+	}
+	return query.Encode(), nil
+}
+
+// primitiveValue returns the primitive value of the parameter, formatted as string.
+func primitiveValue(parameter fhir.ParametersParameter) (string, bool) {
+	for _, value := range []*string{
+		parameter.ValueString, parameter.ValueCode, parameter.ValueUri, parameter.ValueUrl, parameter.ValueCanonical,
+		parameter.ValueID, parameter.ValueOid, parameter.ValueUuid, parameter.ValueMarkdown, parameter.ValueBase64Binary,
+		parameter.ValueDate, parameter.ValueDateTime, parameter.ValueInstant, parameter.ValueTime,
+	} {
+		if value != nil {
+			return *value, true
+		}
+	}
+	for _, value := range []*int{parameter.ValueInteger, parameter.ValuePositiveInt, parameter.ValueUnsignedInt} {
+		if value != nil {
+			return strconv.Itoa(*value), true
+		}
+	}
+	if parameter.ValueBoolean != nil {
+		return strconv.FormatBool(*parameter.ValueBoolean), true
+	}
+	if parameter.ValueDecimal != nil {
+		return strconv.FormatFloat(*parameter.ValueDecimal, 'f', -1, 64), true
+	}
+	return "", false
+}
@@ -16,7 +16,6 @@
 package fhirclient
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -32,7 +31,7 @@ func checkForOperationOutcomeError(data []byte, errorEvenWithoutIssue bool, http
 	}
 	var ooc OperationOutcomeError
 
-	if err := json.Unmarshal(data, &ooc); err != nil {
+	if err := detectCodec(data).Unmarshal(data, &ooc); err != nil {
 		// We're only checking for an OperationOutcome, not for malformed JSON.
 		return nil
 	}
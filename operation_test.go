@@ -0,0 +1,187 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestBaseClient_Operation(t *testing.T) {
+	ctx := context.Background()
+	t.Run("instance level using POST", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.Bundle{Type: fhir.BundleTypeSearchset})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		var result fhir.Bundle
+		err := client.OperationWithContext(ctx, "Patient/1", "$everything", fhirclient.NewParameters().Integer("_count", 10), &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodPost, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Patient/1/$everything", stub.request.URL.String())
+		assert.Equal(t, fhirclient.FhirJsonMediaType, stub.request.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `{"resourceType":"Parameters","parameter":[{"name":"_count","valueInteger":10}]}`, string(body))
+		assert.Equal(t, fhir.BundleTypeSearchset, result.Type)
+	})
+	t.Run("type level with resource parameter", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.OperationOutcome{Issue: []fhir.OperationOutcomeIssue{{Severity: fhir.IssueSeverityInformation, Code: fhir.IssueTypeInformational}}})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		var result fhir.OperationOutcome
+		err := client.OperationWithContext(ctx, "Patient", "validate", fhirclient.NewParameters().Resource("resource", fhir.Patient{ID: ptr("1")}), &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/Patient/$validate", stub.request.URL.String())
+		body, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `{"resourceType":"Parameters","parameter":[{"name":"resource","resource":{"resourceType":"Patient","id":"1"}}]}`, string(body))
+		require.Len(t, result.Issue, 1)
+	})
+	t.Run("system level without parameters", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.Parameters{})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		err := client.Operation("", "$custom", nil, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/fhir/$custom", stub.request.URL.String())
+		body, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `{"resourceType":"Parameters"}`, string(body))
+	})
+	t.Run("GET unwraps return resource", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.Parameters{Parameter: []fhir.ParametersParameter{
+			{Name: "return", Resource: json.RawMessage(`{"resourceType":"ValueSet","status":"active","url":"http://example.com/vs"}`)},
+		}})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		var result fhir.ValueSet
+		err := client.OperationWithContext(ctx, "ValueSet", "$expand", fhirclient.NewParameters().URI("url", "http://example.com/vs").Integer("count", 5), &result, fhirclient.OperationUsingGET())
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodGet, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/ValueSet/$expand?count=5&url=http%3A%2F%2Fexample.com%2Fvs", stub.request.URL.String())
+		assert.Nil(t, stub.request.Body)
+		assert.Equal(t, "http://example.com/vs", *result.Url)
+	})
+	t.Run("GET with non-primitive parameter", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		err := client.OperationWithContext(ctx, "CodeSystem", "$lookup", fhirclient.NewParameters().Coding("coding", fhir.Coding{Code: ptr("x")}), nil, fhirclient.OperationUsingGET())
+
+		assert.EqualError(t, err, "operation $lookup: parameter coding can't be sent using GET, since it doesn't have a primitive value")
+	})
+	t.Run("result into Parameters", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.Parameters{Parameter: []fhir.ParametersParameter{
+			{Name: "display", ValueString: ptr("Hypertension")},
+			{Name: "abstract", ValueBoolean: ptr(false)},
+			{Name: "designation", Part: []fhir.ParametersParameter{{Name: "language", ValueCode: ptr("nl")}, {Name: "value", ValueString: ptr("Hypertensie")}}},
+			{Name: "designation", Part: []fhir.ParametersParameter{{Name: "language", ValueCode: ptr("de")}, {Name: "value", ValueString: ptr("Hypertonie")}}},
+		}})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		var result fhirclient.Parameters
+		err := client.OperationWithContext(ctx, "CodeSystem", "$lookup", fhirclient.NewParameters().
+			URI("system", "http://snomed.info/sct").
+			Code("code", "38341003"), &result, fhirclient.OperationUsingGET())
+
+		require.NoError(t, err)
+		display, ok := result.StringValue("display")
+		assert.True(t, ok)
+		assert.Equal(t, "Hypertension", display)
+		abstract, ok := result.BooleanValue("abstract")
+		assert.True(t, ok)
+		assert.False(t, abstract)
+		designations := result.PartValues("designation")
+		require.Len(t, designations, 2)
+		language, _ := designations[1].StringValue("language")
+		assert.Equal(t, "de", language)
+		_, ok = result.StringValue("missing")
+		assert.False(t, ok)
+	})
+	t.Run("error", func(t *testing.T) {
+		stub := &requestResponder{response: &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		err := client.OperationWithContext(ctx, "Patient/1", "$everything", nil, nil)
+
+		assert.ErrorContains(t, err, "status=404")
+	})
+}
+
+func TestParameters(t *testing.T) {
+	t.Run("build", func(t *testing.T) {
+		parameters, err := fhirclient.NewParameters().
+			String("string", "foo").
+			Boolean("boolean", true).
+			Decimal("decimal", 1.5).
+			Date("date", time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)).
+			DateTime("dateTime", time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)).
+			Reference("reference", fhir.Reference{Reference: ptr("Patient/1")}).
+			Part("multi", fhirclient.NewParameters().Code("code", "x").Canonical("canonical", "http://example.com")).
+			Parameters()
+
+		require.NoError(t, err)
+		data, _ := json.Marshal(parameters)
+		assert.JSONEq(t, `{"resourceType":"Parameters","parameter":[
+			{"name":"string","valueString":"foo"},
+			{"name":"boolean","valueBoolean":true},
+			{"name":"decimal","valueDecimal":1.5},
+			{"name":"date","valueDate":"2024-02-01"},
+			{"name":"dateTime","valueDateTime":"2024-02-01T12:00:00Z"},
+			{"name":"reference","valueReference":{"reference":"Patient/1"}},
+			{"name":"multi","part":[{"name":"code","valueCode":"x"},{"name":"canonical","valueCanonical":"http://example.com"}]}
+		]}`, string(data))
+	})
+	t.Run("invalid resource", func(t *testing.T) {
+		_, err := fhirclient.NewParameters().
+			Part("multi", fhirclient.NewParameters().Resource("resource", map[string]string{"id": "1"})).
+			Parameters()
+
+		assert.EqualError(t, err, "parameter multi: parameter resource: resourceType not present in resource of type map[string]string")
+	})
+	t.Run("read", func(t *testing.T) {
+		parameters := fhirclient.ParametersOf(fhir.Parameters{Parameter: []fhir.ParametersParameter{
+			{Name: "count", ValuePositiveInt: ptr(3)},
+			{Name: "decimal", ValueDecimal: ptr(2.25)},
+			{Name: "patient", Resource: json.RawMessage(`{"resourceType":"Patient","id":"1"}`)},
+			{Name: "patient", Resource: json.RawMessage(`{"resourceType":"Patient","id":"2"}`)},
+		}})
+
+		count, ok := parameters.IntegerValue("count")
+		assert.True(t, ok)
+		assert.Equal(t, 3, count)
+		decimal, _ := parameters.StringValue("decimal")
+		assert.Equal(t, "2.25", decimal)
+		var patient fhir.Patient
+		ok, err := parameters.ResourceValue("patient", &patient)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "1", *patient.ID)
+		assert.Len(t, parameters.Values("patient"), 2)
+		_, ok = parameters.PartValue("patient")
+		assert.False(t, ok)
+	})
+}
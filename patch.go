@@ -0,0 +1,228 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+const JsonPatchMediaType = "application/json-patch+json"
+
+// JSONPatch is a JSON Patch document (RFC 6902), which can be passed to Patch.
+type JSONPatch []JSONPatchOperation
+
+// JSONPatchOperation is a single operation of a JSON Patch document.
+type JSONPatchOperation struct {
+	// Op is the operation: add, remove, replace, move, copy or test.
+	Op string `json:"op"`
+	// Path is the JSON Pointer (RFC 6901) to the target location, e.g. /status or /input/0/valueString.
+	Path string `json:"path"`
+	// From is the JSON Pointer to the source location, for move and copy operations.
+	From string `json:"from,omitempty"`
+	// Value is the value to add, replace or test.
+	Value any `json:"value,omitempty"`
+}
+
+func (o JSONPatchOperation) MarshalJSON() ([]byte, error) {
+	result := map[string]any{
+		"op":   o.Op,
+		"path": o.Path,
+	}
+	if o.From != "" {
+		result["from"] = o.From
+	}
+	switch o.Op {
+	case "add", "replace", "test":
+		// value is required for these operations, even if it's null
+		result["value"] = o.Value
+	}
+	return json.Marshal(result)
+}
+
+// DiffJSONPatch computes the JSON Patch document that transforms the original resource into the modified resource.
+// Arrays of which the length changed are replaced as a whole.
+func DiffJSONPatch(original any, modified any) (JSONPatch, error) {
+	originalValue, err := toJSONValue(original)
+	if err != nil {
+		return nil, fmt.Errorf("invalid original resource of type %T: %w", original, err)
+	}
+	modifiedValue, err := toJSONValue(modified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modified resource of type %T: %w", modified, err)
+	}
+	result := JSONPatch{}
+	diffJSONValue("", originalValue, modifiedValue, &result)
+	return result, nil
+}
+
+func diffJSONValue(path string, original any, modified any, result *JSONPatch) {
+	switch originalValue := original.(type) {
+	case map[string]any:
+		modifiedValue, ok := modified.(map[string]any)
+		if !ok {
+			break
+		}
+		var keys []string
+		for key := range originalValue {
+			keys = append(keys, key)
+		}
+		for key := range modifiedValue {
+			if _, exists := originalValue[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			keyPath := path + "/" + escapeJSONPointer(key)
+			originalChild, inOriginal := originalValue[key]
+			modifiedChild, inModified := modifiedValue[key]
+			switch {
+			case !inModified:
+				*result = append(*result, JSONPatchOperation{Op: "remove", Path: keyPath})
+			case !inOriginal:
+				*result = append(*result, JSONPatchOperation{Op: "add", Path: keyPath, Value: modifiedChild})
+			default:
+				diffJSONValue(keyPath, originalChild, modifiedChild, result)
+			}
+		}
+		return
+	case []any:
+		modifiedValue, ok := modified.([]any)
+		if !ok || len(originalValue) != len(modifiedValue) {
+			break
+		}
+		for i := range originalValue {
+			diffJSONValue(path+"/"+strconv.Itoa(i), originalValue[i], modifiedValue[i], result)
+		}
+		return
+	}
+	if !reflect.DeepEqual(original, modified) {
+		*result = append(*result, JSONPatchOperation{Op: "replace", Path: path, Value: modified})
+	}
+}
+
+// escapeJSONPointer escapes a JSON Pointer reference token as specified by RFC 6901.
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func toJSONValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FHIRPathPatch builds a FHIRPath Patch document: a Parameters resource containing the patch operations.
+// It can be passed to Patch. Values are specified as ParametersParameter with the applicable value[x] field set,
+// e.g. fhir.ParametersParameter{ValueCode: &status}.
+type FHIRPathPatch struct {
+	operations []fhir.ParametersParameter
+}
+
+// NewFHIRPathPatch creates an empty FHIRPath Patch document.
+func NewFHIRPathPatch() *FHIRPathPatch {
+	return &FHIRPathPatch{}
+}
+
+// Add adds an element with the given name and value to the element(s) at the given FHIRPath expression.
+func (p *FHIRPathPatch) Add(path string, name string, value fhir.ParametersParameter) *FHIRPathPatch {
+	return p.operation("add", path, stringPart("name", name), valuePart(value))
+}
+
+// Insert inserts the value into the list at the given FHIRPath expression, at the given index.
+func (p *FHIRPathPatch) Insert(path string, value fhir.ParametersParameter, index int) *FHIRPathPatch {
+	return p.operation("insert", path, valuePart(value), integerPart("index", index))
+}
+
+// Delete deletes the element at the given FHIRPath expression.
+func (p *FHIRPathPatch) Delete(path string) *FHIRPathPatch {
+	return p.operation("delete", path)
+}
+
+// Replace replaces the value of the element at the given FHIRPath expression.
+func (p *FHIRPathPatch) Replace(path string, value fhir.ParametersParameter) *FHIRPathPatch {
+	return p.operation("replace", path, valuePart(value))
+}
+
+// Move moves an element within the list at the given FHIRPath expression, from the source index to the destination index.
+func (p *FHIRPathPatch) Move(path string, source int, destination int) *FHIRPathPatch {
+	return p.operation("move", path, integerPart("source", source), integerPart("destination", destination))
+}
+
+// Parameters returns the FHIRPath Patch document as Parameters resource.
+func (p *FHIRPathPatch) Parameters() fhir.Parameters {
+	return fhir.Parameters{Parameter: slices.Clone(p.operations)}
+}
+
+func (p *FHIRPathPatch) operation(operationType string, path string, parts ...fhir.ParametersParameter) *FHIRPathPatch {
+	operation := fhir.ParametersParameter{
+		Name: "operation",
+		Part: []fhir.ParametersParameter{
+			{Name: "type", ValueCode: &operationType},
+			stringPart("path", path),
+		},
+	}
+	operation.Part = append(operation.Part, parts...)
+	p.operations = append(p.operations, operation)
+	return p
+}
+
+func stringPart(name string, value string) fhir.ParametersParameter {
+	return fhir.ParametersParameter{Name: name, ValueString: &value}
+}
+
+func integerPart(name string, value int) fhir.ParametersParameter {
+	return fhir.ParametersParameter{Name: name, ValueInteger: &value}
+}
+
+func valuePart(value fhir.ParametersParameter) fhir.ParametersParameter {
+	value.Name = "value"
+	return value
+}
+
+// marshalPatch marshals the given patch document and returns it along with its media type.
+// FHIRPath Patch documents are encoded using the given codec, JSON Patch documents are always JSON.
+func marshalPatch(patch any, codec Codec) ([]byte, string, error) {
+	mediaType := codec.MediaType()
+	switch p := patch.(type) {
+	case JSONPatch, []JSONPatchOperation:
+		codec = JSONCodec
+		mediaType = JsonPatchMediaType
+	case fhir.Parameters, *fhir.Parameters:
+	case *FHIRPathPatch:
+		patch = p.Parameters()
+	default:
+		return nil, "", fmt.Errorf("unsupported patch document of type %T (expected JSONPatch, FHIRPathPatch or Parameters)", patch)
+	}
+	data, err := codec.Marshal(patch)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid patch document: %w", err)
+	}
+	return data, mediaType, nil
+}
@@ -0,0 +1,172 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestBaseClient_PatchWithContext(t *testing.T) {
+	t.Run("JSON Patch", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(Resource{Id: "123"}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		var result Resource
+
+		err := client.PatchWithContext(context.Background(), "Resource/123", fhirclient.JSONPatch{
+			{Op: "replace", Path: "/status", Value: "completed"},
+			{Op: "remove", Path: "/note"},
+		}, &result)
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", result.Id)
+		assert.Equal(t, http.MethodPatch, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Resource/123", stub.request.URL.String())
+		assert.Equal(t, fhirclient.JsonPatchMediaType, stub.request.Header.Get("Content-Type"))
+		requestBody, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `[{"op":"replace","path":"/status","value":"completed"},{"op":"remove","path":"/note"}]`, string(requestBody))
+	})
+	t.Run("FHIRPath Patch", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(Resource{Id: "123"}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		status := "completed"
+
+		err := client.Patch("Resource/123", fhirclient.NewFHIRPathPatch().Replace("Task.status", fhir.ParametersParameter{ValueCode: &status}), nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, fhirclient.FhirJsonMediaType, stub.request.Header.Get("Content-Type"))
+		requestBody, _ := io.ReadAll(stub.request.Body)
+		assert.JSONEq(t, `{
+			"resourceType": "Parameters",
+			"parameter": [{
+				"name": "operation",
+				"part": [
+					{"name": "type", "valueCode": "replace"},
+					{"name": "path", "valueString": "Task.status"},
+					{"name": "value", "valueCode": "completed"}
+				]
+			}]
+		}`, string(requestBody))
+	})
+	t.Run("unsupported patch document", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		err := client.Patch("Resource/123", map[string]string{}, nil)
+
+		assert.EqualError(t, err, "unsupported patch document of type map[string]string (expected JSONPatch, FHIRPathPatch or Parameters)")
+	})
+}
+
+func TestFHIRPathPatch_Parameters(t *testing.T) {
+	value := "note"
+	patch := fhirclient.NewFHIRPathPatch().
+		Add("Task", "note", fhir.ParametersParameter{ValueString: &value}).
+		Insert("Task.input", fhir.ParametersParameter{ValueString: &value}, 1).
+		Delete("Task.output").
+		Move("Task.input", 0, 2)
+
+	data, err := json.Marshal(patch.Parameters())
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"resourceType": "Parameters",
+		"parameter": [
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "add"},
+				{"name": "path", "valueString": "Task"},
+				{"name": "name", "valueString": "note"},
+				{"name": "value", "valueString": "note"}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "insert"},
+				{"name": "path", "valueString": "Task.input"},
+				{"name": "value", "valueString": "note"},
+				{"name": "index", "valueInteger": 1}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "delete"},
+				{"name": "path", "valueString": "Task.output"}
+			]},
+			{"name": "operation", "part": [
+				{"name": "type", "valueCode": "move"},
+				{"name": "path", "valueString": "Task.input"},
+				{"name": "source", "valueInteger": 0},
+				{"name": "destination", "valueInteger": 2}
+			]}
+		]
+	}`, string(data))
+}
+
+func TestDiffJSONPatch(t *testing.T) {
+	t.Run("changes", func(t *testing.T) {
+		original := map[string]interface{}{
+			"resourceType": "Task",
+			"status":       "requested",
+			"note":         []interface{}{map[string]interface{}{"text": "hello"}},
+			"input":        []interface{}{"a", "b"},
+			"for":          map[string]interface{}{"reference": "Patient/1"},
+			"a/b":          "old",
+		}
+		modified := map[string]interface{}{
+			"resourceType": "Task",
+			"status":       "completed",
+			"input":        []interface{}{"a", "b", "c"},
+			"for":          map[string]interface{}{"reference": "Patient/1", "display": "John"},
+			"a/b":          nil,
+		}
+
+		patch, err := fhirclient.DiffJSONPatch(original, modified)
+
+		require.NoError(t, err)
+		data, _ := json.Marshal(patch)
+		assert.JSONEq(t, `[
+			{"op": "replace", "path": "/a~1b", "value": null},
+			{"op": "add", "path": "/for/display", "value": "John"},
+			{"op": "replace", "path": "/input", "value": ["a", "b", "c"]},
+			{"op": "remove", "path": "/note"},
+			{"op": "replace", "path": "/status", "value": "completed"}
+		]`, string(data))
+	})
+	t.Run("no changes", func(t *testing.T) {
+		resource := fhir.Task{Status: fhir.TaskStatusRequested}
+
+		patch, err := fhirclient.DiffJSONPatch(resource, resource)
+
+		require.NoError(t, err)
+		assert.Empty(t, patch)
+	})
+	t.Run("array element changed", func(t *testing.T) {
+		patch, err := fhirclient.DiffJSONPatch(
+			map[string]interface{}{"input": []interface{}{"a", "b"}},
+			map[string]interface{}{"input": []interface{}{"a", "c"}},
+		)
+
+		require.NoError(t, err)
+		assert.Equal(t, fhirclient.JSONPatch{{Op: "replace", Path: "/input/1", Value: "c"}}, patch)
+	})
+}
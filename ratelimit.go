@@ -0,0 +1,204 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimit configures client-side limiting of the requests sent to FHIR servers, to prevent them from throttling the client.
+// Limits apply per host: if Config.AllowOutsideBaseURLRequests is enabled, every host the client sends requests to has its own limits.
+// Retries and asynchronous status polls count as requests as well.
+type RateLimit struct {
+	// RequestsPerSecond is the sustained number of requests per second (token bucket refill rate). If zero, the request rate isn't limited.
+	RequestsPerSecond float64
+	// Burst is the maximum number of requests that can be sent at once, after a period of inactivity (token bucket size).
+	// If zero, it's 1.
+	Burst int
+	// MaxInFlight is the maximum number of concurrent requests. A request is in flight until its response body has been read.
+	// If zero, the number of concurrent requests isn't limited.
+	MaxInFlight int
+}
+
+// RateLimitStats contains metrics about the time requests waited for the rate limiter.
+type RateLimitStats struct {
+	// Requests is the number of requests that passed the rate limiter.
+	Requests int64
+	// Delayed is the number of requests that had to wait for the rate limiter.
+	Delayed int64
+	// TotalWait is the total time requests waited for the rate limiter.
+	TotalWait time.Duration
+	// MaxWait is the longest time a request waited for the rate limiter.
+	MaxWait time.Duration
+}
+
+// RateLimitStats returns metrics about the time requests waited for the rate limiter (see Config.RateLimit).
+func (d BaseClient) RateLimitStats() RateLimitStats {
+	if d.rateLimiter == nil {
+		return RateLimitStats{}
+	}
+	d.rateLimiter.mux.Lock()
+	defer d.rateLimiter.mux.Unlock()
+	return d.rateLimiter.stats
+}
+
+// rateLimiter enforces a RateLimit. It's shared by copies of the BaseClient.
+type rateLimiter struct {
+	config RateLimit
+	mux    sync.Mutex
+	hosts  map[string]*hostLimiter
+	stats  RateLimitStats
+}
+
+// hostLimiter contains the token bucket and in-flight slots for a single host.
+type hostLimiter struct {
+	tokens     float64
+	lastRefill time.Time
+	inFlight   chan struct{}
+}
+
+func newRateLimiter(config RateLimit) *rateLimiter {
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return &rateLimiter{
+		config: config,
+		hosts:  map[string]*hostLimiter{},
+	}
+}
+
+// acquire waits until the request may be sent. It returns a function that must be called when the request has completed.
+// If the context is cancelled or its deadline would pass before the request may be sent, an error is returned.
+func (l *rateLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	start := time.Now()
+	l.mux.Lock()
+	limiter := l.host(host)
+	delay := l.reserve(limiter, start)
+	l.mux.Unlock()
+	if delay > 0 {
+		if deadline, ok := ctx.Deadline(); ok && start.Add(delay).After(deadline) {
+			l.cancelReservation(limiter)
+			return nil, fmt.Errorf("rate limit: waiting %s would exceed context deadline: %w", delay, context.DeadlineExceeded)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			l.cancelReservation(limiter)
+			return nil, fmt.Errorf("rate limit: %w", err)
+		}
+	}
+	release := func() {}
+	if limiter.inFlight != nil {
+		select {
+		case limiter.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			l.cancelReservation(limiter)
+			return nil, fmt.Errorf("rate limit: %w", ctx.Err())
+		}
+		var once sync.Once
+		release = func() {
+			once.Do(func() { <-limiter.inFlight })
+		}
+	}
+	l.record(time.Since(start))
+	return release, nil
+}
+
+// host returns the limiter for the given host, creating it if needed. The caller must hold the lock.
+func (l *rateLimiter) host(host string) *hostLimiter {
+	result, ok := l.hosts[host]
+	if !ok {
+		result = &hostLimiter{tokens: float64(l.config.Burst)}
+		if l.config.MaxInFlight > 0 {
+			result.inFlight = make(chan struct{}, l.config.MaxInFlight)
+		}
+		l.hosts[host] = result
+	}
+	return result
+}
+
+// reserve takes a token from the host's bucket, and returns how long to wait until the token is available.
+// The caller must hold the lock.
+func (l *rateLimiter) reserve(limiter *hostLimiter, now time.Time) time.Duration {
+	if l.config.RequestsPerSecond <= 0 {
+		return 0
+	}
+	if !limiter.lastRefill.IsZero() {
+		limiter.tokens += now.Sub(limiter.lastRefill).Seconds() * l.config.RequestsPerSecond
+		limiter.tokens = min(limiter.tokens, float64(l.config.Burst))
+	}
+	limiter.lastRefill = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / l.config.RequestsPerSecond * float64(time.Second))
+}
+
+// cancelReservation returns the token of a request that won't be sent.
+func (l *rateLimiter) cancelReservation(limiter *hostLimiter) {
+	if l.config.RequestsPerSecond <= 0 {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	limiter.tokens = min(limiter.tokens+1, float64(l.config.Burst))
+}
+
+func (l *rateLimiter) record(wait time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.stats.Requests++
+	// Ignore scheduling noise
+	if wait >= time.Millisecond {
+		l.stats.Delayed++
+		l.stats.TotalWait += wait
+		l.stats.MaxWait = max(l.stats.MaxWait, wait)
+	}
+}
+
+// send sends the HTTP request, waiting for the rate limiter (if configured).
+// The in-flight slot is released when the response body is closed.
+func (d BaseClient) send(httpRequest *http.Request) (*http.Response, error) {
+	if d.rateLimiter == nil {
+		return d.httpClient.Do(httpRequest)
+	}
+	release, err := d.rateLimiter.acquire(httpRequest.Context(), httpRequest.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	httpResponse, err := d.httpClient.Do(httpRequest)
+	if err != nil || httpResponse.Body == nil {
+		release()
+		return httpResponse, err
+	}
+	httpResponse.Body = &releasingBody{ReadCloser: httpResponse.Body, release: release}
+	return httpResponse, nil
+}
+
+// releasingBody is a response body that releases the request's in-flight slot when it's closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
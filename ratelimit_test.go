@@ -0,0 +1,222 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestRateLimit(t *testing.T) {
+	patientHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+		_, _ = w.Write([]byte(`{"resourceType":"Patient","id":"1"}`))
+	}
+	t.Run("requests per second", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(patientHandler), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{RequestsPerSecond: 20, Burst: 2},
+		})
+
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+		}
+
+		// First 2 requests are sent immediately (burst), the next 2 wait 50ms each
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+		stats := client.RateLimitStats()
+		assert.Equal(t, int64(4), stats.Requests)
+		assert.Equal(t, int64(2), stats.Delayed)
+		assert.GreaterOrEqual(t, stats.TotalWait, 90*time.Millisecond)
+		assert.GreaterOrEqual(t, stats.MaxWait, 40*time.Millisecond)
+	})
+	t.Run("wait exceeds context deadline", func(t *testing.T) {
+		var requests atomic.Int32
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			patientHandler(w, r)
+		}), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{RequestsPerSecond: 1},
+		})
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := client.ReadWithContext(ctx, "Patient/1", new(fhir.Patient))
+
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Less(t, time.Since(start), 50*time.Millisecond, "should fail without waiting")
+		assert.Equal(t, int32(1), requests.Load())
+	})
+	t.Run("max in flight", func(t *testing.T) {
+		var inFlight, maxInFlight atomic.Int32
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				observed := maxInFlight.Load()
+				if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			patientHandler(w, r)
+		}), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{MaxInFlight: 2},
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(2), maxInFlight.Load())
+		assert.Equal(t, int64(6), client.RateLimitStats().Requests)
+	})
+	t.Run("failing PostRequestOption releases in-flight slot", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(patientHandler), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{MaxInFlight: 1},
+		})
+		failingOption := fhirclient.PostRequestOption(func(client fhirclient.Client, r *http.Response) error {
+			return errors.New("failed")
+		})
+		require.EqualError(t, client.Read("Patient/1", new(fhir.Patient), failingOption), "failed")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := client.ReadWithContext(ctx, "Patient/1", new(fhir.Patient))
+
+		require.NoError(t, err)
+	})
+	t.Run("cancelled in-flight wait returns token", func(t *testing.T) {
+		unblock := make(chan struct{})
+		received := make(chan struct{}, 1)
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fhir/Patient/2" {
+				received <- struct{}{}
+				<-unblock
+			}
+			patientHandler(w, r)
+		}), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{RequestsPerSecond: 1, Burst: 2, MaxInFlight: 1},
+		})
+		done := make(chan error, 1)
+		go func() {
+			done <- client.Read("Patient/2", new(fhir.Patient))
+		}()
+		<-received
+		// Takes the second token, but can't get an in-flight slot
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.Error(t, client.ReadWithContext(ctx, "Patient/1", new(fhir.Patient)))
+		close(unblock)
+		require.NoError(t, <-done)
+
+		start := time.Now()
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+
+		assert.Less(t, time.Since(start), 500*time.Millisecond, "token should have been returned")
+	})
+	t.Run("ResolveRef doesn't wait for in-flight slot of resolving request", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+			if r.URL.Path == "/fhir/Observation/1" {
+				_, _ = w.Write([]byte(`{"resourceType":"Observation","id":"1","status":"final","code":{},"subject":{"reference":"Patient/1"}}`))
+				return
+			}
+			patientHandler(w, r)
+		}), &fhirclient.Config{
+			RateLimit: &fhirclient.RateLimit{MaxInFlight: 1},
+		})
+		var patient fhir.Patient
+		done := make(chan error, 1)
+		go func() {
+			// ResolveRef reads the reference without the request's context, so it would otherwise block forever
+			done <- client.Read("Observation/1", new(fhir.Observation), fhirclient.ResolveRef("subject", &patient))
+		}()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			assert.Equal(t, "1", *patient.ID)
+		case <-time.After(time.Second):
+			t.Fatal("ResolveRef blocked on the in-flight slot")
+		}
+	})
+	t.Run("async status polls don't wait for in-flight slot of initial request", func(t *testing.T) {
+		var polls atomic.Int32
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fhir/async/1" {
+				polls.Add(1)
+				_, _ = w.Write([]byte(`{"resourceType":"Bundle","type":"batch-response","entry":[{"resource":{"resourceType":"Patient","id":"1"},"response":{"status":"200 OK"}}]}`))
+				return
+			}
+			w.Header().Set("Content-Location", "http://example.com/fhir/async/1")
+			w.WriteHeader(http.StatusAccepted)
+		}), &fhirclient.Config{
+			RateLimit:         &fhirclient.RateLimit{MaxInFlight: 1},
+			AsyncPollInterval: time.Millisecond,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var patient fhir.Patient
+
+		err := client.ReadWithContext(ctx, "Patient/1", &patient, fhirclient.RespondAsync(nil))
+
+		require.NoError(t, err)
+		assert.Equal(t, "1", *patient.ID)
+		assert.Equal(t, int32(1), polls.Load())
+	})
+	t.Run("limits per host", func(t *testing.T) {
+		config := fhirclient.DefaultConfig()
+		config.AllowOutsideBaseURLRequests = true
+		config.RateLimit = &fhirclient.RateLimit{RequestsPerSecond: 1}
+		client := fhirclient.New(baseURL, handlerDoer(patientHandler), &config)
+		otherHost, _ := url.Parse("http://other.example.com/fhir/Patient/1")
+
+		start := time.Now()
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+		require.NoError(t, client.Read(otherHost.String(), new(fhir.Patient)))
+
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, int64(0), client.RateLimitStats().Delayed)
+	})
+	t.Run("not configured", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(patientHandler), nil)
+
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+
+		assert.Equal(t, fhirclient.RateLimitStats{}, client.RateLimitStats())
+	})
+}
@@ -0,0 +1,277 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// DefaultResolveConcurrency is the default maximum number of concurrent requests a ReferenceResolver performs.
+const DefaultResolveConcurrency = 4
+
+// maxIDsPerSearch is the maximum number of ids a ReferenceResolver searches for in a single _id search.
+const maxIDsPerSearch = 100
+
+// ReferenceResolver resolves the references of many resources at once, e.g. the subjects of all Observations in a search result.
+// References are collected using Add, de-duplicated, and fetched by Resolve using a single _id search per resource type
+// (or individual reads, see ResolveUsingReads), with a bounded number of concurrent requests.
+// References to contained resources, URNs and logical references (Reference.identifier) are not collected, use ResolveRef for those.
+// A ReferenceResolver is not safe for concurrent use.
+type ReferenceResolver struct {
+	client      Client
+	concurrency int
+	useReads    bool
+	// pending maps the references that haven't been resolved yet (relative to the FHIR base URL if possible)
+	// to the references as they were added.
+	pending  map[string][]string
+	resolved map[string]json.RawMessage
+}
+
+// ReferenceResolverOption configures a ReferenceResolver.
+type ReferenceResolverOption func(r *ReferenceResolver)
+
+// ResolveConcurrency sets the maximum number of concurrent requests. If not set, DefaultResolveConcurrency is used.
+func ResolveConcurrency(max int) ReferenceResolverOption {
+	return func(r *ReferenceResolver) {
+		r.concurrency = max
+	}
+}
+
+// ResolveUsingReads makes the ReferenceResolver read every resource individually,
+// instead of searching for all resources of a type using _id. Use it for FHIR servers that don't support searching on multiple ids.
+func ResolveUsingReads() ReferenceResolverOption {
+	return func(r *ReferenceResolver) {
+		r.useReads = true
+	}
+}
+
+// NewReferenceResolver creates a new ReferenceResolver that fetches resources using the given client.
+func NewReferenceResolver(client Client, opts ...ReferenceResolverOption) *ReferenceResolver {
+	result := &ReferenceResolver{
+		client:      client,
+		concurrency: DefaultResolveConcurrency,
+		pending:     map[string][]string{},
+		resolved:    map[string]json.RawMessage{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	if result.concurrency < 1 {
+		result.concurrency = 1
+	}
+	return result
+}
+
+// Add collects the references at the given path in the resource, to be resolved by Resolve.
+// The path has the same syntax as for ResolveRef, e.g. subject or participant.member.
+func (r *ReferenceResolver) Add(resource any, path string) error {
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	asMap, err := toMap(resource)
+	if err != nil {
+		return err
+	}
+	for _, match := range evaluatePath(asMap, segments) {
+		refMap, ok := match.value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("not a FHIR Reference at path: %s", path)
+		}
+		if ref, ok := refMap["reference"].(string); ok {
+			r.AddReference(ref)
+		}
+	}
+	return nil
+}
+
+// AddReference collects the given literal reference (e.g. Patient/123), to be resolved by Resolve.
+// References to contained resources and URNs are ignored.
+func (r *ReferenceResolver) AddReference(ref string) {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "urn:") {
+		return
+	}
+	normalized := r.normalize(ref)
+	if _, ok := r.resolved[normalized]; ok {
+		r.resolved[ref] = r.resolved[normalized]
+		return
+	}
+	if !slices.Contains(r.pending[normalized], ref) {
+		r.pending[normalized] = append(r.pending[normalized], ref)
+	}
+}
+
+// Resolve fetches the resources of the references that were added since the last call to Resolve.
+// It returns the resources of all references resolved so far, mapped by their reference as it was added.
+// References to resources that don't exist (or which the server didn't return) are absent from the result.
+func (r *ReferenceResolver) Resolve(ctx context.Context) (map[string]json.RawMessage, error) {
+	var tasks []func(ctx context.Context) (map[string]json.RawMessage, error)
+	idsByType := map[string][]string{}
+	for ref := range r.pending {
+		resourceType, id, isTypeAndID := splitReference(ref)
+		if r.useReads || !isTypeAndID {
+			tasks = append(tasks, r.readTask(ref))
+			continue
+		}
+		idsByType[resourceType] = append(idsByType[resourceType], id)
+	}
+	for resourceType, ids := range idsByType {
+		slices.Sort(ids)
+		for chunk := range slices.Chunk(ids, maxIDsPerSearch) {
+			tasks = append(tasks, r.searchTask(resourceType, chunk))
+		}
+	}
+	results, err := runConcurrently(ctx, r.concurrency, tasks)
+	if err != nil {
+		return nil, fmt.Errorf("resolve references: %w", err)
+	}
+	for normalized, refs := range r.pending {
+		resource, ok := results[normalized]
+		if !ok {
+			continue
+		}
+		r.resolved[normalized] = resource
+		for _, ref := range refs {
+			r.resolved[ref] = resource
+		}
+	}
+	clear(r.pending)
+	return maps.Clone(r.resolved), nil
+}
+
+// readTask reads the resource the (normalized) reference refers to.
+// If the resource doesn't exist (404 Not Found or 410 Gone), the reference is left unresolved.
+func (r *ReferenceResolver) readTask(ref string) func(ctx context.Context) (map[string]json.RawMessage, error) {
+	return func(ctx context.Context) (map[string]json.RawMessage, error) {
+		var resource json.RawMessage
+		var statusCode int
+		if err := r.client.ReadWithContext(ctx, ref, &resource, ResponseStatusCode(&statusCode)); err != nil {
+			if statusCode == http.StatusNotFound || statusCode == http.StatusGone {
+				return map[string]json.RawMessage{}, nil
+			}
+			return nil, err
+		}
+		return map[string]json.RawMessage{ref: resource}, nil
+	}
+}
+
+// searchTask searches for the resources of the given type with the given ids, following pagination links.
+func (r *ReferenceResolver) searchTask(resourceType string, ids []string) func(ctx context.Context) (map[string]json.RawMessage, error) {
+	return func(ctx context.Context) (map[string]json.RawMessage, error) {
+		query := url.Values{
+			"_id":    []string{strings.Join(ids, ",")},
+			"_count": []string{strconv.Itoa(len(ids))},
+		}
+		var searchSet fhir.Bundle
+		if err := r.client.SearchWithContext(ctx, resourceType, query, &searchSet); err != nil {
+			return nil, err
+		}
+		result := map[string]json.RawMessage{}
+		err := Paginate(ctx, r.client, searchSet, func(bundle *fhir.Bundle) (bool, error) {
+			for _, entry := range bundle.Entry {
+				if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fhir.SearchEntryModeMatch {
+					continue
+				}
+				desc, err := DescribeResource([]byte(entry.Resource))
+				if err != nil || desc.Type != resourceType {
+					continue
+				}
+				if id := resourceID(entry.Resource); slices.Contains(ids, id) {
+					result[resourceType+"/"+id] = entry.Resource
+				}
+			}
+			return true, nil
+		})
+		return result, err
+	}
+}
+
+// normalize makes the reference relative to the FHIR base URL, if it's an absolute URL within the base URL.
+func (r *ReferenceResolver) normalize(ref string) string {
+	baseURL := strings.TrimSuffix(r.client.Path().String(), "/") + "/"
+	if relative, ok := strings.CutPrefix(ref, baseURL); ok {
+		return relative
+	}
+	return ref
+}
+
+// splitReference splits a relative reference into resource type and id.
+// It returns false if the reference isn't of the form Type/id (e.g. absolute or versioned references).
+func splitReference(ref string) (string, string, bool) {
+	resourceType, id, ok := strings.Cut(ref, "/")
+	if !ok || !isResourceName(resourceType) || id == "" || strings.Contains(id, "/") {
+		return "", "", false
+	}
+	return resourceType, id, true
+}
+
+// runConcurrently runs the tasks with the given maximum concurrency, and merges their results.
+// If a task fails, the remaining tasks are cancelled and the first error is returned.
+func runConcurrently(ctx context.Context, concurrency int, tasks []func(ctx context.Context) (map[string]json.RawMessage, error)) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	result := map[string]json.RawMessage{}
+	semaphore := make(chan struct{}, concurrency)
+	for _, task := range tasks {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			taskResult, err := task(ctx)
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			for key, value := range taskResult {
+				result[key] = value
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
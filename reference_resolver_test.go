@@ -0,0 +1,171 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// handlerDoer is an HttpRequestDoer that handles requests using an http.Handler, which makes it safe for concurrent use.
+type handlerDoer http.HandlerFunc
+
+func (h handlerDoer) Do(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	h(recorder, req)
+	return recorder.Result(), nil
+}
+
+func TestReferenceResolver(t *testing.T) {
+	observations := []fhir.Observation{
+		{Subject: &fhir.Reference{Reference: ptr("Patient/1")}, Performer: []fhir.Reference{{Reference: ptr("Practitioner/9")}}},
+		{Subject: &fhir.Reference{Reference: ptr("Patient/1")}, Performer: []fhir.Reference{{Reference: ptr("#contained")}}},
+		{Subject: &fhir.Reference{Reference: ptr("http://example.com/fhir/Patient/2")}},
+		{Subject: &fhir.Reference{Identifier: &fhir.Identifier{Value: ptr("123")}}},
+	}
+	addAll := func(t *testing.T, resolver *fhirclient.ReferenceResolver) {
+		for _, observation := range observations {
+			require.NoError(t, resolver.Add(observation, "subject"))
+			require.NoError(t, resolver.Add(observation, "performer"))
+		}
+	}
+
+	t.Run("search by _id per resource type", func(t *testing.T) {
+		var mux sync.Mutex
+		var searches []string
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			_ = r.ParseForm()
+			mux.Lock()
+			searches = append(searches, r.URL.Path+"?_id="+r.Form.Get("_id"))
+			mux.Unlock()
+			resourceType := strings.Split(r.URL.Path, "/")[2]
+			var bundle fhir.Bundle
+			for _, id := range strings.Split(r.Form.Get("_id"), ",") {
+				bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+					Resource: json.RawMessage(`{"resourceType":"` + resourceType + `","id":"` + id + `"}`),
+				})
+			}
+			_ = json.NewEncoder(w).Encode(bundle)
+		}), nil)
+		resolver := fhirclient.NewReferenceResolver(client)
+		addAll(t, resolver)
+
+		resources, err := resolver.Resolve(context.Background())
+
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/fhir/Patient/_search?_id=1,2", "/fhir/Practitioner/_search?_id=9"}, searches)
+		assert.Len(t, resources, 4)
+		assert.JSONEq(t, `{"resourceType":"Patient","id":"1"}`, string(resources["Patient/1"]))
+		assert.JSONEq(t, `{"resourceType":"Patient","id":"2"}`, string(resources["http://example.com/fhir/Patient/2"]))
+		assert.JSONEq(t, `{"resourceType":"Patient","id":"2"}`, string(resources["Patient/2"]))
+		assert.JSONEq(t, `{"resourceType":"Practitioner","id":"9"}`, string(resources["Practitioner/9"]))
+
+		t.Run("already resolved references are not fetched again", func(t *testing.T) {
+			searches = nil
+			resolver.AddReference("Patient/1")
+
+			resources, err := resolver.Resolve(context.Background())
+
+			require.NoError(t, err)
+			assert.Empty(t, searches)
+			assert.Len(t, resources, 4)
+		})
+	})
+	t.Run("reads with bounded concurrency", func(t *testing.T) {
+		var inFlight, maxInFlight, reads atomic.Int32
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			reads.Add(1)
+			for {
+				observed := maxInFlight.Load()
+				if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			segments := strings.Split(r.URL.Path, "/")
+			_, _ = w.Write([]byte(`{"resourceType":"` + segments[2] + `","id":"` + segments[3] + `"}`))
+		}), nil)
+		resolver := fhirclient.NewReferenceResolver(client, fhirclient.ResolveUsingReads(), fhirclient.ResolveConcurrency(2))
+		for i := 0; i < 3; i++ {
+			addAll(t, resolver)
+			resolver.AddReference("Organization/" + string(rune('a'+i)))
+		}
+
+		resources, err := resolver.Resolve(context.Background())
+
+		require.NoError(t, err)
+		assert.Equal(t, int32(6), reads.Load())
+		assert.LessOrEqual(t, maxInFlight.Load(), int32(2))
+		assert.Len(t, resources, 7)
+		assert.JSONEq(t, `{"resourceType":"Organization","id":"c"}`, string(resources["Organization/c"]))
+	})
+	t.Run("missing resources are absent", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/fhir/Patient/1":
+				_, _ = w.Write([]byte(`{"resourceType":"Patient","id":"1"}`))
+			case "/fhir/Patient/2":
+				w.WriteHeader(http.StatusGone)
+			default:
+				w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"not-found"}]}`))
+			}
+		}), nil)
+		resolver := fhirclient.NewReferenceResolver(client, fhirclient.ResolveUsingReads())
+		resolver.AddReference("Patient/1")
+		resolver.AddReference("Patient/2")
+		resolver.AddReference("Patient/3")
+
+		resources, err := resolver.Resolve(context.Background())
+
+		require.NoError(t, err)
+		assert.Len(t, resources, 1)
+		assert.Contains(t, resources, "Patient/1")
+	})
+	t.Run("error", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}), nil)
+		resolver := fhirclient.NewReferenceResolver(client)
+		addAll(t, resolver)
+
+		_, err := resolver.Resolve(context.Background())
+
+		require.ErrorContains(t, err, "resolve references: FHIR request failed")
+	})
+	t.Run("invalid path", func(t *testing.T) {
+		resolver := fhirclient.NewReferenceResolver(fhirclient.New(baseURL, nil, nil))
+
+		err := resolver.Add(observations[0], "performer[")
+
+		require.EqualError(t, err, "invalid path (missing ']'): performer[")
+	})
+}
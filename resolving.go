@@ -19,17 +19,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
 )
 
+// ErrAmbiguousReference is returned when resolving a logical reference (Reference.identifier),
+// and multiple resources with the identifier are found.
+var ErrAmbiguousReference = errors.New("FHIR Reference is ambiguous")
+
 // ResolveRef can be used to resolve references in a resource being read.
 // The path is the path to the reference, and the target is the resource to resolve the reference into.
 // E.g., to ServiceRequest's subject, the path would be "subject" and the target could e.g., be a Patient or Group.
+// The path is a simple FHIRPath expression: dot-separated element names, optionally followed by an index (e.g. basedOn[0]).
+// An element name of * selects all child elements. Like in FHIRPath, lists are flattened: e.g. participant.member selects the member
+// of all participants. If the path selects multiple references, the target must be a pointer to a slice (e.g. &[]fhir.Practitioner{}).
+// References to contained resources (#id) are resolved from the resource's contained resources,
+// and if the resource is a Bundle (or InBundle is specified), references are first looked up in the Bundle's entries.
+// Other references are read from the FHIR server.
 // It returns an error when the path does not contain a reference, when the reference cannot be resolved,
 // or when the resolved reference cannot be unmarshaled into the target.
-func ResolveRef(path string, target any) PostParseOption {
+func ResolveRef(path string, target any, opts ...ResolveOption) PostParseOption {
 	return func(client Client, resource any) error {
-		err := resolveReference(client, path, resource, target)
+		var settings resolveSettings
+		for _, opt := range opts {
+			opt(&settings)
+		}
+		err := resolveReference(client, path, resource, target, settings)
 		if err != nil {
 			return fmt.Errorf("resolve reference: %w", err)
 		}
@@ -37,7 +57,25 @@ func ResolveRef(path string, target any) PostParseOption {
 	}
 }
 
-func resolveReference(client Client, path string, resource any, result any) error {
+// ResolveOption configures how ResolveRef resolves references.
+type ResolveOption func(*resolveSettings)
+
+type resolveSettings struct {
+	bundle *fhir.Bundle
+	// offline disables reading references from the FHIR server: they must be resolved from the Bundle or contained resources.
+	offline bool
+}
+
+// InBundle specifies the Bundle the resource is an entry of (e.g. a document or transaction response).
+// References are resolved from the Bundle's entries (by fullUrl, or by resource type and id for relative references) if possible,
+// before reading them from the FHIR server.
+func InBundle(bundle fhir.Bundle) ResolveOption {
+	return func(s *resolveSettings) {
+		s.bundle = &bundle
+	}
+}
+
+func resolveReference(client Client, path string, resource any, result any, settings resolveSettings) error {
 	// Sanity checks
 	resultPtrType := reflect.TypeOf(result)
 	if resultPtrType.Kind() != reflect.Ptr {
@@ -45,25 +83,29 @@ func resolveReference(client Client, path string, resource any, result any) erro
 	}
 	resultType := resultPtrType.Elem()
 
-	// TODO: Support lists, absolute URLs
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
 	asMap, err := toMap(resource)
 	if err != nil {
 		return err
 	}
-	refRaw, ok := asMap[path]
-	if !ok {
+	matches := evaluatePath(asMap, segments)
+	if len(matches) == 0 {
 		// TODO: Set to nil instead
 		return fmt.Errorf("path not found: %s", path)
 	}
+	scope, err := newReferenceScope(asMap, settings.bundle)
+	if err != nil {
+		return err
+	}
+	scope.offline = settings.offline
 	if resultType.Kind() == reflect.Slice {
 		// Need to result list of references
-		refs, ok := refRaw.([]interface{})
-		if !ok {
-			return fmt.Errorf("not a list of FHIR References at path: %s", path)
-		}
-		for _, ref := range refs {
+		for _, match := range matches {
 			sliceEntry := reflect.New(resultType.Elem())
-			err := doResolve(client, path, ref, sliceEntry.Interface())
+			err := doResolve(client, scope.forResource(match.resource), path, match.value, sliceEntry.Interface())
 			if err != nil {
 				return err
 			}
@@ -73,26 +115,284 @@ func resolveReference(client Client, path string, resource any, result any) erro
 		}
 		return nil
 	}
-	return doResolve(client, path, refRaw, result)
+	if len(matches) > 1 {
+		return fmt.Errorf("multiple FHIR References (%d) at path, result must be a slice: %s", len(matches), path)
+	}
+	return doResolve(client, scope.forResource(matches[0].resource), path, matches[0].value, result)
 }
 
-func doResolve(client Client, path string, refRaw interface{}, result any) error {
+// pathSegment is a segment of a path expression, e.g. participant or basedOn[0].
+type pathSegment struct {
+	// name is the name of the element, or * to select all child elements.
+	name string
+	// index is the index of the element to select from the list, or -1 to select all elements.
+	index int
+}
+
+// parsePath parses a path expression (see ResolveRef) into its segments.
+func parsePath(path string) ([]pathSegment, error) {
+	var result []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		segment := pathSegment{name: part, index: -1}
+		if name, indexStr, hasIndex := strings.Cut(part, "["); hasIndex {
+			indexStr, ok := strings.CutSuffix(indexStr, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid path (missing ']'): %s", path)
+			}
+			segment.name = name
+			if indexStr != "*" {
+				index, err := strconv.Atoi(indexStr)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid path (invalid index '%s'): %s", indexStr, path)
+				}
+				segment.index = index
+			}
+		}
+		if segment.name == "" {
+			return nil, fmt.Errorf("invalid path (empty element name): %s", path)
+		}
+		result = append(result, segment)
+	}
+	return result, nil
+}
+
+// pathMatch is a value selected by a path expression.
+type pathMatch struct {
+	value any
+	// resource is the (contained, or Bundle entry) resource the value is part of.
+	resource map[string]interface{}
+}
+
+// evaluatePath selects the values at the given path in the resource. Lists are flattened.
+func evaluatePath(resource map[string]interface{}, segments []pathSegment) []pathMatch {
+	matches := []pathMatch{{value: resource, resource: resource}}
+	for _, segment := range segments {
+		var next []pathMatch
+		for _, match := range matches {
+			element, ok := match.value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, isResource := element["resourceType"]; isResource {
+				match.resource = element
+			}
+			var children []interface{}
+			if segment.name == "*" {
+				names := make([]string, 0, len(element))
+				for name := range element {
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				for _, name := range names {
+					children = append(children, element[name])
+				}
+			} else if child, ok := element[segment.name]; ok {
+				children = append(children, child)
+			}
+			var values []interface{}
+			for _, child := range children {
+				if list, isList := child.([]interface{}); isList {
+					values = append(values, list...)
+				} else {
+					values = append(values, child)
+				}
+			}
+			if segment.index >= 0 {
+				if segment.index >= len(values) {
+					continue
+				}
+				values = values[segment.index : segment.index+1]
+			}
+			for _, value := range values {
+				next = append(next, pathMatch{value: value, resource: match.resource})
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+func doResolve(client Client, scope referenceScope, path string, refRaw interface{}, result any) error {
 	refMap, ok := refRaw.(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("not a FHIR Reference at path: %s", path)
 	}
-	if _, ok := refMap["identifier"]; ok {
-		return fmt.Errorf("unexpected FHIR Reference.identifier (not supported) at path: %s", path)
+	if _, hasReference := refMap["reference"]; !hasReference {
+		if identifier, ok := refMap["identifier"]; ok {
+			if scope.offline {
+				return fmt.Errorf("FHIR Reference.identifier can't be resolved locally at path: %s", path)
+			}
+			return resolveIdentifier(client, path, refMap["type"], identifier, result)
+		}
 	}
 	ref, ok := refMap["reference"].(string)
 	if !ok {
 		return fmt.Errorf("FHIR Reference.reference missing/invalid at path: %s", path)
 	}
+	local, err := scope.lookup(ref)
+	if err != nil {
+		return fmt.Errorf("%w at path: %s", err, path)
+	}
+	if local != nil {
+		return json.Unmarshal(local, result)
+	}
+	if scope.offline {
+		return fmt.Errorf("Bundle entry not found: %s at path: %s", ref, path)
+	}
 	return client.Read(ref, result)
 }
 
-type ReferenceResolver struct {
-	client Client
+// referenceScope contains the resources references can be resolved from without reading them from the FHIR server.
+type referenceScope struct {
+	// contained maps the ids of the contained resources to the resources.
+	contained map[string]json.RawMessage
+	// bundle maps the fullUrl and the relative URL (Type/id) of the Bundle's entries to the resources.
+	bundle map[string]json.RawMessage
+	// offline indicates references that can't be resolved locally must not be read from the FHIR server.
+	offline bool
+}
+
+// newReferenceScope creates a referenceScope for the given resource (as map),
+// which includes the resource's entries if it's a Bundle, and the entries of the enclosing Bundle (if any).
+func newReferenceScope(resource map[string]interface{}, enclosing *fhir.Bundle) (referenceScope, error) {
+	type bundleEntry struct {
+		FullUrl  string          `json:"fullUrl"`
+		Resource json.RawMessage `json:"resource"`
+	}
+	var local struct {
+		ResourceType string        `json:"resourceType"`
+		Entry        []bundleEntry `json:"entry"`
+	}
+	if data, err := json.Marshal(resource); err != nil {
+		return referenceScope{}, err
+	} else if err := json.Unmarshal(data, &local); err != nil {
+		return referenceScope{}, err
+	}
+	result := referenceScope{
+		bundle: map[string]json.RawMessage{},
+	}
+	var entries []bundleEntry
+	if local.ResourceType == "Bundle" {
+		entries = local.Entry
+	}
+	if enclosing != nil {
+		for _, entry := range enclosing.Entry {
+			var fullUrl string
+			if entry.FullUrl != nil {
+				fullUrl = *entry.FullUrl
+			}
+			entries = append(entries, bundleEntry{FullUrl: fullUrl, Resource: entry.Resource})
+		}
+	}
+	for _, entry := range entries {
+		if len(entry.Resource) == 0 {
+			continue
+		}
+		if entry.FullUrl != "" {
+			result.bundle[entry.FullUrl] = entry.Resource
+		}
+		if desc, err := DescribeResource([]byte(entry.Resource)); err == nil {
+			if id := resourceID(entry.Resource); id != "" {
+				if _, exists := result.bundle[desc.Type+"/"+id]; !exists {
+					result.bundle[desc.Type+"/"+id] = entry.Resource
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// forResource returns a copy of the scope for references in the given resource (as map), which has its own contained resources.
+func (s referenceScope) forResource(resource map[string]interface{}) referenceScope {
+	s.contained = map[string]json.RawMessage{}
+	containedList, _ := resource["contained"].([]interface{})
+	for _, contained := range containedList {
+		if data, err := json.Marshal(contained); err == nil {
+			if id := resourceID(data); id != "" {
+				s.contained[id] = data
+			}
+		}
+	}
+	return s
+}
+
+// lookup returns the resource the reference refers to, or nil if it can't be resolved locally.
+// It returns an error if the reference must be resolved locally (contained resources and URNs), but can't be found.
+func (s referenceScope) lookup(ref string) (json.RawMessage, error) {
+	if id, isContained := strings.CutPrefix(ref, "#"); isContained {
+		if resource, ok := s.contained[id]; ok {
+			return resource, nil
+		}
+		return nil, fmt.Errorf("contained resource not found: %s", ref)
+	}
+	if resource, ok := s.bundle[ref]; ok {
+		return resource, nil
+	}
+	if strings.HasPrefix(ref, "urn:") {
+		return nil, fmt.Errorf("Bundle entry not found: %s", ref)
+	}
+	return nil, nil
+}
+
+// resourceID returns the id of the given resource, or an empty string if it has none.
+func resourceID(resource json.RawMessage) string {
+	var result struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(resource, &result)
+	return result.ID
+}
+
+// resolveIdentifier resolves a logical reference (Reference.identifier), by searching for the resource with the given identifier.
+// The resource type is taken from Reference.type, or derived from the result if not present.
+// Exactly one resource must match, otherwise an error is returned (ErrAmbiguousReference if multiple resources match).
+func resolveIdentifier(client Client, path string, refType any, identifierRaw any, result any) error {
+	identifierMap, ok := identifierRaw.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("FHIR Reference.identifier invalid at path: %s", path)
+	}
+	value, _ := identifierMap["value"].(string)
+	if value == "" {
+		return fmt.Errorf("FHIR Reference.identifier.value missing at path: %s", path)
+	}
+	// The identifier is searched as token, of which the system and value must be escaped
+	searchValue := EscapeSearchValue(value)
+	if system, ok := identifierMap["system"].(string); ok {
+		value = system + "|" + value
+		searchValue = EscapeSearchValue(system) + "|" + searchValue
+	}
+	resourceType, _ := refType.(string)
+	// Reference.type is a URI, which is relative to http://hl7.org/fhir/StructureDefinition/ for FHIR resource types
+	resourceType = resourceType[strings.LastIndex(resourceType, "/")+1:]
+	if resourceType == "" {
+		desc, err := DescribeResource(reflect.ValueOf(result).Elem().Interface())
+		if err != nil {
+			return fmt.Errorf("FHIR Reference.type missing, and unable to derive it from the target at path: %s", path)
+		}
+		resourceType = desc.Type
+	}
+	var searchSet fhir.Bundle
+	// 2 results are enough to detect whether the identifier is ambiguous, regardless of the server's default page size
+	if err := client.Search(resourceType, url.Values{"identifier": []string{searchValue}, "_count": []string{"2"}}, &searchSet); err != nil {
+		return err
+	}
+	var matches []json.RawMessage
+	for _, entry := range searchSet.Entry {
+		if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fhir.SearchEntryModeMatch {
+			continue
+		}
+		if desc, err := DescribeResource([]byte(entry.Resource)); err == nil && desc.Type == resourceType {
+			matches = append(matches, entry.Resource)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("no %s found with identifier %s (FHIR Reference.identifier at path: %s)", resourceType, value, path)
+	case 1:
+		return json.Unmarshal(matches[0], result)
+	default:
+		return fmt.Errorf("%w: %d resources of type %s found with identifier %s (FHIR Reference.identifier at path: %s)", ErrAmbiguousReference, len(matches), resourceType, value, path)
+	}
 }
 
 func isSlice(v interface{}) bool {
@@ -16,15 +16,20 @@
 package fhirclient_test
 
 import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
 	fhirclient "github.com/SanteonNL/go-fhir-client"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
 	"go.uber.org/mock/gomock"
-	"testing"
 )
 
 type RefResource struct {
 	Id        string                   `json:"id"`
+	Contained []map[string]interface{} `json:"contained,omitempty"`
 	OneToOne  map[string]interface{}   `json:"oneToOne"`
 	OneToMany []map[string]interface{} `json:"oneToMany"`
 }
@@ -87,4 +92,263 @@ func TestResolveRef(t *testing.T) {
 		require.Equal(t, "123", resolved[0].Id)
 		require.Equal(t, "789", resolved[1].Id)
 	})
+	t.Run("resolve logical reference", func(t *testing.T) {
+		identifierRef := RefResource{
+			OneToOne: map[string]interface{}{
+				"type": "Resource",
+				"identifier": map[string]interface{}{
+					"system": "http://example.com/mrn",
+					"value":  "1",
+				},
+			},
+		}
+		searchResult := func(resources ...string) func(string, url.Values, any, ...fhirclient.Option) error {
+			return func(_ string, _ url.Values, target any, _ ...fhirclient.Option) error {
+				bundle := target.(*fhir.Bundle)
+				for _, resource := range resources {
+					bundle.Entry = append(bundle.Entry, fhir.BundleEntry{Resource: json.RawMessage(resource)})
+				}
+				return nil
+			}
+		}
+		t.Run("ok", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().Search("Resource", url.Values{"identifier": []string{"http://example.com/mrn|1"}, "_count": []string{"2"}}, gomock.Any()).
+				DoAndReturn(searchResult(`{"resourceType":"Resource","id":"123"}`, `{"resourceType":"OperationOutcome"}`))
+
+			var resolved Resource
+			err := fhirclient.ResolveRef("oneToOne", &resolved)(client, identifierRef)
+
+			require.NoError(t, err)
+			require.Equal(t, "123", resolved.Id)
+		})
+		t.Run("type derived from target", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().Search("Patient", url.Values{"identifier": []string{"1"}, "_count": []string{"2"}}, gomock.Any()).
+				DoAndReturn(searchResult(`{"resourceType":"Patient","id":"123"}`))
+
+			var resolved fhir.Patient
+			err := fhirclient.ResolveRef("oneToOne", &resolved)(client, RefResource{
+				OneToOne: map[string]interface{}{
+					"identifier": map[string]interface{}{"value": "1"},
+				},
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, "123", *resolved.ID)
+		})
+		t.Run("identifier with special characters", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().Search("Resource", url.Values{"identifier": []string{`http://example.com/a\|b|1\,2\$3\\`}, "_count": []string{"2"}}, gomock.Any()).
+				DoAndReturn(searchResult(`{"resourceType":"Resource","id":"123"}`))
+
+			var resolved Resource
+			err := fhirclient.ResolveRef("oneToOne", &resolved)(client, RefResource{
+				OneToOne: map[string]interface{}{
+					"type":       "Resource",
+					"identifier": map[string]interface{}{"system": "http://example.com/a|b", "value": `1,2$3\`},
+				},
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, "123", resolved.Id)
+		})
+		t.Run("no match", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().Search("Resource", gomock.Any(), gomock.Any()).DoAndReturn(searchResult())
+
+			err := fhirclient.ResolveRef("oneToOne", &Resource{})(client, identifierRef)
+
+			require.EqualError(t, err, "resolve reference: no Resource found with identifier http://example.com/mrn|1 (FHIR Reference.identifier at path: oneToOne)")
+		})
+		t.Run("ambiguous", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().Search("Resource", gomock.Any(), gomock.Any()).
+				DoAndReturn(searchResult(`{"resourceType":"Resource","id":"1"}`, `{"resourceType":"Resource","id":"2"}`))
+
+			err := fhirclient.ResolveRef("oneToOne", &Resource{})(client, identifierRef)
+
+			require.ErrorIs(t, err, fhirclient.ErrAmbiguousReference)
+			require.EqualError(t, err, "resolve reference: FHIR Reference is ambiguous: 2 resources of type Resource found with identifier http://example.com/mrn|1 (FHIR Reference.identifier at path: oneToOne)")
+		})
+	})
+	t.Run("resolve contained reference", func(t *testing.T) {
+		client := NewMockClient(gomock.NewController(t))
+
+		var resolved Resource
+		err := fhirclient.ResolveRef("oneToOne", &resolved)(client, RefResource{
+			Contained: []map[string]interface{}{
+				{"resourceType": "Resource", "id": "other"},
+				{"resourceType": "Resource", "id": "c1"},
+			},
+			OneToOne: map[string]interface{}{"reference": "#c1"},
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, "c1", resolved.Id)
+	})
+	t.Run("contained resource not found", func(t *testing.T) {
+		client := NewMockClient(gomock.NewController(t))
+
+		err := fhirclient.ResolveRef("oneToOne", &Resource{})(client, RefResource{
+			OneToOne: map[string]interface{}{"reference": "#c1"},
+		})
+
+		require.EqualError(t, err, "resolve reference: contained resource not found: #c1 at path: oneToOne")
+	})
+	t.Run("resolve references in enclosing Bundle", func(t *testing.T) {
+		client := NewMockClient(gomock.NewController(t))
+		client.EXPECT().Read("Resource/3", gomock.Any()).DoAndReturn(func(_ string, r *Resource, _ ...fhirclient.Option) error {
+			*r = Resource{Id: "3"}
+			return nil
+		})
+		bundle := fhir.Bundle{
+			Type: fhir.BundleTypeDocument,
+			Entry: []fhir.BundleEntry{
+				{
+					FullUrl:  ptr("urn:uuid:6e4f7b5e-7c3a-4b0e-9d0a-2b1d4f5c6a7b"),
+					Resource: json.RawMessage(`{"resourceType":"Resource","id":"1"}`),
+				},
+				{
+					FullUrl:  ptr("http://example.com/fhir/Resource/2"),
+					Resource: json.RawMessage(`{"resourceType":"Resource","id":"2"}`),
+				},
+			},
+		}
+
+		var resolved []Resource
+		err := fhirclient.ResolveRef("oneToMany", &resolved, fhirclient.InBundle(bundle))(client, RefResource{
+			OneToMany: []map[string]interface{}{
+				{"reference": "urn:uuid:6e4f7b5e-7c3a-4b0e-9d0a-2b1d4f5c6a7b"},
+				{"reference": "Resource/2"},
+				{"reference": "Resource/3"},
+			},
+		})
+
+		require.NoError(t, err)
+		require.Len(t, resolved, 3)
+		assert.Equal(t, "1", resolved[0].Id)
+		assert.Equal(t, "2", resolved[1].Id)
+		assert.Equal(t, "3", resolved[2].Id)
+	})
+	t.Run("Bundle entry not found", func(t *testing.T) {
+		client := NewMockClient(gomock.NewController(t))
+
+		err := fhirclient.ResolveRef("oneToOne", &Resource{}, fhirclient.InBundle(fhir.Bundle{}))(client, RefResource{
+			OneToOne: map[string]interface{}{"reference": "urn:uuid:6e4f7b5e-7c3a-4b0e-9d0a-2b1d4f5c6a7b"},
+		})
+
+		require.EqualError(t, err, "resolve reference: Bundle entry not found: urn:uuid:6e4f7b5e-7c3a-4b0e-9d0a-2b1d4f5c6a7b at path: oneToOne")
+	})
+	t.Run("nested paths", func(t *testing.T) {
+		readResource := func(_ string, r *Resource, _ ...fhirclient.Option) error {
+			*r = Resource{Id: "read"}
+			return nil
+		}
+		careTeam := map[string]interface{}{
+			"resourceType": "CareTeam",
+			"participant": []interface{}{
+				map[string]interface{}{"member": map[string]interface{}{"reference": "Resource/1"}},
+				map[string]interface{}{"role": []interface{}{}},
+				map[string]interface{}{"member": map[string]interface{}{"reference": "Resource/2"}},
+			},
+			"basedOn": []interface{}{
+				map[string]interface{}{"reference": "Resource/3"},
+				map[string]interface{}{"reference": "Resource/4"},
+			},
+		}
+		t.Run("list is flattened", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+			client.EXPECT().Read("Resource/1", gomock.Any()).DoAndReturn(readResource)
+			client.EXPECT().Read("Resource/2", gomock.Any()).DoAndReturn(readResource)
+
+			var resolved []Resource
+			err := fhirclient.ResolveRef("participant.member", &resolved)(client, careTeam)
+
+			require.NoError(t, err)
+			assert.Len(t, resolved, 2)
+		})
+		t.Run("index", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+			client.EXPECT().Read("Resource/4", gomock.Any()).DoAndReturn(readResource)
+
+			var resolved Resource
+			err := fhirclient.ResolveRef("basedOn[1]", &resolved)(client, careTeam)
+
+			require.NoError(t, err)
+			assert.Equal(t, "read", resolved.Id)
+		})
+		t.Run("wildcard", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+			client.EXPECT().Read("Resource/1", gomock.Any()).DoAndReturn(readResource)
+			client.EXPECT().Read("Resource/2", gomock.Any()).DoAndReturn(readResource)
+
+			var resolved []Resource
+			err := fhirclient.ResolveRef("participant[*].*", &resolved)(client, careTeam)
+
+			require.NoError(t, err)
+			assert.Len(t, resolved, 2)
+		})
+		t.Run("multiple references require a slice", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+
+			err := fhirclient.ResolveRef("basedOn", &Resource{})(client, careTeam)
+
+			require.EqualError(t, err, "resolve reference: multiple FHIR References (2) at path, result must be a slice: basedOn")
+		})
+		t.Run("index out of range", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+
+			err := fhirclient.ResolveRef("basedOn[2]", &Resource{})(client, careTeam)
+
+			require.EqualError(t, err, "resolve reference: path not found: basedOn[2]")
+		})
+		t.Run("invalid path", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+
+			err := fhirclient.ResolveRef("basedOn[x]", &Resource{})(client, careTeam)
+
+			require.EqualError(t, err, "resolve reference: invalid path (invalid index 'x'): basedOn[x]")
+		})
+		t.Run("Bundle entries", func(t *testing.T) {
+			client := NewMockClient(gomock.NewController(t))
+			bundle := map[string]interface{}{
+				"resourceType": "Bundle",
+				"entry": []interface{}{
+					map[string]interface{}{
+						"fullUrl": "urn:uuid:1",
+						"resource": map[string]interface{}{
+							"resourceType": "Observation",
+							"contained":    []interface{}{map[string]interface{}{"resourceType": "Resource", "id": "c1"}},
+							"subject":      map[string]interface{}{"reference": "#c1"},
+						},
+					},
+					map[string]interface{}{
+						"fullUrl": "urn:uuid:2",
+						"resource": map[string]interface{}{
+							"resourceType": "Observation",
+							"subject":      map[string]interface{}{"reference": "urn:uuid:3"},
+						},
+					},
+					map[string]interface{}{
+						"fullUrl":  "urn:uuid:3",
+						"resource": map[string]interface{}{"resourceType": "Resource", "id": "3"},
+					},
+				},
+			}
+
+			var resolved []Resource
+			err := fhirclient.ResolveRef("entry.resource.subject", &resolved)(client, bundle)
+
+			require.NoError(t, err)
+			require.Len(t, resolved, 2)
+			assert.Equal(t, "c1", resolved[0].Id)
+			assert.Equal(t, "3", resolved[1].Id)
+		})
+	})
 }
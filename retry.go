@@ -0,0 +1,178 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures automatic retries of failed FHIR requests.
+// Only idempotent requests (GET, HEAD, PUT, DELETE and POST-based searches) are retried,
+// unless retrying is explicitly allowed for a request using the AllowRetry option.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for a request, including the first attempt.
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry. It is doubled for every subsequent retry.
+	// Random jitter is applied to the backoff to prevent clients from retrying in lockstep.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait before a retry. It also caps the delay requested by the FHIR server through Retry-After.
+	MaxBackoff time.Duration
+	// RetryableStatusCodes are the HTTP status codes for which a request is retried.
+	// If empty, requests are retried on 429, 502, 503 and 504.
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy returns a retry policy that retries a request up to 3 times, starting with a backoff of 500ms.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// AllowRetry allows a non-idempotent request (e.g. create, patch or transaction) to be retried according to the configured RetryPolicy.
+// Only use this when retrying the request can't cause duplicate side effects, e.g. for conditional creates.
+func AllowRetry() Option {
+	return requestOption(func(s *requestSettings) {
+		s.allowRetry = true
+	})
+}
+
+// doWithRetry executes the HTTP request, retrying it according to the retry policy.
+// It returns the last response (or error) and the number of attempts made.
+func (d BaseClient) doWithRetry(httpRequest *http.Request, settings requestSettings) (*http.Response, int, error) {
+	policy := d.config.RetryPolicy
+	if policy == nil || policy.MaxAttempts <= 1 || !(isIdempotent(httpRequest.Method) || settings.allowRetry) {
+		httpResponse, err := d.send(httpRequest)
+		return httpResponse, 1, err
+	}
+	// Buffer the request body, so it can be replayed for every attempt
+	var body []byte
+	if httpRequest.Body != nil {
+		var err error
+		body, err = io.ReadAll(httpRequest.Body)
+		_ = httpRequest.Body.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		httpRequest.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		if body != nil {
+			httpRequest.Body, _ = httpRequest.GetBody()
+		}
+		httpResponse, err := d.send(httpRequest)
+		if attempt >= policy.MaxAttempts || !policy.shouldRetry(httpResponse, err) || httpRequest.Context().Err() != nil {
+			return httpResponse, attempt, err
+		}
+		delay := policy.backoff(attempt, httpResponse)
+		if httpResponse != nil && httpResponse.Body != nil {
+			// Drain the body, so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(httpResponse.Body, 64*1024))
+			_ = httpResponse.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-httpRequest.Context().Done():
+			timer.Stop()
+			return nil, attempt, httpRequest.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (p RetryPolicy) shouldRetry(httpResponse *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	statusCodes := p.RetryableStatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	return slices.Contains(statusCodes, httpResponse.StatusCode)
+}
+
+// backoff returns the time to wait before the next attempt, honoring the Retry-After header of the response (if any).
+func (p RetryPolicy) backoff(attempt int, httpResponse *http.Response) time.Duration {
+	var result time.Duration
+	if retryAfter, ok := retryAfterDelay(httpResponse, time.Now()); ok {
+		result = retryAfter
+	} else {
+		result = p.InitialBackoff << (attempt - 1)
+		if result > 0 {
+			// Equal jitter: wait at least half of the backoff
+			result = result/2 + rand.N(result/2+1)
+		}
+	}
+	if p.MaxBackoff > 0 && (result > p.MaxBackoff || result < 0) {
+		result = p.MaxBackoff
+	}
+	return result
+}
+
+// retryAfterDelay parses the Retry-After header of the response, which is either a number of seconds or an HTTP date.
+func retryAfterDelay(httpResponse *http.Response, now time.Time) (time.Duration, bool) {
+	if httpResponse == nil {
+		return 0, false
+	}
+	value := httpResponse.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// withAttempts adds the number of attempts to the error, if the request was retried.
+func withAttempts(err error, attempts int) error {
+	if attempts <= 1 {
+		return err
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
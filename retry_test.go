@@ -0,0 +1,223 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	baseURL, _ := url.Parse("http://example.com/fhir")
+	retryPolicy := &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+	statusResponse := func(statusCode int) *http.Response {
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     http.Header{"Retry-After": {"1"}},
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+		}
+	}
+
+	t.Run("retries until success", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				statusResponse(http.StatusTooManyRequests),
+				statusResponse(http.StatusServiceUnavailable),
+				createBundleResponse(fhir.Bundle{}),
+			},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy})
+
+		err := client.Read("Patient/1", new(fhir.Bundle))
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 3)
+	})
+	t.Run("gives up after max. attempts", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				statusResponse(http.StatusServiceUnavailable),
+				statusResponse(http.StatusServiceUnavailable),
+				statusResponse(http.StatusServiceUnavailable),
+			},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy})
+
+		err := client.Read("Patient/1", new(fhir.Bundle))
+
+		require.EqualError(t, err, "giving up after 3 attempts: FHIR request failed (GET http://example.com/fhir/Patient/1, status=503)")
+		assert.Len(t, stub.requests, 3)
+	})
+	t.Run("non-retryable status code", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{statusResponse(http.StatusBadRequest)},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy})
+
+		err := client.Read("Patient/1", new(fhir.Bundle))
+
+		require.EqualError(t, err, "FHIR request failed (GET http://example.com/fhir/Patient/1, status=400)")
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("transport error", func(t *testing.T) {
+		stub := &failingDoer{failures: 1, response: createBundleResponse(fhir.Bundle{})}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy})
+
+		err := client.Read("Patient/1", new(fhir.Bundle))
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, stub.calls)
+	})
+	t.Run("non-idempotent request is not retried", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{statusResponse(http.StatusServiceUnavailable)},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy})
+
+		err := client.Create(fhir.Patient{}, nil)
+
+		require.Error(t, err)
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("non-idempotent request is retried when allowed, body is replayed", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				statusResponse(http.StatusServiceUnavailable),
+				createBundleResponse(fhir.Bundle{}),
+			},
+		}
+		var bodies []string
+		client := New(baseURL, doerFunc(func(r *http.Request) (*http.Response, error) {
+			data, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(data))
+			return stub.Do(r)
+		}), &Config{RetryPolicy: retryPolicy})
+
+		err := client.Create(fhir.Patient{}, nil, AllowRetry())
+
+		require.NoError(t, err)
+		require.Len(t, bodies, 2)
+		assert.Equal(t, `{"resourceType":"Patient"}`, bodies[0])
+		assert.Equal(t, bodies[0], bodies[1])
+	})
+	t.Run("POST search is retried", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{
+				statusResponse(http.StatusServiceUnavailable),
+				createBundleResponse(fhir.Bundle{}),
+			},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: retryPolicy, UsePostSearch: true})
+
+		err := client.Search("Patient", url.Values{"name": {"John"}}, new(fhir.Bundle))
+
+		require.NoError(t, err)
+		assert.Len(t, stub.requests, 2)
+	})
+	t.Run("context cancelled while waiting", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{statusResponse(http.StatusServiceUnavailable)},
+		}
+		client := New(baseURL, stub, &Config{RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.ReadWithContext(ctx, "Patient/1", new(fhir.Bundle))
+
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Len(t, stub.requests, 1)
+	})
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	t.Run("exponential with jitter", func(t *testing.T) {
+		first := policy.backoff(1, nil)
+		assert.GreaterOrEqual(t, first, 50*time.Millisecond)
+		assert.LessOrEqual(t, first, 100*time.Millisecond)
+		third := policy.backoff(3, nil)
+		assert.GreaterOrEqual(t, third, 200*time.Millisecond)
+		assert.LessOrEqual(t, third, 400*time.Millisecond)
+	})
+	t.Run("capped by max. backoff", func(t *testing.T) {
+		assert.Equal(t, time.Second, policy.backoff(10, nil))
+	})
+	t.Run("Retry-After", func(t *testing.T) {
+		response := &http.Response{Header: http.Header{"Retry-After": {"0"}}}
+		assert.Equal(t, time.Duration(0), policy.backoff(3, response))
+	})
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t.Run("seconds", func(t *testing.T) {
+		delay, ok := retryAfterDelay(&http.Response{Header: http.Header{"Retry-After": {"120"}}}, now)
+		assert.True(t, ok)
+		assert.Equal(t, 2*time.Minute, delay)
+	})
+	t.Run("HTTP date", func(t *testing.T) {
+		delay, ok := retryAfterDelay(&http.Response{Header: http.Header{"Retry-After": {"Mon, 01 Jan 2024 12:00:30 GMT"}}}, now)
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, delay)
+	})
+	t.Run("HTTP date in the past", func(t *testing.T) {
+		delay, ok := retryAfterDelay(&http.Response{Header: http.Header{"Retry-After": {"Mon, 01 Jan 2024 11:00:00 GMT"}}}, now)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(0), delay)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, ok := retryAfterDelay(&http.Response{Header: http.Header{"Retry-After": {"soon"}}}, now)
+		assert.False(t, ok)
+	})
+	t.Run("absent", func(t *testing.T) {
+		_, ok := retryAfterDelay(&http.Response{}, now)
+		assert.False(t, ok)
+	})
+}
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type failingDoer struct {
+	failures int
+	calls    int
+	response *http.Response
+}
+
+func (f *failingDoer) Do(_ *http.Request) (*http.Response, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("connection reset")
+	}
+	return f.response, nil
+}
@@ -0,0 +1,245 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchPrefix is a prefix of a date, number or quantity search value, which specifies how values are compared.
+type SearchPrefix string
+
+const (
+	PrefixEq SearchPrefix = "eq"
+	PrefixNe SearchPrefix = "ne"
+	PrefixGt SearchPrefix = "gt"
+	PrefixLt SearchPrefix = "lt"
+	PrefixGe SearchPrefix = "ge"
+	PrefixLe SearchPrefix = "le"
+	PrefixSa SearchPrefix = "sa"
+	PrefixEb SearchPrefix = "eb"
+	PrefixAp SearchPrefix = "ap"
+)
+
+// SummaryMode is a value of the _summary search result parameter.
+type SummaryMode string
+
+const (
+	SummaryTrue  SummaryMode = "true"
+	SummaryFalse SummaryMode = "false"
+	SummaryText  SummaryMode = "text"
+	SummaryData  SummaryMode = "data"
+	SummaryCount SummaryMode = "count"
+)
+
+// SearchQuery builds the query of a FHIR search, taking care of modifiers, prefixes and escaping of the search parameter types.
+// Every call adds a search parameter, so calling a method twice for the same parameter means both must match (AND).
+// Characters with a special meaning in search values (, | $ and \) are escaped in the given values,
+// except for | in reference values, which separates the version of a canonical reference.
+// The result (see Values) can be passed to Client.SearchWithContext, and is encoded correctly for both GET and POST searches.
+type SearchQuery struct {
+	values url.Values
+}
+
+// NewSearchQuery creates an empty SearchQuery.
+func NewSearchQuery() *SearchQuery {
+	return &SearchQuery{values: url.Values{}}
+}
+
+// Values returns the search parameters.
+func (q *SearchQuery) Values() url.Values {
+	result := url.Values{}
+	for key, values := range q.values {
+		result[key] = append([]string(nil), values...)
+	}
+	return result
+}
+
+// Encode encodes the search parameters as URL query (or form body, for POST searches).
+func (q *SearchQuery) Encode() string {
+	return q.values.Encode()
+}
+
+// Add adds a search parameter with the given name and value, as-is (the value is not escaped).
+func (q *SearchQuery) Add(name string, value string) *SearchQuery {
+	q.values.Add(name, value)
+	return q
+}
+
+// String adds a string search parameter, which matches values that start with the given value (case- and accent-insensitive).
+func (q *SearchQuery) String(name string, value string) *SearchQuery {
+	return q.Add(name, EscapeSearchValue(value))
+}
+
+// StringExact adds a string search parameter with the :exact modifier, which matches values exactly (case- and accent-sensitive).
+func (q *SearchQuery) StringExact(name string, value string) *SearchQuery {
+	return q.Add(name+":exact", EscapeSearchValue(value))
+}
+
+// StringContains adds a string search parameter with the :contains modifier, which matches values that contain the given value.
+func (q *SearchQuery) StringContains(name string, value string) *SearchQuery {
+	return q.Add(name+":contains", EscapeSearchValue(value))
+}
+
+// Token adds a token search parameter (e.g. identifier or code), rendered as system|code.
+// If the system is empty, codes of any system match. If the code is empty, any code of the system matches.
+func (q *SearchQuery) Token(name string, system string, code string) *SearchQuery {
+	return q.Add(name, tokenValue(system, code))
+}
+
+// TokenNoSystem adds a token search parameter that matches codes without a system (|code).
+func (q *SearchQuery) TokenNoSystem(name string, code string) *SearchQuery {
+	return q.Add(name, "|"+EscapeSearchValue(code))
+}
+
+// TokenNot adds a token search parameter with the :not modifier, which matches resources that don't have the given code.
+func (q *SearchQuery) TokenNot(name string, system string, code string) *SearchQuery {
+	return q.Add(name+":not", tokenValue(system, code))
+}
+
+// TokenText adds a token search parameter with the :text modifier, which matches the text (or display) of the code.
+func (q *SearchQuery) TokenText(name string, text string) *SearchQuery {
+	return q.Add(name+":text", EscapeSearchValue(text))
+}
+
+// Date adds a date search parameter, e.g. Date("birthdate", PrefixGe, "1990-01") for patients born in or after January 1990.
+// The date can have any precision (yyyy, yyyy-mm, yyyy-mm-dd or a full dateTime).
+func (q *SearchQuery) Date(name string, prefix SearchPrefix, date string) *SearchQuery {
+	return q.Add(name, string(prefix)+date)
+}
+
+// DateTime adds a date search parameter for the given time, with precision in seconds.
+func (q *SearchQuery) DateTime(name string, prefix SearchPrefix, dateTime time.Time) *SearchQuery {
+	return q.Add(name, string(prefix)+dateTime.Format(time.RFC3339))
+}
+
+// Number adds a number search parameter, e.g. Number("probability", PrefixGt, 0.8).
+func (q *SearchQuery) Number(name string, prefix SearchPrefix, value float64) *SearchQuery {
+	return q.Add(name, string(prefix)+formatSearchNumber(value))
+}
+
+// Quantity adds a quantity search parameter, rendered as [prefix]value|system|code, e.g. Quantity("value-quantity", PrefixLt, 5.4, "http://unitsofmeasure.org", "mg").
+// If the system is empty, the code is matched against the unit and code of any system. If both are empty, only the value is matched.
+func (q *SearchQuery) Quantity(name string, prefix SearchPrefix, value float64, system string, code string) *SearchQuery {
+	result := string(prefix) + formatSearchNumber(value)
+	if system != "" || code != "" {
+		result += "|" + EscapeSearchValue(system) + "|" + EscapeSearchValue(code)
+	}
+	return q.Add(name, result)
+}
+
+// Reference adds a reference search parameter, e.g. Reference("subject", "Patient/123"),
+// or a canonical reference with version, e.g. Reference("definition", "http://example.com/PlanDefinition/1|2.0").
+func (q *SearchQuery) Reference(name string, ref string) *SearchQuery {
+	return q.Add(name, referenceValueEscaper.Replace(ref))
+}
+
+// ReferenceOfType adds a reference search parameter with a type modifier, e.g. ReferenceOfType("subject", "Patient", "123").
+func (q *SearchQuery) ReferenceOfType(name string, resourceType string, id string) *SearchQuery {
+	return q.Add(name+":"+resourceType, EscapeSearchValue(id))
+}
+
+// Composite adds a composite search parameter, of which the component values are joined by $,
+// e.g. Composite("code-value-quantity", "http://loinc.org|8480-6", "gt140").
+// Component values are added as-is, so that they can contain token and quantity values (use EscapeSearchValue where needed).
+func (q *SearchQuery) Composite(name string, components ...string) *SearchQuery {
+	return q.Add(name, strings.Join(components, "$"))
+}
+
+// Chain adds a chained search parameter, which searches on an element of a referenced resource,
+// e.g. Chain("subject", "Patient", "name", "peter") renders as subject:Patient.name=peter.
+// The resource type is optional, if the reference can only refer to one type.
+func (q *SearchQuery) Chain(reference string, resourceType string, name string, value string) *SearchQuery {
+	if resourceType != "" {
+		reference += ":" + resourceType
+	}
+	return q.Add(reference+"."+name, EscapeSearchValue(value))
+}
+
+// Has adds a reverse chained search parameter (_has), which searches for resources that are referenced by other resources,
+// e.g. Has("Observation", "patient", "code", "1234-5") renders as _has:Observation:patient:code=1234-5.
+func (q *SearchQuery) Has(resourceType string, reference string, name string, value string) *SearchQuery {
+	return q.Add("_has:"+resourceType+":"+reference+":"+name, EscapeSearchValue(value))
+}
+
+// Count sets the maximum number of results per page (_count).
+func (q *SearchQuery) Count(count int) *SearchQuery {
+	q.values.Set("_count", strconv.Itoa(count))
+	return q
+}
+
+// Sort sets the sort order of the results (_sort). Prefix a parameter with - to sort descending, e.g. Sort("-date", "status").
+func (q *SearchQuery) Sort(params ...string) *SearchQuery {
+	q.values.Set("_sort", strings.Join(params, ","))
+	return q
+}
+
+// Elements limits the elements returned for every resource (_elements), e.g. Elements("identifier", "name").
+func (q *SearchQuery) Elements(elements ...string) *SearchQuery {
+	q.values.Set("_elements", strings.Join(elements, ","))
+	return q
+}
+
+// Summary sets the summary mode (_summary), e.g. SummaryCount to only return the number of matches.
+func (q *SearchQuery) Summary(mode SummaryMode) *SearchQuery {
+	q.values.Set("_summary", string(mode))
+	return q
+}
+
+// Include includes the resources referenced by the matches (_include), e.g. Include("Encounter", "participant", "Practitioner").
+// The target type is optional.
+func (q *SearchQuery) Include(resourceType string, param string, targetType ...string) *SearchQuery {
+	return q.Add("_include", includeValue(resourceType, param, targetType))
+}
+
+// RevInclude includes the resources that refer to the matches (_revinclude), e.g. RevInclude("Provenance", "target").
+// The target type is optional.
+func (q *SearchQuery) RevInclude(resourceType string, param string, targetType ...string) *SearchQuery {
+	return q.Add("_revinclude", includeValue(resourceType, param, targetType))
+}
+
+// EscapeSearchValue escapes the characters that have a special meaning in search values: , (OR), | (token and quantity separator),
+// $ (composite separator) and \ (escape character).
+func EscapeSearchValue(value string) string {
+	return searchValueEscaper.Replace(value)
+}
+
+var searchValueEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `$`, `\$`, `|`, `\|`)
+
+// referenceValueEscaper escapes reference values, leaving | intact for canonical references (url|version).
+var referenceValueEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `$`, `\$`)
+
+func tokenValue(system string, code string) string {
+	if system == "" {
+		return EscapeSearchValue(code)
+	}
+	return EscapeSearchValue(system) + "|" + EscapeSearchValue(code)
+}
+
+func includeValue(resourceType string, param string, targetType []string) string {
+	result := resourceType + ":" + param
+	if len(targetType) > 0 && targetType[0] != "" {
+		result += ":" + targetType[0]
+	}
+	return result
+}
+
+func formatSearchNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,123 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestSearchQuery(t *testing.T) {
+	t.Run("parameter types", func(t *testing.T) {
+		query := fhirclient.NewSearchQuery().
+			String("family", "van der Berg").
+			StringExact("given", "Jan").
+			StringContains("address", "Main").
+			Token("identifier", "http://example.com/mrn", "a|b,c").
+			TokenNoSystem("code", "123").
+			TokenNot("status", "", "cancelled").
+			TokenText("code", "blood pressure").
+			Date("birthdate", fhirclient.PrefixGe, "1990-01").
+			DateTime("_lastUpdated", fhirclient.PrefixLt, time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))).
+			Number("probability", fhirclient.PrefixGt, 0.8).
+			Quantity("value-quantity", fhirclient.PrefixLt, 5.4, "http://unitsofmeasure.org", "mg").
+			Reference("general-practitioner", "Practitioner/1").
+			ReferenceOfType("subject", "Patient", "123").
+			Reference("instantiates-canonical", "http://example.com/PlanDefinition/1|2.0").
+			Reference("based-on", "ServiceRequest/a,b").
+			Composite("code-value-quantity", "http://loinc.org|8480-6", "gt140").
+			Chain("subject", "Patient", "name", "peter").
+			Has("Observation", "patient", "code", "1234-5")
+
+		assert.Equal(t, url.Values{
+			"family":                        {"van der Berg"},
+			"given:exact":                   {"Jan"},
+			"address:contains":              {"Main"},
+			"identifier":                    {`http://example.com/mrn|a\|b\,c`},
+			"code":                          {"|123"},
+			"status:not":                    {"cancelled"},
+			"code:text":                     {"blood pressure"},
+			"birthdate":                     {"ge1990-01"},
+			"_lastUpdated":                  {"lt2024-01-02T03:04:05+01:00"},
+			"probability":                   {"gt0.8"},
+			"value-quantity":                {"lt5.4|http://unitsofmeasure.org|mg"},
+			"general-practitioner":          {"Practitioner/1"},
+			"subject:Patient":               {"123"},
+			"instantiates-canonical":        {"http://example.com/PlanDefinition/1|2.0"},
+			"based-on":                      {`ServiceRequest/a\,b`},
+			"code-value-quantity":           {"http://loinc.org|8480-6$gt140"},
+			"subject:Patient.name":          {"peter"},
+			"_has:Observation:patient:code": {"1234-5"},
+		}, query.Values())
+	})
+	t.Run("result parameters", func(t *testing.T) {
+		query := fhirclient.NewSearchQuery().
+			Count(10).
+			Count(50).
+			Sort("-date", "status").
+			Elements("identifier", "name").
+			Summary(fhirclient.SummaryCount).
+			Include("Encounter", "participant", "Practitioner").
+			Include("Encounter", "subject").
+			RevInclude("Provenance", "target")
+
+		assert.Equal(t, url.Values{
+			"_count":      {"50"},
+			"_sort":       {"-date,status"},
+			"_elements":   {"identifier,name"},
+			"_summary":    {"count"},
+			"_include":    {"Encounter:participant:Practitioner", "Encounter:subject"},
+			"_revinclude": {"Provenance:target"},
+		}, query.Values())
+	})
+	t.Run("Values returns a copy", func(t *testing.T) {
+		query := fhirclient.NewSearchQuery().String("name", "a")
+
+		query.Values().Add("name", "b")
+
+		assert.Equal(t, "name=a", query.Encode())
+	})
+	t.Run("encoded for GET and POST searches", func(t *testing.T) {
+		query := fhirclient.NewSearchQuery().
+			Date("date", fhirclient.PrefixGe, "2024-01-01T00:00:00+01:00").
+			Token("identifier", "http://example.com", "a&b")
+		const expected = "date=ge2024-01-01T00%3A00%3A00%2B01%3A00&identifier=http%3A%2F%2Fexample.com%7Ca%26b"
+		for _, usePostSearch := range []bool{false, true} {
+			stub := &requestResponder{response: okResponse(fhir.Bundle{})}
+			client := fhirclient.New(baseURL, stub, &fhirclient.Config{UsePostSearch: usePostSearch})
+
+			err := client.Search("Observation", query.Values(), new(fhir.Bundle))
+
+			require.NoError(t, err)
+			if usePostSearch {
+				body, _ := io.ReadAll(stub.request.Body)
+				assert.Equal(t, expected, string(body))
+			} else {
+				assert.Equal(t, expected, stub.request.URL.RawQuery)
+			}
+		}
+	})
+	t.Run("EscapeSearchValue", func(t *testing.T) {
+		assert.Equal(t, `a\,b\|c\$d\\e`, fhirclient.EscapeSearchValue(`a,b|c$d\e`))
+	})
+}
@@ -0,0 +1,171 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// SearchResult is a page of search results, of which the entries are split by search mode.
+// It indexes the resources included using _include and _revinclude, so references can be followed from the matching resources
+// to the included resources (and back) without additional requests to the FHIR server.
+// To have ResolveRef prefer included resources over reading them from the FHIR server, pass InSearchResult(result).
+type SearchResult struct {
+	// Bundle is the searchset Bundle the result was created from.
+	Bundle fhir.Bundle
+	// Matches are the entries that match the search criteria (search mode match, or no search mode).
+	Matches []fhir.BundleEntry
+	// Includes are the entries that were included using _include or _revinclude (search mode include).
+	Includes []fhir.BundleEntry
+	// Outcomes are the entries with information about the search (search mode outcome), e.g. OperationOutcomes with warnings.
+	Outcomes []fhir.BundleEntry
+	// included maps the fullUrl and relative URL (Type/id) of the included resources to the resources.
+	included map[string]json.RawMessage
+	// referencedBy maps references (as relative URL, if possible) to the included resources containing them.
+	referencedBy map[string][]json.RawMessage
+}
+
+// NewSearchResult creates a SearchResult from a searchset Bundle.
+func NewSearchResult(bundle fhir.Bundle) (*SearchResult, error) {
+	result := &SearchResult{
+		Bundle:       bundle,
+		included:     map[string]json.RawMessage{},
+		referencedBy: map[string][]json.RawMessage{},
+	}
+	for i, entry := range bundle.Entry {
+		mode := fhir.SearchEntryModeMatch
+		if entry.Search != nil && entry.Search.Mode != nil {
+			mode = *entry.Search.Mode
+		}
+		switch mode {
+		case fhir.SearchEntryModeInclude:
+			result.Includes = append(result.Includes, entry)
+		case fhir.SearchEntryModeOutcome:
+			result.Outcomes = append(result.Outcomes, entry)
+			continue
+		default:
+			result.Matches = append(result.Matches, entry)
+			continue
+		}
+		if len(entry.Resource) == 0 {
+			continue
+		}
+		desc, err := DescribeResource([]byte(entry.Resource))
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource in Bundle entry %d: %w", i, err)
+		}
+		if entry.FullUrl != nil {
+			result.included[*entry.FullUrl] = entry.Resource
+		}
+		if id := resourceID(entry.Resource); id != "" {
+			result.included[desc.Type+"/"+id] = entry.Resource
+		}
+		var resource any
+		if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+			return nil, fmt.Errorf("invalid resource in Bundle entry %d: %w", i, err)
+		}
+		for _, ref := range collectReferences(resource) {
+			ref = relativeReference(ref)
+			result.referencedBy[ref] = append(result.referencedBy[ref], entry.Resource)
+		}
+	}
+	return result, nil
+}
+
+// Included looks up the included resource the reference (e.g. Practitioner/123, or its fullUrl) refers to,
+// and unmarshals it into the target. It returns false if the resource was not included.
+func (r SearchResult) Included(ref string, target any) (bool, error) {
+	resource, ok := r.included[ref]
+	if !ok {
+		resource, ok = r.included[relativeReference(ref)]
+	}
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(resource, target); err != nil {
+		return true, fmt.Errorf("invalid included resource (reference=%s): %w", ref, err)
+	}
+	return true, nil
+}
+
+// Follow resolves the reference(s) at the given path in the resource (e.g. a match) into the target,
+// using only the resources in the search result. The path and target are as for ResolveRef.
+// It returns an error if a referenced resource is not part of the search result.
+func (r SearchResult) Follow(resource any, path string, target any) error {
+	err := resolveReference(nil, path, resource, target, resolveSettings{bundle: &r.Bundle, offline: true})
+	if err != nil {
+		return fmt.Errorf("follow reference: %w", err)
+	}
+	return nil
+}
+
+// InSearchResult specifies the search result the resource was found in (e.g. a match).
+// References are resolved from the search result's entries (e.g. the resources included using _include) if possible,
+// before reading them from the FHIR server.
+func InSearchResult(result *SearchResult) ResolveOption {
+	return InBundle(result.Bundle)
+}
+
+// RevIncluded returns the included resources that refer to the resource with the given reference (e.g. Encounter/123),
+// e.g. resources that were included using _revinclude.
+func (r SearchResult) RevIncluded(ref string) []json.RawMessage {
+	return r.referencedBy[relativeReference(ref)]
+}
+
+// collectReferences returns the values of all Reference.reference elements in the given JSON value.
+// References to contained resources (#id) are skipped, since they don't refer to other entries.
+func collectReferences(value any) []string {
+	var result []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "reference" {
+				if !strings.HasPrefix(ref, "#") {
+					result = append(result, ref)
+				}
+			} else {
+				result = append(result, collectReferences(child)...)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			result = append(result, collectReferences(child)...)
+		}
+	}
+	return result
+}
+
+// relativeReference returns the relative URL (Type/id) of an absolute reference (e.g. http://example.com/fhir/Patient/123).
+// Other references are returned as-is.
+func relativeReference(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || !u.IsAbs() || u.Scheme == "urn" {
+		return ref
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) >= 4 && segments[len(segments)-2] == "_history" {
+		segments = segments[:len(segments)-2]
+	}
+	if len(segments) < 2 || !isResourceName(segments[len(segments)-2]) {
+		return ref
+	}
+	return segments[len(segments)-2] + "/" + segments[len(segments)-1]
+}
@@ -0,0 +1,116 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSearchResult(t *testing.T) {
+	entry := func(mode fhir.SearchEntryMode, fullUrl string, resource string) fhir.BundleEntry {
+		return fhir.BundleEntry{
+			FullUrl:  ptr(fullUrl),
+			Resource: json.RawMessage(resource),
+			Search:   &fhir.BundleEntrySearch{Mode: &mode},
+		}
+	}
+	bundle := fhir.Bundle{
+		Type: fhir.BundleTypeSearchset,
+		Entry: []fhir.BundleEntry{
+			entry(fhir.SearchEntryModeMatch, "http://example.com/fhir/Encounter/1",
+				`{"resourceType":"Encounter","id":"1","status":"finished","class":{},"participant":[{"individual":{"reference":"Practitioner/1"}}]}`),
+			entry(fhir.SearchEntryModeMatch, "http://example.com/fhir/Encounter/2",
+				`{"resourceType":"Encounter","id":"2","status":"finished","class":{},"participant":[{"individual":{"reference":"http://example.com/fhir/Practitioner/2"}},{"individual":{"reference":"Practitioner/3"}}]}`),
+			entry(fhir.SearchEntryModeInclude, "http://example.com/fhir/Practitioner/1", `{"resourceType":"Practitioner","id":"1"}`),
+			entry(fhir.SearchEntryModeInclude, "http://example.com/fhir/Practitioner/2", `{"resourceType":"Practitioner","id":"2"}`),
+			entry(fhir.SearchEntryModeInclude, "http://example.com/fhir/Provenance/1",
+				`{"resourceType":"Provenance","id":"1","target":[{"reference":"http://example.com/fhir/Encounter/1"}],"recorded":"2024-01-01T00:00:00Z","agent":[]}`),
+			entry(fhir.SearchEntryModeInclude, "http://example.com/fhir/Provenance/2",
+				`{"resourceType":"Provenance","id":"2","contained":[{"resourceType":"Device","id":"1"}],"target":[{"reference":"http://example.com/fhir/Encounter/2"}],"recorded":"2024-01-01T00:00:00Z","agent":[{"who":{"reference":"#1"}}]}`),
+			entry(fhir.SearchEntryModeOutcome, "urn:uuid:1", `{"resourceType":"OperationOutcome","issue":[]}`),
+		},
+	}
+	result, err := fhirclient.NewSearchResult(bundle)
+	require.NoError(t, err)
+	var encounter1, encounter2 fhir.Encounter
+	require.NoError(t, json.Unmarshal(result.Matches[0].Resource, &encounter1))
+	require.NoError(t, json.Unmarshal(result.Matches[1].Resource, &encounter2))
+
+	t.Run("entries are split by search mode", func(t *testing.T) {
+		assert.Len(t, result.Matches, 2)
+		assert.Len(t, result.Includes, 4)
+		assert.Len(t, result.Outcomes, 1)
+	})
+	t.Run("Included", func(t *testing.T) {
+		var practitioner fhir.Practitioner
+		ok, err := result.Included("Practitioner/2", &practitioner)
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "2", *practitioner.ID)
+
+		ok, err = result.Included("Practitioner/3", &practitioner)
+
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("Follow", func(t *testing.T) {
+		var practitioner fhir.Practitioner
+		err := result.Follow(encounter1, "participant.individual", &practitioner)
+
+		require.NoError(t, err)
+		assert.Equal(t, "1", *practitioner.ID)
+	})
+	t.Run("Follow reference that wasn't included", func(t *testing.T) {
+		var practitioners []fhir.Practitioner
+		err := result.Follow(encounter2, "participant.individual", &practitioners)
+
+		require.EqualError(t, err, "follow reference: Bundle entry not found: Practitioner/3 at path: participant.individual")
+	})
+	t.Run("RevIncluded", func(t *testing.T) {
+		revIncluded := result.RevIncluded("Encounter/1")
+
+		require.Len(t, revIncluded, 1)
+		assert.Contains(t, string(revIncluded[0]), `"resourceType":"Provenance"`)
+		assert.Len(t, result.RevIncluded("Encounter/2"), 1)
+		assert.Empty(t, result.RevIncluded("Encounter/3"))
+	})
+	t.Run("RevIncluded ignores contained references", func(t *testing.T) {
+		assert.Empty(t, result.RevIncluded("#1"))
+	})
+	t.Run("ResolveRef prefers included resources", func(t *testing.T) {
+		client := NewMockClient(gomock.NewController(t))
+		client.EXPECT().Read("Practitioner/3", gomock.Any()).DoAndReturn(func(_ string, r *fhir.Practitioner, _ ...fhirclient.Option) error {
+			r.ID = ptr("3")
+			return nil
+		})
+
+		var practitioners []fhir.Practitioner
+		err := fhirclient.ResolveRef("participant.individual", &practitioners, fhirclient.InSearchResult(result))(client, encounter2)
+
+		require.NoError(t, err)
+		require.Len(t, practitioners, 2)
+		assert.Equal(t, "2", *practitioners[0].ID)
+		assert.Equal(t, "3", *practitioners[1].ID)
+	})
+}
@@ -17,7 +17,9 @@ package fhirclient
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"iter"
 	"net/url"
 	"strings"
 
@@ -29,6 +31,7 @@ import (
 // The function will stop if there are no more pages (no "next" link in the Bundle).
 // It will return an error if any of the calls to consumeFunc or the FHIR server fail.
 // By default, it will stop after 100 iterations to prevent endless loops due to bugs in the FHIR server or the code.
+// History Bundles can be paged through as well; their pages are always retrieved using GET, since history is only defined for GET.
 func Paginate(ctx context.Context, fhirClient Client, searchSet fhir.Bundle, consumeFunc func(*fhir.Bundle) (bool, error), opts ...PaginationOption) error {
 	options := &paginationOptions{
 		maxIterations: 100,
@@ -50,39 +53,175 @@ func Paginate(ctx context.Context, fhirClient Client, searchSet fhir.Bundle, con
 			return nil
 		}
 
-		hasNext := false
-		for _, link := range searchSet.Link {
-			if link.Relation == "next" {
-				var err error
-				if nextURL, err = url.Parse(link.Url); err != nil {
-					return fmt.Errorf("paginate: invalid 'next' link for search set: %w", err)
-				}
-				if !strings.HasPrefix(link.Url, fhirClient.Path().String()) {
-					return fmt.Errorf("paginate: next link for search set does not start with expected FHIR base URL")
-				}
-				hasNext = true
-			}
+		var err error
+		if nextURL, err = nextPageURL(fhirClient, searchSet); err != nil {
+			return fmt.Errorf("paginate: %w", err)
 		}
-		if !hasNext {
+		if nextURL == nil {
 			break
 		}
+		history := searchSet.Type == fhir.BundleTypeHistory
 		searchSet = fhir.Bundle{}
-		if err := fhirClient.SearchWithContext(ctx, "", nil, &searchSet, AtUrl(nextURL)); err != nil {
+		if history {
+			// History is only defined for GET, so its pages can't be retrieved by searching (which might use POST)
+			err = fhirClient.ReadWithContext(ctx, "", &searchSet, AtUrl(nextURL))
+		} else {
+			err = fhirClient.SearchWithContext(ctx, "", nil, &searchSet, AtUrl(nextURL))
+		}
+		if err != nil {
 			return fmt.Errorf("pagintate: query next page failed (url=%s): %w", nextURL, err)
 		}
 	}
 	return nil
 }
 
+// SearchAll searches for resources of type T on the FHIR server, and returns an iterator that yields the matching resources of all pages.
+// The resource type is derived from T, which must be a FHIR resource model (e.g. fhir.Patient).
+// Entries with search mode include are not yielded, but passed to the handler registered using WithIncludes (if any).
+// Other entries that aren't of type T (e.g. OperationOutcomes) are skipped.
+// If an error occurs, it's yielded as the last element of the iterator.
+// Unlike Paginate, there's no limit on the number of pages unless WithMaxIterations is specified.
+func SearchAll[T any](ctx context.Context, fhirClient Client, query url.Values, opts ...PaginationOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		options := &paginationOptions{}
+		for _, opt := range opts {
+			opt(options)
+		}
+		resourceType, err := resourceTypeOf[T]()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		// Make sure a prefetch in progress is cancelled when the consumer stops early
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		page := fetchPage(ctx, fhirClient, resourceType, query, nil)
+		for iteration := 1; ; iteration++ {
+			if page.err != nil {
+				yield(zero, page.err)
+				return
+			}
+			nextURL, err := nextPageURL(fhirClient, page.bundle)
+			if err != nil {
+				yield(zero, fmt.Errorf("search: %w", err))
+				return
+			}
+			if nextURL != nil && options.maxIterations > 0 && iteration >= options.maxIterations {
+				yield(zero, fmt.Errorf("search: max. search iterations reached (%d)", options.maxIterations))
+				return
+			}
+			var prefetched chan searchPage
+			if nextURL != nil && options.prefetch {
+				prefetched = make(chan searchPage, 1)
+				go func() {
+					prefetched <- fetchPage(ctx, fhirClient, "", nil, nextURL)
+				}()
+			}
+			for i, entry := range page.bundle.Entry {
+				if err := ctx.Err(); err != nil {
+					yield(zero, err)
+					return
+				}
+				if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode == fhir.SearchEntryModeInclude {
+					if options.includeHandler != nil {
+						options.includeHandler(entry)
+					}
+					continue
+				}
+				if len(entry.Resource) == 0 {
+					continue
+				}
+				desc, err := DescribeResource([]byte(entry.Resource))
+				if err != nil {
+					yield(zero, fmt.Errorf("search: invalid resource in Bundle entry %d: %w", i, err))
+					return
+				}
+				if desc.Type != resourceType {
+					continue
+				}
+				var result T
+				if err := json.Unmarshal(entry.Resource, &result); err != nil {
+					yield(zero, fmt.Errorf("search: invalid %s in Bundle entry %d: %w", resourceType, i, err))
+					return
+				}
+				if !yield(result, nil) {
+					return
+				}
+			}
+			if nextURL == nil {
+				return
+			}
+			if prefetched != nil {
+				page = <-prefetched
+			} else {
+				page = fetchPage(ctx, fhirClient, "", nil, nextURL)
+			}
+		}
+	}
+}
+
+// searchPage is the result of fetching a page of a search set.
+type searchPage struct {
+	bundle fhir.Bundle
+	err    error
+}
+
+// fetchPage performs the search, or fetches the next page of a search set if nextURL is set.
+func fetchPage(ctx context.Context, fhirClient Client, resourceType string, query url.Values, nextURL *url.URL) searchPage {
+	var result searchPage
+	if nextURL == nil {
+		result.err = fhirClient.SearchWithContext(ctx, resourceType, query, &result.bundle)
+	} else if err := fhirClient.SearchWithContext(ctx, "", nil, &result.bundle, AtUrl(nextURL)); err != nil {
+		result.err = fmt.Errorf("search: query next page failed (url=%s): %w", nextURL, err)
+	}
+	return result
+}
+
+// nextPageURL returns the URL of the next page of the search set, or nil if there is no next page.
+// It returns an error if the next link is invalid, or doesn't point to the FHIR server.
+func nextPageURL(fhirClient Client, searchSet fhir.Bundle) (*url.URL, error) {
+	var nextURL *url.URL
+	for _, link := range searchSet.Link {
+		if link.Relation == "next" {
+			var err error
+			if nextURL, err = url.Parse(link.Url); err != nil {
+				return nil, fmt.Errorf("invalid 'next' link for search set: %w", err)
+			}
+			if !strings.HasPrefix(link.Url, fhirClient.Path().String()) {
+				return nil, fmt.Errorf("next link for search set does not start with expected FHIR base URL")
+			}
+		}
+	}
+	return nextURL, nil
+}
+
 type PaginationOption func(*paginationOptions)
 
 type paginationOptions struct {
-	maxIterations int
+	maxIterations  int
+	prefetch       bool
+	includeHandler func(entry fhir.BundleEntry)
 }
 
-// WithMaxIterations sets the maximum number of iterations for the Paginate function.
+// WithMaxIterations sets the maximum number of iterations (pages) for the Paginate and SearchAll functions.
 func WithMaxIterations(max int) PaginationOption {
 	return func(o *paginationOptions) {
 		o.maxIterations = max
 	}
 }
+
+// WithPrefetch makes SearchAll fetch the next page concurrently, while the current page is being consumed.
+func WithPrefetch() PaginationOption {
+	return func(o *paginationOptions) {
+		o.prefetch = true
+	}
+}
+
+// WithIncludes registers a handler for entries with search mode include (e.g. resources requested using _include) in SearchAll.
+func WithIncludes(handler func(entry fhir.BundleEntry)) PaginationOption {
+	return func(o *paginationOptions) {
+		o.includeHandler = handler
+	}
+}
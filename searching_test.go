@@ -318,6 +318,121 @@ func TestPaginate(t *testing.T) {
 	})
 }
 
+func TestSearchAll(t *testing.T) {
+	baseURL, _ := url.Parse("http://example.com/fhir")
+	patientEntry := func(id string) fhir.BundleEntry {
+		data, _ := json.Marshal(fhir.Patient{ID: &id})
+		return fhir.BundleEntry{Resource: data}
+	}
+	includeMode := fhir.SearchEntryModeInclude
+	organization, _ := json.Marshal(fhir.Organization{})
+	newStub := func() *requestsResponder {
+		firstPage := createBundleWithNextLink("http://example.com/fhir/page2")
+		firstPage.Entry = []fhir.BundleEntry{
+			patientEntry("1"),
+			{Resource: organization, Search: &fhir.BundleEntrySearch{Mode: &includeMode}},
+			patientEntry("2"),
+		}
+		secondPage := createBundleWithoutNextLink()
+		secondPage.Entry = []fhir.BundleEntry{patientEntry("3")}
+		return &requestsResponder{
+			responses: []*http.Response{createBundleResponse(firstPage), createBundleResponse(secondPage)},
+		}
+	}
+
+	t.Run("yields resources of all pages", func(t *testing.T) {
+		stub := newStub()
+		client := New(baseURL, stub, nil)
+		var ids []string
+		var includes []fhir.BundleEntry
+
+		for patient, err := range SearchAll[fhir.Patient](context.Background(), client, url.Values{"_include": {"Patient:organization"}}, WithIncludes(func(entry fhir.BundleEntry) {
+			includes = append(includes, entry)
+		})) {
+			require.NoError(t, err)
+			ids = append(ids, *patient.ID)
+		}
+
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+		assert.Len(t, includes, 1)
+		require.Len(t, stub.requests, 2)
+		assert.Equal(t, "http://example.com/fhir/Patient/_search", stub.requests[0].URL.String())
+		assert.Equal(t, "http://example.com/fhir/page2", stub.requests[1].URL.String())
+	})
+	t.Run("with prefetch", func(t *testing.T) {
+		stub := newStub()
+		client := New(baseURL, stub, nil)
+		var ids []string
+
+		for patient, err := range SearchAll[fhir.Patient](context.Background(), client, nil, WithPrefetch()) {
+			require.NoError(t, err)
+			ids = append(ids, *patient.ID)
+		}
+
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+		assert.Len(t, stub.requests, 2)
+	})
+	t.Run("consumer stops early", func(t *testing.T) {
+		stub := newStub()
+		client := New(baseURL, stub, nil)
+		var ids []string
+
+		for patient, err := range SearchAll[fhir.Patient](context.Background(), client, nil) {
+			require.NoError(t, err)
+			ids = append(ids, *patient.ID)
+			break
+		}
+
+		assert.Equal(t, []string{"1"}, ids)
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("max. iterations reached", func(t *testing.T) {
+		stub := newStub()
+		client := New(baseURL, stub, nil)
+		var lastErr error
+
+		for _, err := range SearchAll[fhir.Patient](context.Background(), client, nil, WithMaxIterations(1)) {
+			lastErr = err
+		}
+
+		assert.EqualError(t, lastErr, "search: max. search iterations reached (1)")
+		assert.Len(t, stub.requests, 1)
+	})
+	t.Run("context cancelled", func(t *testing.T) {
+		stub := newStub()
+		client := New(baseURL, stub, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var ids []string
+		var lastErr error
+
+		for patient, err := range SearchAll[fhir.Patient](ctx, client, nil) {
+			if err != nil {
+				lastErr = err
+				break
+			}
+			ids = append(ids, *patient.ID)
+			cancel()
+		}
+
+		assert.ErrorIs(t, lastErr, context.Canceled)
+		assert.Equal(t, []string{"1"}, ids)
+	})
+	t.Run("next link outside base URL", func(t *testing.T) {
+		stub := &requestsResponder{
+			responses: []*http.Response{createBundleResponse(createBundleWithNextLink("http://evil.com/fhir/page2"))},
+		}
+		client := New(baseURL, stub, nil)
+		var lastErr error
+
+		for _, err := range SearchAll[fhir.Patient](context.Background(), client, nil) {
+			lastErr = err
+		}
+
+		assert.EqualError(t, lastErr, "search: next link for search set does not start with expected FHIR base URL")
+	})
+}
+
 func createBundleWithNextLink(nextURL string) fhir.Bundle {
 	return fhir.Bundle{
 		Link: []fhir.BundleLink{
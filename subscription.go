@@ -0,0 +1,549 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+const (
+	backportURL                 = "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/"
+	backportSubscriptionProfile = backportURL + "backport-subscription"
+)
+
+// Types of subscription notifications.
+const (
+	NotificationTypeHandshake         = "handshake"
+	NotificationTypeHeartbeat         = "heartbeat"
+	NotificationTypeEventNotification = "event-notification"
+	NotificationTypeQueryStatus       = "query-status"
+	NotificationTypeQueryEvent        = "query-event"
+)
+
+// PayloadContent specifies how much of the resources is included in subscription notifications.
+type PayloadContent string
+
+const (
+	// PayloadEmpty only includes the event numbers, not the resources.
+	PayloadEmpty PayloadContent = "empty"
+	// PayloadIDOnly includes references to the resources.
+	PayloadIDOnly PayloadContent = "id-only"
+	// PayloadFullResource includes the resources.
+	PayloadFullResource PayloadContent = "full-resource"
+)
+
+// SubscriptionRequest describes a topic-based Subscription to create, see CreateSubscription.
+type SubscriptionRequest struct {
+	// Topic is the canonical URL of the SubscriptionTopic.
+	Topic string
+	// FilterCriteria narrow down the events of the topic, e.g. "Task?owner=Organization/1".
+	FilterCriteria []string
+	// Endpoint is the URL notifications are sent to (rest-hook channel).
+	Endpoint string
+	// Headers are sent with every notification, e.g. "Authorization: Bearer secret".
+	Headers []string
+	// PayloadContent specifies how much of the resources is included in notifications. If empty, PayloadIDOnly is used.
+	PayloadContent PayloadContent
+	// ContentType is the media type of the notifications. If empty, FhirJsonMediaType is used.
+	ContentType string
+	// HeartbeatPeriod is the interval at which the server sends heartbeats if there are no events. If zero, no heartbeats are requested.
+	HeartbeatPeriod time.Duration
+	// Timeout is the maximum time the server waits for the endpoint to respond. If zero, the server's default is used.
+	Timeout time.Duration
+	// MaxCount is the maximum number of events in a single notification. If zero, the server's default is used.
+	MaxCount int
+	// End is the time the Subscription ends. If nil, it doesn't end.
+	End *time.Time
+	// Reason describes why the Subscription is created.
+	Reason string
+}
+
+// Resource returns the Subscription resource for the request, according to the R4 Subscriptions Backport IG.
+// The topic and filter criteria are specified using (primitive) extensions, which aren't supported by fhir.Subscription,
+// so the resource is returned as map.
+func (r SubscriptionRequest) Resource() map[string]any {
+	payloadContent := r.PayloadContent
+	if payloadContent == "" {
+		payloadContent = PayloadIDOnly
+	}
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = FhirJsonMediaType
+	}
+	reason := r.Reason
+	if reason == "" {
+		reason = "Subscription to " + r.Topic
+	}
+	var channelExtensions []any
+	if r.HeartbeatPeriod > 0 {
+		channelExtensions = append(channelExtensions, map[string]any{"url": backportURL + "backport-heartbeat-period", "valueUnsignedInt": int(r.HeartbeatPeriod.Seconds())})
+	}
+	if r.Timeout > 0 {
+		channelExtensions = append(channelExtensions, map[string]any{"url": backportURL + "backport-timeout", "valueUnsignedInt": int(r.Timeout.Seconds())})
+	}
+	if r.MaxCount > 0 {
+		channelExtensions = append(channelExtensions, map[string]any{"url": backportURL + "backport-max-count", "valuePositiveInt": r.MaxCount})
+	}
+	channel := map[string]any{
+		"type":     "rest-hook",
+		"endpoint": r.Endpoint,
+		"payload":  contentType,
+		"_payload": map[string]any{
+			"extension": []any{map[string]any{"url": backportURL + "backport-payload-content", "valueCode": string(payloadContent)}},
+		},
+	}
+	if len(channelExtensions) > 0 {
+		channel["extension"] = channelExtensions
+	}
+	if len(r.Headers) > 0 {
+		channel["header"] = r.Headers
+	}
+	result := map[string]any{
+		"resourceType": "Subscription",
+		"meta":         map[string]any{"profile": []string{backportSubscriptionProfile}},
+		"status":       "requested",
+		"reason":       reason,
+		"criteria":     r.Topic,
+		"channel":      channel,
+	}
+	if len(r.FilterCriteria) > 0 {
+		var extensions []any
+		for _, criteria := range r.FilterCriteria {
+			extensions = append(extensions, map[string]any{"url": backportURL + "backport-filter-criteria", "valueString": criteria})
+		}
+		result["_criteria"] = map[string]any{"extension": extensions}
+	}
+	if r.End != nil {
+		result["end"] = r.End.Format(time.RFC3339)
+	}
+	return result
+}
+
+// CreateSubscription creates a topic-based Subscription on the FHIR server. The server will send a handshake to the endpoint,
+// after which it activates the Subscription. Use a NotificationHandler at the endpoint to receive the notifications.
+func CreateSubscription(ctx context.Context, client Client, request SubscriptionRequest, opts ...Option) (*fhir.Subscription, error) {
+	if request.Topic == "" || request.Endpoint == "" {
+		return nil, errors.New("subscription request must have a topic and endpoint")
+	}
+	var result fhir.Subscription
+	if err := client.CreateWithContext(ctx, request.Resource(), &result, opts...); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SubscriptionNotification is a parsed subscription notification Bundle.
+// Both the R4 Subscriptions Backport format (Parameters) and the R4B/R5 format (SubscriptionStatus) are supported.
+type SubscriptionNotification struct {
+	// Type is the type of the notification, e.g. NotificationTypeEventNotification.
+	Type string
+	// Subscription is the reference to the Subscription.
+	Subscription string
+	// Topic is the canonical URL of the SubscriptionTopic.
+	Topic string
+	// Status is the status of the Subscription, e.g. active.
+	Status string
+	// EventsSinceSubscriptionStart is the number of events the server generated for the Subscription, including the events in this notification.
+	EventsSinceSubscriptionStart int64
+	// Events are the events in the notification, in order of event number.
+	Events []SubscriptionEvent
+}
+
+// SubscriptionEvent is an event in a subscription notification.
+type SubscriptionEvent struct {
+	// Subscription is the reference to the Subscription.
+	Subscription string
+	// Topic is the canonical URL of the SubscriptionTopic.
+	Topic string
+	// EventNumber is the number of the event, which increments by 1 for every event of the Subscription.
+	EventNumber int64
+	// Timestamp is the time the event occurred, if present.
+	Timestamp *time.Time
+	// Focus is the reference to the resource the event is about. It's nil for PayloadEmpty.
+	Focus *fhir.Reference
+	// AdditionalContext are references to additional resources related to the event.
+	AdditionalContext []fhir.Reference
+	// Resource is the focus resource, if the notification contains it (PayloadFullResource).
+	Resource json.RawMessage
+}
+
+// Unmarshal unmarshals the focus resource of the event into the target.
+func (e SubscriptionEvent) Unmarshal(target any) error {
+	if len(e.Resource) == 0 {
+		return fmt.Errorf("subscription event %d doesn't contain the focus resource", e.EventNumber)
+	}
+	return json.Unmarshal(e.Resource, target)
+}
+
+// notificationBundle is the part of a subscription notification Bundle that's needed to parse it.
+// fhir.Bundle isn't used, since it can't hold the subscription-notification Bundle type of R4B/R5.
+type notificationBundle struct {
+	Type  string `json:"type"`
+	Entry []struct {
+		FullUrl  *string         `json:"fullUrl"`
+		Resource json.RawMessage `json:"resource"`
+	} `json:"entry"`
+}
+
+// ParseSubscriptionNotification parses a subscription notification Bundle (JSON or XML), and validates it contains a subscription status.
+func ParseSubscriptionNotification(data []byte) (*SubscriptionNotification, error) {
+	var resource json.RawMessage
+	if err := detectCodec(data).Unmarshal(data, &resource); err != nil {
+		return nil, fmt.Errorf("invalid subscription notification: %w", err)
+	}
+	var bundle notificationBundle
+	if err := json.Unmarshal(resource, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid subscription notification: %w", err)
+	}
+	if bundle.Type != "history" && bundle.Type != "subscription-notification" {
+		return nil, fmt.Errorf("invalid subscription notification: unexpected Bundle type: %s", bundle.Type)
+	}
+	if len(bundle.Entry) == 0 || len(bundle.Entry[0].Resource) == 0 {
+		return nil, errors.New("invalid subscription notification: first entry must contain the subscription status")
+	}
+	var status struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(bundle.Entry[0].Resource, &status); err != nil {
+		return nil, fmt.Errorf("invalid subscription notification: %w", err)
+	}
+	var result *SubscriptionNotification
+	var err error
+	switch status.ResourceType {
+	case "Parameters":
+		result, err = parseBackportStatus(bundle.Entry[0].Resource)
+	case "SubscriptionStatus":
+		result, err = parseSubscriptionStatus(bundle.Entry[0].Resource)
+	default:
+		return nil, fmt.Errorf("invalid subscription notification: unexpected status resource: %s", status.ResourceType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription notification: %w", err)
+	}
+	switch result.Type {
+	case NotificationTypeHandshake, NotificationTypeHeartbeat, NotificationTypeQueryStatus:
+	case NotificationTypeEventNotification, NotificationTypeQueryEvent:
+		if len(result.Events) == 0 && result.Type == NotificationTypeEventNotification {
+			return nil, errors.New("invalid subscription notification: event notification without events")
+		}
+	default:
+		return nil, fmt.Errorf("invalid subscription notification: unknown type: %s", result.Type)
+	}
+	if result.Subscription == "" {
+		return nil, errors.New("invalid subscription notification: subscription not present")
+	}
+	for i, event := range result.Events {
+		event.Subscription = result.Subscription
+		event.Topic = result.Topic
+		if event.Focus != nil && event.Focus.Reference != nil {
+			event.Resource = findNotificationResource(bundle, *event.Focus.Reference)
+		}
+		result.Events[i] = event
+	}
+	return result, nil
+}
+
+// parseBackportStatus parses a subscription status in the format of the R4 Subscriptions Backport IG.
+func parseBackportStatus(data json.RawMessage) (*SubscriptionNotification, error) {
+	var resource fhir.Parameters
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, err
+	}
+	parameters := ParametersOf(resource)
+	result := &SubscriptionNotification{}
+	result.Type, _ = parameters.StringValue("type")
+	result.Topic, _ = parameters.StringValue("topic")
+	result.Status, _ = parameters.StringValue("status")
+	if subscription, ok := parameters.Value("subscription"); ok && subscription.ValueReference != nil && subscription.ValueReference.Reference != nil {
+		result.Subscription = *subscription.ValueReference.Reference
+	}
+	if value, ok := parameters.StringValue("events-since-subscription-start"); ok {
+		var err error
+		if result.EventsSinceSubscriptionStart, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid events-since-subscription-start: %w", err)
+		}
+	}
+	for _, part := range parameters.PartValues("notification-event") {
+		var event SubscriptionEvent
+		value, _ := part.StringValue("event-number")
+		var err error
+		if event.EventNumber, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid event-number: %w", err)
+		}
+		if timestamp, ok := part.StringValue("timestamp"); ok {
+			event.Timestamp = parseTimestamp(timestamp)
+		}
+		if focus, ok := part.Value("focus"); ok {
+			event.Focus = focus.ValueReference
+		}
+		for _, context := range part.Values("additional-context") {
+			if context.ValueReference != nil {
+				event.AdditionalContext = append(event.AdditionalContext, *context.ValueReference)
+			}
+		}
+		result.Events = append(result.Events, event)
+	}
+	return result, nil
+}
+
+// parseSubscriptionStatus parses a SubscriptionStatus resource (R4B/R5).
+func parseSubscriptionStatus(data json.RawMessage) (*SubscriptionNotification, error) {
+	// integer64 values are represented as JSON string
+	var resource struct {
+		Status                       string         `json:"status"`
+		Type                         string         `json:"type"`
+		EventsSinceSubscriptionStart json.Number    `json:"eventsSinceSubscriptionStart"`
+		Subscription                 fhir.Reference `json:"subscription"`
+		Topic                        string         `json:"topic"`
+		NotificationEvent            []struct {
+			EventNumber       json.Number      `json:"eventNumber"`
+			Timestamp         string           `json:"timestamp"`
+			Focus             *fhir.Reference  `json:"focus"`
+			AdditionalContext []fhir.Reference `json:"additionalContext"`
+		} `json:"notificationEvent"`
+	}
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, err
+	}
+	result := &SubscriptionNotification{
+		Type:   resource.Type,
+		Topic:  resource.Topic,
+		Status: resource.Status,
+	}
+	if resource.Subscription.Reference != nil {
+		result.Subscription = *resource.Subscription.Reference
+	}
+	if resource.EventsSinceSubscriptionStart != "" {
+		var err error
+		if result.EventsSinceSubscriptionStart, err = resource.EventsSinceSubscriptionStart.Int64(); err != nil {
+			return nil, fmt.Errorf("invalid eventsSinceSubscriptionStart: %w", err)
+		}
+	}
+	for _, notificationEvent := range resource.NotificationEvent {
+		eventNumber, err := notificationEvent.EventNumber.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid eventNumber: %w", err)
+		}
+		result.Events = append(result.Events, SubscriptionEvent{
+			EventNumber:       eventNumber,
+			Timestamp:         parseTimestamp(notificationEvent.Timestamp),
+			Focus:             notificationEvent.Focus,
+			AdditionalContext: notificationEvent.AdditionalContext,
+		})
+	}
+	return result, nil
+}
+
+func parseTimestamp(value string) *time.Time {
+	result, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
+
+// findNotificationResource returns the resource in the notification Bundle with the given (absolute or relative) reference.
+func findNotificationResource(bundle notificationBundle, reference string) json.RawMessage {
+	for _, entry := range bundle.Entry[1:] {
+		if entry.FullUrl == nil || len(entry.Resource) == 0 {
+			continue
+		}
+		if *entry.FullUrl == reference || strings.HasSuffix(*entry.FullUrl, "/"+reference) {
+			return entry.Resource
+		}
+	}
+	return nil
+}
+
+// NotificationHandler is an http.Handler that receives rest-hook subscription notifications.
+// It validates the notifications, and dispatches their events to a callback in order of event number.
+// It tracks the number of events of every Subscription (eventsSinceSubscriptionStart): events that were already dispatched are skipped,
+// and if events are missing (e.g. because a notification got lost) they're retrieved using the $events operation before dispatching newer events.
+// Since the numbering of a Subscription's events is only known after its first notification, use Track to continue after a restart.
+// Notifications are processed one at a time.
+type NotificationHandler struct {
+	client      Client
+	onEvent     func(ctx context.Context, event SubscriptionEvent) error
+	onHandshake func(ctx context.Context, notification SubscriptionNotification)
+	onHeartbeat func(ctx context.Context, notification SubscriptionNotification)
+	mux         sync.Mutex
+	// eventCounts maps the Subscription references to the number of the last dispatched event.
+	eventCounts map[string]int64
+}
+
+// NotificationHandlerOption configures a NotificationHandler.
+type NotificationHandlerOption func(h *NotificationHandler)
+
+// OnHandshake sets the function that's called when a handshake is received, e.g. to mark the Subscription as active.
+func OnHandshake(fn func(ctx context.Context, notification SubscriptionNotification)) NotificationHandlerOption {
+	return func(h *NotificationHandler) {
+		h.onHandshake = fn
+	}
+}
+
+// OnHeartbeat sets the function that's called when a heartbeat is received, e.g. to detect a Subscription stopped working.
+func OnHeartbeat(fn func(ctx context.Context, notification SubscriptionNotification)) NotificationHandlerOption {
+	return func(h *NotificationHandler) {
+		h.onHeartbeat = fn
+	}
+}
+
+// NewNotificationHandler creates a NotificationHandler that dispatches events to onEvent, and uses the client to retrieve missing events.
+// If onEvent returns an error, the notification is rejected so the server retries it; the remaining events aren't dispatched.
+func NewNotificationHandler(client Client, onEvent func(ctx context.Context, event SubscriptionEvent) error, opts ...NotificationHandlerOption) *NotificationHandler {
+	result := &NotificationHandler{
+		client:      client,
+		onEvent:     onEvent,
+		eventCounts: map[string]int64{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Track sets the number of the last event that was dispatched for the Subscription, e.g. as persisted before a restart.
+func (h *NotificationHandler) Track(subscription string, eventNumber int64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.eventCounts[subscription] = eventNumber
+}
+
+// LastEventNumber returns the number of the last event that was dispatched for the Subscription, or false if it isn't tracked.
+func (h *NotificationHandler) LastEventNumber(subscription string) (int64, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	result, ok := h.eventCounts[subscription]
+	return result, ok
+}
+
+func (h *NotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(DefaultConfig().MaxResponseSize)))
+	if err != nil {
+		http.Error(w, "failed to read notification", http.StatusBadRequest)
+		return
+	}
+	notification, err := ParseSubscriptionNotification(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Handle(r.Context(), *notification); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Handle processes a parsed notification: it retrieves missing events and dispatches the new events.
+func (h *NotificationHandler) Handle(ctx context.Context, notification SubscriptionNotification) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	_, tracked := h.eventCounts[notification.Subscription]
+	switch notification.Type {
+	case NotificationTypeHandshake:
+		if !tracked {
+			h.eventCounts[notification.Subscription] = notification.EventsSinceSubscriptionStart
+		}
+		if h.onHandshake != nil {
+			h.onHandshake(ctx, notification)
+		}
+		return nil
+	case NotificationTypeHeartbeat:
+		if h.onHeartbeat != nil {
+			h.onHeartbeat(ctx, notification)
+		}
+		if !tracked {
+			h.eventCounts[notification.Subscription] = notification.EventsSinceSubscriptionStart
+			return nil
+		}
+		// Events that happened since the last notification got lost
+		return h.catchUp(ctx, notification.Subscription, notification.EventsSinceSubscriptionStart+1)
+	}
+	if len(notification.Events) == 0 {
+		return nil
+	}
+	if tracked {
+		if err := h.catchUp(ctx, notification.Subscription, notification.Events[0].EventNumber); err != nil {
+			return err
+		}
+	} else {
+		h.eventCounts[notification.Subscription] = notification.Events[0].EventNumber - 1
+	}
+	return h.dispatch(ctx, notification.Subscription, notification.Events)
+}
+
+// catchUp retrieves and dispatches the events of the Subscription between the last dispatched event and the given event number (exclusive).
+// The caller must hold the lock.
+func (h *NotificationHandler) catchUp(ctx context.Context, subscription string, untilEventNumber int64) error {
+	since := h.eventCounts[subscription] + 1
+	if since >= untilEventNumber {
+		return nil
+	}
+	_, id, ok := strings.Cut(subscription, "Subscription/")
+	id, _, _ = strings.Cut(id, "/")
+	if !ok || id == "" {
+		return fmt.Errorf("missed events %d-%d, but can't retrieve them for subscription: %s", since, untilEventNumber-1, subscription)
+	}
+	parameters := NewParameters().
+		String("eventsSinceNumber", strconv.FormatInt(since, 10)).
+		String("eventsUntilNumber", strconv.FormatInt(untilEventNumber-1, 10))
+	var bundle json.RawMessage
+	if err := h.client.OperationWithContext(ctx, "Subscription/"+id, "$events", parameters, &bundle, OperationUsingGET()); err != nil {
+		return fmt.Errorf("failed to retrieve missed events %d-%d of subscription %s: %w", since, untilEventNumber-1, subscription, err)
+	}
+	missed, err := ParseSubscriptionNotification(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve missed events %d-%d of subscription %s: %w", since, untilEventNumber-1, subscription, err)
+	}
+	var events []SubscriptionEvent
+	for _, event := range missed.Events {
+		if event.EventNumber < untilEventNumber {
+			events = append(events, event)
+		}
+	}
+	return h.dispatch(ctx, subscription, events)
+}
+
+// dispatch calls onEvent for the events that haven't been dispatched yet. The caller must hold the lock.
+func (h *NotificationHandler) dispatch(ctx context.Context, subscription string, events []SubscriptionEvent) error {
+	for _, event := range events {
+		if event.EventNumber <= h.eventCounts[subscription] {
+			// Already dispatched, e.g. the server retried a notification
+			continue
+		}
+		if err := h.onEvent(ctx, event); err != nil {
+			return fmt.Errorf("subscription event %d: %w", event.EventNumber, err)
+		}
+		h.eventCounts[subscription] = event.EventNumber
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// backportNotification returns an R4 backport notification Bundle with full-resource events for Task/<event number>.
+func backportNotification(notificationType string, eventsSinceStart int, eventNumbers ...int) string {
+	var events, entries []string
+	for _, number := range eventNumbers {
+		events = append(events, fmt.Sprintf(`{"name":"notification-event","part":[`+
+			`{"name":"event-number","valueString":"%d"},`+
+			`{"name":"timestamp","valueInstant":"2024-01-02T03:04:05Z"},`+
+			`{"name":"focus","valueReference":{"reference":"Task/%d"}}]}`, number, number))
+		entries = append(entries, fmt.Sprintf(`{"fullUrl":"http://example.com/fhir/Task/%d","resource":{"resourceType":"Task","id":"%d","status":"requested"}}`, number, number))
+	}
+	parameters := append([]string{
+		`{"name":"subscription","valueReference":{"reference":"http://example.com/fhir/Subscription/1"}}`,
+		`{"name":"topic","valueCanonical":"http://example.com/topic"}`,
+		`{"name":"status","valueCode":"active"}`,
+		`{"name":"type","valueCode":"` + notificationType + `"}`,
+		fmt.Sprintf(`{"name":"events-since-subscription-start","valueString":"%d"}`, eventsSinceStart),
+	}, events...)
+	entries = append([]string{`{"fullUrl":"urn:uuid:1","resource":{"resourceType":"Parameters","parameter":[` + strings.Join(parameters, ",") + `]}}`}, entries...)
+	return `{"resourceType":"Bundle","type":"history","entry":[` + strings.Join(entries, ",") + `]}`
+}
+
+func TestSubscriptionRequest_Resource(t *testing.T) {
+	end := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := fhirclient.SubscriptionRequest{
+		Topic:           "http://example.com/topic",
+		FilterCriteria:  []string{"Task?owner=Organization/1"},
+		Endpoint:        "https://example.com/notify",
+		Headers:         []string{"Authorization: Bearer secret"},
+		PayloadContent:  fhirclient.PayloadFullResource,
+		HeartbeatPeriod: time.Minute,
+		Timeout:         10 * time.Second,
+		MaxCount:        5,
+		End:             &end,
+	}
+
+	data, err := fhirclient.JSONCodec.Marshal(request.Resource())
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"resourceType": "Subscription",
+		"meta": {"profile": ["http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-subscription"]},
+		"status": "requested",
+		"reason": "Subscription to http://example.com/topic",
+		"end": "2025-01-01T00:00:00Z",
+		"criteria": "http://example.com/topic",
+		"_criteria": {"extension": [{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-filter-criteria", "valueString": "Task?owner=Organization/1"}]},
+		"channel": {
+			"type": "rest-hook",
+			"endpoint": "https://example.com/notify",
+			"header": ["Authorization: Bearer secret"],
+			"payload": "application/fhir+json",
+			"_payload": {"extension": [{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-payload-content", "valueCode": "full-resource"}]},
+			"extension": [
+				{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-heartbeat-period", "valueUnsignedInt": 60},
+				{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-timeout", "valueUnsignedInt": 10},
+				{"url": "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-max-count", "valuePositiveInt": 5}
+			]
+		}
+	}`, string(data))
+}
+
+func TestCreateSubscription(t *testing.T) {
+	ctx := context.Background()
+	t.Run("ok", func(t *testing.T) {
+		stub := &requestResponder{response: okResponse(fhir.Subscription{ID: ptr("1"), Status: fhir.SubscriptionStatusRequested})}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		result, err := fhirclient.CreateSubscription(ctx, client, fhirclient.SubscriptionRequest{
+			Topic:    "http://example.com/topic",
+			Endpoint: "https://example.com/notify",
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, "1", *result.ID)
+		assert.Equal(t, http.MethodPost, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir/Subscription", stub.request.URL.String())
+		body, _ := io.ReadAll(stub.request.Body)
+		assert.Contains(t, string(body), `"valueCode":"id-only"`)
+	})
+	t.Run("topic missing", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		_, err := fhirclient.CreateSubscription(ctx, client, fhirclient.SubscriptionRequest{Endpoint: "https://example.com/notify"})
+
+		assert.EqualError(t, err, "subscription request must have a topic and endpoint")
+	})
+}
+
+func TestParseSubscriptionNotification(t *testing.T) {
+	t.Run("R4 backport", func(t *testing.T) {
+		notification, err := fhirclient.ParseSubscriptionNotification([]byte(backportNotification(fhirclient.NotificationTypeEventNotification, 2, 1, 2)))
+
+		require.NoError(t, err)
+		assert.Equal(t, fhirclient.NotificationTypeEventNotification, notification.Type)
+		assert.Equal(t, "http://example.com/fhir/Subscription/1", notification.Subscription)
+		assert.Equal(t, "http://example.com/topic", notification.Topic)
+		assert.Equal(t, "active", notification.Status)
+		assert.Equal(t, int64(2), notification.EventsSinceSubscriptionStart)
+		require.Len(t, notification.Events, 2)
+		event := notification.Events[1]
+		assert.Equal(t, int64(2), event.EventNumber)
+		assert.Equal(t, notification.Subscription, event.Subscription)
+		assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), *event.Timestamp)
+		assert.Equal(t, "Task/2", *event.Focus.Reference)
+		var task fhir.Task
+		require.NoError(t, event.Unmarshal(&task))
+		assert.Equal(t, "2", *task.ID)
+	})
+	t.Run("R5", func(t *testing.T) {
+		notification, err := fhirclient.ParseSubscriptionNotification([]byte(`{"resourceType":"Bundle","type":"subscription-notification","entry":[{"resource":{
+			"resourceType":"SubscriptionStatus","status":"active","type":"event-notification","eventsSinceSubscriptionStart":"3",
+			"subscription":{"reference":"Subscription/1"},"topic":"http://example.com/topic",
+			"notificationEvent":[{"eventNumber":"3","focus":{"reference":"Task/3"},"additionalContext":[{"reference":"Patient/1"}]}]}}]}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), notification.EventsSinceSubscriptionStart)
+		require.Len(t, notification.Events, 1)
+		assert.Equal(t, int64(3), notification.Events[0].EventNumber)
+		assert.Equal(t, "Subscription/1", notification.Events[0].Subscription)
+		assert.Equal(t, "Patient/1", *notification.Events[0].AdditionalContext[0].Reference)
+		assert.Nil(t, notification.Events[0].Resource)
+		assert.EqualError(t, notification.Events[0].Unmarshal(&fhir.Task{}), "subscription event 3 doesn't contain the focus resource")
+	})
+	t.Run("heartbeat", func(t *testing.T) {
+		notification, err := fhirclient.ParseSubscriptionNotification([]byte(backportNotification(fhirclient.NotificationTypeHeartbeat, 5)))
+
+		require.NoError(t, err)
+		assert.Equal(t, fhirclient.NotificationTypeHeartbeat, notification.Type)
+		assert.Empty(t, notification.Events)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		testCases := []struct {
+			name          string
+			data          string
+			expectedError string
+		}{
+			{"Bundle type", `{"resourceType":"Bundle","type":"searchset"}`, "invalid subscription notification: unexpected Bundle type: searchset"},
+			{"no entries", `{"resourceType":"Bundle","type":"history"}`, "invalid subscription notification: first entry must contain the subscription status"},
+			{"status resource", `{"resourceType":"Bundle","type":"history","entry":[{"resource":{"resourceType":"Patient"}}]}`, "invalid subscription notification: unexpected status resource: Patient"},
+			{"type", strings.ReplaceAll(backportNotification(fhirclient.NotificationTypeHeartbeat, 0), "heartbeat", "foo"), "invalid subscription notification: unknown type: foo"},
+			{"event notification without events", backportNotification(fhirclient.NotificationTypeEventNotification, 0), "invalid subscription notification: event notification without events"},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				_, err := fhirclient.ParseSubscriptionNotification([]byte(testCase.data))
+
+				assert.EqualError(t, err, testCase.expectedError)
+			})
+		}
+	})
+}
+
+func TestNotificationHandler(t *testing.T) {
+	const subscription = "http://example.com/fhir/Subscription/1"
+	notify := func(handler http.Handler, data string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(data)))
+		return recorder
+	}
+	eventsServer := func(requests *[]string) fhirclient.Client {
+		return fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			*requests = append(*requests, r.URL.RequestURI())
+			var numbers []int
+			var since, until int
+			_, _ = fmt.Sscan(r.URL.Query().Get("eventsSinceNumber"), &since)
+			_, _ = fmt.Sscan(r.URL.Query().Get("eventsUntilNumber"), &until)
+			for number := since; number <= until; number++ {
+				numbers = append(numbers, number)
+			}
+			w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+			_, _ = w.Write([]byte(backportNotification(fhirclient.NotificationTypeQueryEvent, until, numbers...)))
+		}), nil)
+	}
+	t.Run("dispatches events in order", func(t *testing.T) {
+		var handshakes []string
+		var received []int64
+		handler := fhirclient.NewNotificationHandler(nil, func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			received = append(received, event.EventNumber)
+			return nil
+		}, fhirclient.OnHandshake(func(ctx context.Context, notification fhirclient.SubscriptionNotification) {
+			handshakes = append(handshakes, notification.Subscription)
+		}))
+
+		assert.Equal(t, http.StatusOK, notify(handler, backportNotification(fhirclient.NotificationTypeHandshake, 0)).Code)
+		assert.Equal(t, http.StatusOK, notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 2, 1, 2)).Code)
+		assert.Equal(t, http.StatusOK, notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 3, 3)).Code)
+
+		assert.Equal(t, []string{subscription}, handshakes)
+		assert.Equal(t, []int64{1, 2, 3}, received)
+		lastEventNumber, ok := handler.LastEventNumber(subscription)
+		assert.True(t, ok)
+		assert.Equal(t, int64(3), lastEventNumber)
+	})
+	t.Run("skips duplicate events", func(t *testing.T) {
+		var received []int64
+		handler := fhirclient.NewNotificationHandler(nil, func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			received = append(received, event.EventNumber)
+			return nil
+		})
+
+		notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 1, 1))
+		notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 2, 1, 2))
+
+		assert.Equal(t, []int64{1, 2}, received)
+	})
+	t.Run("retrieves missed events", func(t *testing.T) {
+		var requests []string
+		var received []int64
+		handler := fhirclient.NewNotificationHandler(eventsServer(&requests), func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			received = append(received, event.EventNumber)
+			return nil
+		})
+		handler.Track(subscription, 1)
+
+		response := notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 5, 5))
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, []string{"/fhir/Subscription/1/$events?eventsSinceNumber=2&eventsUntilNumber=4"}, requests)
+		assert.Equal(t, []int64{2, 3, 4, 5}, received)
+	})
+	t.Run("heartbeat reveals missed events", func(t *testing.T) {
+		var requests []string
+		var received []int64
+		var heartbeats int
+		handler := fhirclient.NewNotificationHandler(eventsServer(&requests), func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			received = append(received, event.EventNumber)
+			return nil
+		}, fhirclient.OnHeartbeat(func(ctx context.Context, notification fhirclient.SubscriptionNotification) {
+			heartbeats++
+		}))
+
+		notify(handler, backportNotification(fhirclient.NotificationTypeHeartbeat, 2))
+		notify(handler, backportNotification(fhirclient.NotificationTypeHeartbeat, 2))
+		notify(handler, backportNotification(fhirclient.NotificationTypeHeartbeat, 3))
+
+		assert.Equal(t, 3, heartbeats)
+		assert.Equal(t, []string{"/fhir/Subscription/1/$events?eventsSinceNumber=3&eventsUntilNumber=3"}, requests)
+		assert.Equal(t, []int64{3}, received)
+	})
+	t.Run("retrieving missed events fails", func(t *testing.T) {
+		client := fhirclient.New(baseURL, handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}), nil)
+		var received []int64
+		handler := fhirclient.NewNotificationHandler(client, func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			received = append(received, event.EventNumber)
+			return nil
+		})
+		handler.Track(subscription, 1)
+
+		response := notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 3, 3))
+
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Contains(t, response.Body.String(), "failed to retrieve missed events 2-2 of subscription "+subscription)
+		assert.Empty(t, received)
+		lastEventNumber, _ := handler.LastEventNumber(subscription)
+		assert.Equal(t, int64(1), lastEventNumber)
+	})
+	t.Run("callback fails", func(t *testing.T) {
+		var received []int64
+		handler := fhirclient.NewNotificationHandler(nil, func(ctx context.Context, event fhirclient.SubscriptionEvent) error {
+			if event.EventNumber == 2 {
+				return errors.New("database unavailable")
+			}
+			received = append(received, event.EventNumber)
+			return nil
+		})
+
+		response := notify(handler, backportNotification(fhirclient.NotificationTypeEventNotification, 3, 1, 2, 3))
+
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Contains(t, response.Body.String(), "subscription event 2: database unavailable")
+		assert.Equal(t, []int64{1}, received)
+		lastEventNumber, _ := handler.LastEventNumber(subscription)
+		assert.Equal(t, int64(1), lastEventNumber)
+	})
+	t.Run("invalid notification", func(t *testing.T) {
+		handler := fhirclient.NewNotificationHandler(nil, nil)
+
+		response := notify(handler, `{"resourceType":"Bundle","type":"searchset"}`)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("method not allowed", func(t *testing.T) {
+		handler := fhirclient.NewNotificationHandler(nil, nil)
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/notify", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
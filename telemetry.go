@@ -0,0 +1,319 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/SanteonNL/go-fhir-client"
+
+// Attributes recorded on spans and metrics, in addition to the OpenTelemetry HTTP semantic conventions.
+const (
+	// InteractionKey is the FHIR RESTful interaction (e.g. read, search-type, create), see http://hl7.org/fhir/ValueSet/type-restful-interaction
+	InteractionKey = attribute.Key("fhir.interaction")
+	// ResourceTypeKey is the FHIR resource type the request targets.
+	ResourceTypeKey = attribute.Key("fhir.resource.type")
+	// ResourceIDKey is the ID of the FHIR resource the request targets.
+	ResourceIDKey = attribute.Key("fhir.resource.id")
+	// OperationOutcomeCodesKey contains the issue codes of the OperationOutcome returned by the server.
+	OperationOutcomeCodesKey = attribute.Key("fhir.operation_outcome.codes")
+)
+
+// telemetry creates spans and records metrics for the requests of the client.
+// Spans don't contain the query of the request URL, since it might contain personal information.
+type telemetry struct {
+	tracer       trace.Tracer
+	propagator   propagation.TextMapPropagator
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+func newTelemetry(config Config) *telemetry {
+	tracerProvider := config.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	meterProvider := config.MeterProvider
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	result := &telemetry{
+		tracer:     tracerProvider.Tracer(instrumentationName),
+		propagator: config.Propagator,
+	}
+	if result.propagator == nil {
+		result.propagator = otel.GetTextMapPropagator()
+	}
+	meter := meterProvider.Meter(instrumentationName)
+	// Instrument creation only fails on invalid names, in which case the returned no-op instrument is used.
+	result.duration, _ = meter.Float64Histogram("fhir.client.request.duration",
+		metric.WithDescription("Duration of FHIR requests."), metric.WithUnit("s"))
+	result.requestSize, _ = meter.Int64Histogram("fhir.client.request.body.size",
+		metric.WithDescription("Size of FHIR request bodies."), metric.WithUnit("By"))
+	result.responseSize, _ = meter.Int64Histogram("fhir.client.response.body.size",
+		metric.WithDescription("Size of FHIR response bodies."), metric.WithUnit("By"))
+	return result
+}
+
+// requestRecord collects information about a request for tracing, metrics and logging.
+// The response fields are set by doRequest once they're known.
+type requestRecord struct {
+	request      *http.Request
+	start        time.Time
+	interaction  string
+	resourceType string
+	resourceID   string
+	requestBody  *bodyRecorder
+	attempts     int
+	statusCode   int
+	response     []byte
+	responseBody *bodyRecorder
+	span         trace.Span
+	attributes   []attribute.KeyValue
+}
+
+// responseSize returns the size of the response body. For streamed responses (responseBody), it's the number of bytes read by the caller.
+func (r *requestRecord) responseSize() int64 {
+	if r.responseBody != nil {
+		return r.responseBody.size()
+	}
+	return int64(len(r.response))
+}
+
+// startRequest starts tracking the request. endRequest must be called when the request completes.
+func (d BaseClient) startRequest(httpRequest *http.Request) *requestRecord {
+	record := &requestRecord{
+		request: httpRequest,
+		start:   time.Now(),
+	}
+	record.interaction, record.resourceType, record.resourceID = describeInteraction(httpRequest.Method, strings.TrimPrefix(httpRequest.URL.Path, d.baseURL.Path))
+	if httpRequest.Body != nil {
+		record.requestBody = &bodyRecorder{ReadCloser: httpRequest.Body, capture: d.config.Logging != nil && d.config.Logging.LogBodies}
+		httpRequest.Body = record.requestBody
+	}
+	d.telemetry.start(record)
+	return record
+}
+
+// endRequest ends the span, records the metrics and logs the request.
+func (d BaseClient) endRequest(record *requestRecord, err error) {
+	d.telemetry.end(record, err)
+	d.logRequest(record, err)
+}
+
+// start starts a span for the request and injects the trace context into the request headers.
+// A nil telemetry (client not created using New) does nothing.
+func (t *telemetry) start(record *requestRecord) {
+	if t == nil {
+		return
+	}
+	httpRequest := record.request
+	record.attributes = []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(httpRequest.Method),
+		semconv.ServerAddress(httpRequest.URL.Hostname()),
+	}
+	if record.interaction != "" {
+		record.attributes = append(record.attributes, InteractionKey.String(record.interaction))
+	}
+	if record.resourceType != "" {
+		record.attributes = append(record.attributes, ResourceTypeKey.String(record.resourceType))
+	}
+	spanName := strings.TrimSpace("FHIR " + record.interaction + " " + record.resourceType)
+	spanAttributes := append([]attribute.KeyValue{semconv.URLPath(httpRequest.URL.Path)}, record.attributes...)
+	if record.resourceID != "" {
+		spanAttributes = append(spanAttributes, ResourceIDKey.String(record.resourceID))
+	}
+	ctx, span := t.tracer.Start(httpRequest.Context(), spanName,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(spanAttributes...))
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(httpRequest.Header))
+	*httpRequest = *httpRequest.WithContext(ctx)
+	record.span = span
+}
+
+// end ends the span and records the metrics of the request.
+func (t *telemetry) end(record *requestRecord, err error) {
+	if t == nil {
+		return
+	}
+	attributes := record.attributes
+	if record.statusCode != 0 {
+		attributes = append(attributes, semconv.HTTPResponseStatusCode(record.statusCode))
+	}
+	if err != nil {
+		errorType := fmt.Sprintf("%T", err)
+		if record.statusCode >= 400 {
+			errorType = fmt.Sprintf("%d", record.statusCode)
+		}
+		attributes = append(attributes, semconv.ErrorTypeKey.String(errorType))
+	}
+	spanAttributes := attributes[len(record.attributes):]
+	var outcome OperationOutcomeError
+	if errors.As(err, &outcome) {
+		var issueCodes []string
+		for _, issue := range outcome.Issue {
+			issueCodes = append(issueCodes, issue.Code.Code())
+		}
+		spanAttributes = append(spanAttributes, OperationOutcomeCodesKey.StringSlice(issueCodes))
+	}
+	record.span.SetAttributes(spanAttributes...)
+	if err != nil {
+		// Strip the query from the error message, since it might contain personal information
+		withoutQuery := *record.request.URL
+		withoutQuery.RawQuery = ""
+		message := strings.ReplaceAll(err.Error(), record.request.URL.String(), withoutQuery.String())
+		record.span.RecordError(errors.New(message))
+		record.span.SetStatus(codes.Error, message)
+	}
+	record.span.End()
+
+	ctx := record.request.Context()
+	recordOpts := metric.WithAttributes(attributes...)
+	t.duration.Record(ctx, time.Since(record.start).Seconds(), recordOpts)
+	if record.requestBody != nil {
+		t.requestSize.Record(ctx, record.requestBody.size(), recordOpts)
+	}
+	if record.statusCode != 0 {
+		t.responseSize.Record(ctx, record.responseSize(), recordOpts)
+	}
+}
+
+// describeInteraction derives the FHIR RESTful interaction, resource type and resource ID from the method and path (relative to the base URL) of a request.
+// Unknown interactions are returned as empty string.
+func describeInteraction(method string, path string) (interaction string, resourceType string, resourceID string) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	// Operations, e.g. $export, Patient/$match or Patient/1/$everything
+	if len(segments) > 0 && strings.HasPrefix(segments[len(segments)-1], "$") {
+		if len(segments) > 1 {
+			resourceType = segments[0]
+		}
+		if len(segments) > 2 {
+			resourceID = segments[1]
+		}
+		return "operation", resourceType, resourceID
+	}
+	switch {
+	case len(segments) == 0:
+		switch method {
+		case http.MethodGet:
+			return "search-system", "", ""
+		case http.MethodPost:
+			return "transaction", "", ""
+		case http.MethodDelete:
+			return "delete", "", ""
+		}
+	case segments[0] == "metadata":
+		return "capabilities", "", ""
+	case segments[0] == "_history":
+		return "history-system", "", ""
+	case segments[0] == "_search":
+		return "search-system", "", ""
+	case len(segments) == 1:
+		resourceType = segments[0]
+		switch method {
+		case http.MethodGet:
+			return "search-type", resourceType, ""
+		case http.MethodPost:
+			return "create", resourceType, ""
+		case http.MethodPut:
+			return "update", resourceType, ""
+		case http.MethodPatch:
+			return "patch", resourceType, ""
+		case http.MethodDelete:
+			return "delete", resourceType, ""
+		}
+		return "", resourceType, ""
+	case len(segments) == 2 && segments[1] == "_search":
+		return "search-type", segments[0], ""
+	case len(segments) == 2 && segments[1] == "_history":
+		return "history-type", segments[0], ""
+	case len(segments) == 2:
+		resourceType, resourceID = segments[0], segments[1]
+		switch method {
+		case http.MethodGet:
+			return "read", resourceType, resourceID
+		case http.MethodPut:
+			return "update", resourceType, resourceID
+		case http.MethodPatch:
+			return "patch", resourceType, resourceID
+		case http.MethodDelete:
+			return "delete", resourceType, resourceID
+		}
+		return "", resourceType, resourceID
+	case segments[2] == "_history":
+		if len(segments) == 4 {
+			return "vread", segments[0], segments[1]
+		}
+		return "history-instance", segments[0], segments[1]
+	case len(segments) == 3 && method == http.MethodGet:
+		// Compartment search, e.g. Patient/1/Observation
+		return "search-compartment", segments[2], ""
+	}
+	return "", "", ""
+}
+
+// bodyRecorder counts the bytes read from a request body, and optionally captures them for logging.
+// The HTTP transport might read it from another goroutine.
+type bodyRecorder struct {
+	io.ReadCloser
+	capture bool
+	mux     sync.Mutex
+	count   int64
+	data    []byte
+}
+
+func (b *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.count += int64(n)
+	if b.capture {
+		b.data = append(b.data, p[:n]...)
+	}
+	return n, err
+}
+
+func (b *bodyRecorder) size() int64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.count
+}
+
+func (b *bodyRecorder) captured() []byte {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.data
+}
@@ -0,0 +1,169 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTelemetry(t *testing.T) {
+	var traceParents []string
+	handler := handlerDoer(func(w http.ResponseWriter, r *http.Request) {
+		traceParents = append(traceParents, r.Header.Get("traceparent"))
+		if r.Body != nil {
+			_, _ = io.ReadAll(r.Body)
+		}
+		w.Header().Set("Content-Type", fhirclient.FhirJsonMediaType)
+		if r.URL.Path == "/fhir/Patient/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"not-found"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"resourceType":"Patient","id":"1"}`))
+	})
+	setup := func() (fhirclient.Client, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+		traceParents = nil
+		exporter := tracetest.NewInMemoryExporter()
+		reader := sdkmetric.NewManualReader()
+		client := fhirclient.New(baseURL, handler, &fhirclient.Config{
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+			MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+			Propagator:     propagation.TraceContext{},
+		})
+		return client, exporter, reader
+	}
+	spanAttributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		result := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			result[kv.Key] = kv.Value
+		}
+		return result
+	}
+
+	t.Run("span for read", func(t *testing.T) {
+		client, exporter, _ := setup()
+
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, "FHIR read Patient", span.Name)
+		attributes := spanAttributes(span)
+		assert.Equal(t, "read", attributes[fhirclient.InteractionKey].AsString())
+		assert.Equal(t, "Patient", attributes[fhirclient.ResourceTypeKey].AsString())
+		assert.Equal(t, "1", attributes[fhirclient.ResourceIDKey].AsString())
+		assert.Equal(t, int64(http.StatusOK), attributes["http.response.status_code"].AsInt64())
+		assert.Equal(t, "example.com", attributes["server.address"].AsString())
+		assert.Equal(t, codes.Unset, span.Status.Code)
+	})
+	t.Run("trace context is propagated", func(t *testing.T) {
+		client, exporter, _ := setup()
+
+		require.NoError(t, client.Read("Patient/1", new(fhir.Patient)))
+
+		require.Len(t, traceParents, 1)
+		spanContext := exporter.GetSpans()[0].SpanContext
+		assert.Equal(t, "00-"+spanContext.TraceID().String()+"-"+spanContext.SpanID().String()+"-01", traceParents[0])
+	})
+	t.Run("OperationOutcome codes", func(t *testing.T) {
+		client, exporter, _ := setup()
+
+		err := client.Read("Patient/missing", new(fhir.Patient))
+
+		require.Error(t, err)
+		span := exporter.GetSpans()[0]
+		assert.Equal(t, codes.Error, span.Status.Code)
+		attributes := spanAttributes(span)
+		assert.Equal(t, []string{"not-found"}, attributes[fhirclient.OperationOutcomeCodesKey].AsStringSlice())
+		assert.Equal(t, "404", attributes["error.type"].AsString())
+	})
+	t.Run("query is not recorded", func(t *testing.T) {
+		client, exporter, _ := setup()
+
+		require.NoError(t, client.Search("Patient", url.Values{"name": {"Doe"}}, new(fhir.Bundle)))
+
+		span := exporter.GetSpans()[0]
+		assert.Equal(t, "FHIR search-type Patient", span.Name)
+		for _, kv := range span.Attributes {
+			assert.NotContains(t, kv.Value.Emit(), "Doe")
+		}
+	})
+	t.Run("interactions", func(t *testing.T) {
+		client, exporter, _ := setup()
+		ctx := context.Background()
+
+		require.NoError(t, client.CreateWithContext(ctx, fhir.Patient{}, nil))
+		require.NoError(t, client.UpdateWithContext(ctx, "Patient/1", fhir.Patient{}, nil))
+		require.NoError(t, client.DeleteWithContext(ctx, "Patient/1"))
+		require.NoError(t, client.ReadWithContext(ctx, "Patient/1/_history/2", new(fhir.Patient)))
+		require.NoError(t, client.ReadWithContext(ctx, "Patient/1/$everything", new(fhir.Bundle)))
+		require.NoError(t, client.ReadWithContext(ctx, "metadata", new(fhir.Bundle)))
+
+		var names []string
+		for _, span := range exporter.GetSpans() {
+			names = append(names, span.Name)
+		}
+		assert.Equal(t, []string{
+			"FHIR create Patient",
+			"FHIR update Patient",
+			"FHIR delete Patient",
+			"FHIR vread Patient",
+			"FHIR operation Patient",
+			"FHIR capabilities",
+		}, names)
+	})
+	t.Run("metrics", func(t *testing.T) {
+		client, _, reader := setup()
+
+		require.NoError(t, client.Create(fhir.Patient{}, nil))
+
+		var metrics metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(context.Background(), &metrics))
+		require.Len(t, metrics.ScopeMetrics, 1)
+		histograms := map[string]metricdata.HistogramDataPoint[int64]{}
+		var duration metricdata.HistogramDataPoint[float64]
+		for _, m := range metrics.ScopeMetrics[0].Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[int64]:
+				histograms[m.Name] = data.DataPoints[0]
+			case metricdata.Histogram[float64]:
+				duration = data.DataPoints[0]
+			}
+		}
+		assert.Equal(t, uint64(1), duration.Count)
+		interaction, _ := duration.Attributes.Value(fhirclient.InteractionKey)
+		assert.Equal(t, "create", interaction.AsString())
+		assert.Equal(t, int64(len(`{"resourceType":"Patient"}`)), histograms["fhir.client.request.body.size"].Sum)
+		assert.Equal(t, int64(len(`{"resourceType":"Patient","id":"1"}`)), histograms["fhir.client.response.body.size"].Sum)
+	})
+}
@@ -0,0 +1,257 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// TransactionBuilder builds a FHIR Bundle of type transaction or batch.
+// Entries are added using the Create, Update and Delete (and their conditional variants) functions,
+// which return the added entry so that its fullUrl can be used to reference it from other entries,
+// and the result of the entry can be unmarshaled into a target after the Bundle has been executed.
+type TransactionBuilder struct {
+	bundleType fhir.BundleType
+	entries    []*TransactionEntry
+	err        error
+}
+
+// NewTransaction creates a builder for a Bundle of type transaction.
+func NewTransaction() *TransactionBuilder {
+	return &TransactionBuilder{bundleType: fhir.BundleTypeTransaction}
+}
+
+// NewBatch creates a builder for a Bundle of type batch.
+func NewBatch() *TransactionBuilder {
+	return &TransactionBuilder{bundleType: fhir.BundleTypeBatch}
+}
+
+// Create adds an entry that creates the given resource. The path is derived from the resource's resourceType.
+func (b *TransactionBuilder) Create(resource any) *TransactionEntry {
+	return b.addCreate(resource, nil)
+}
+
+// ConditionalCreate adds an entry that creates the given resource, if no resource matches the given search criteria (If-None-Exist).
+func (b *TransactionBuilder) ConditionalCreate(resource any, ifNoneExist url.Values) *TransactionEntry {
+	return b.addCreate(resource, ifNoneExist)
+}
+
+func (b *TransactionBuilder) addCreate(resource any, ifNoneExist url.Values) *TransactionEntry {
+	entry := &TransactionEntry{
+		FullUrl: "urn:uuid:" + newUUID(),
+		Request: fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbPOST,
+		},
+	}
+	desc, err := DescribeResource(resource)
+	if err != nil {
+		b.fail(err)
+	} else {
+		entry.Request.Url = desc.Type
+		entry.resource = desc.Data
+	}
+	if len(ifNoneExist) > 0 {
+		query := ifNoneExist.Encode()
+		entry.Request.IfNoneExist = &query
+	}
+	b.entries = append(b.entries, entry)
+	return entry
+}
+
+// Update adds an entry that updates the resource at the given path (e.g. Patient/123) with the given resource.
+// The entry's fullUrl is the resource's absolute URL, which is set when the transaction is executed.
+func (b *TransactionBuilder) Update(path string, resource any) *TransactionEntry {
+	return b.addUpdate(path, resource)
+}
+
+// ConditionalUpdate adds an entry that updates the resource of the given type that matches the given search criteria.
+func (b *TransactionBuilder) ConditionalUpdate(resourceType string, criteria url.Values, resource any) *TransactionEntry {
+	return b.addUpdate(resourceType+"?"+criteria.Encode(), resource)
+}
+
+func (b *TransactionBuilder) addUpdate(path string, resource any) *TransactionEntry {
+	entry := &TransactionEntry{
+		Request: fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbPUT,
+			Url:    path,
+		},
+	}
+	if strings.Contains(path, "?") {
+		// The identity of a conditionally updated resource isn't known in advance
+		entry.FullUrl = "urn:uuid:" + newUUID()
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		b.fail(fmt.Errorf("invalid resource of type %T: %w", resource, err))
+	}
+	entry.resource = data
+	b.entries = append(b.entries, entry)
+	return entry
+}
+
+// Delete adds an entry that deletes the resource at the given path (e.g. Patient/123).
+func (b *TransactionBuilder) Delete(path string) *TransactionEntry {
+	entry := &TransactionEntry{
+		Request: fhir.BundleEntryRequest{
+			Method: fhir.HTTPVerbDELETE,
+			Url:    path,
+		},
+	}
+	b.entries = append(b.entries, entry)
+	return entry
+}
+
+// ConditionalDelete adds an entry that deletes the resource(s) of the given type that match the given search criteria.
+func (b *TransactionBuilder) ConditionalDelete(resourceType string, criteria url.Values) *TransactionEntry {
+	return b.Delete(resourceType + "?" + criteria.Encode())
+}
+
+// Entries returns the entries that were added to the builder, in order.
+func (b *TransactionBuilder) Entries() []*TransactionEntry {
+	return b.entries
+}
+
+// Bundle returns the FHIR Bundle that is sent to the FHIR server.
+// It returns an error if any of the entries could not be added (e.g. because the resource could not be marshaled).
+func (b *TransactionBuilder) Bundle() (*fhir.Bundle, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	result := &fhir.Bundle{
+		Type: b.bundleType,
+	}
+	for _, entry := range b.entries {
+		bundleEntry := fhir.BundleEntry{
+			Resource: entry.resource,
+			Request:  &entry.Request,
+		}
+		if entry.FullUrl != "" {
+			fullUrl := entry.FullUrl
+			bundleEntry.FullUrl = &fullUrl
+		}
+		result.Entry = append(result.Entry, bundleEntry)
+	}
+	return result, nil
+}
+
+// mapResponse maps the entries of the transaction/batch response Bundle back to the entries of the builder.
+// The FHIR specification requires the response entries to be in the same order as the request entries.
+func (b *TransactionBuilder) mapResponse(response fhir.Bundle) error {
+	if len(response.Entry) != len(b.entries) {
+		return fmt.Errorf("FHIR %s response contains %d entries, expected %d", b.bundleType.Code(), len(response.Entry), len(b.entries))
+	}
+	for i, responseEntry := range response.Entry {
+		entry := b.entries[i]
+		entry.Response = responseEntry.Response
+		entry.responseResource = responseEntry.Resource
+		if entry.result == nil || len(responseEntry.Resource) == 0 || entry.Err() != nil {
+			continue
+		}
+		if err := json.Unmarshal(responseEntry.Resource, entry.result); err != nil {
+			return fmt.Errorf("FHIR %s response entry %d unmarshal failed: %w", b.bundleType.Code(), i, err)
+		}
+	}
+	return nil
+}
+
+func (b *TransactionBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = fmt.Errorf("transaction entry %d: %w", len(b.entries), err)
+	}
+}
+
+// TransactionEntry is an entry of a transaction or batch Bundle.
+type TransactionEntry struct {
+	// FullUrl is the fullUrl of the entry. For creates and conditional updates, it is a urn:uuid
+	// which can be used to reference the resource from other entries in the same transaction.
+	// For updates of Type/id it's empty, since the resource's absolute URL is used when the transaction is executed.
+	FullUrl string
+	// Request contains the request details of the entry.
+	Request fhir.BundleEntryRequest
+	// Response contains the response details (status, location, etag, outcome) of the entry.
+	// It is populated after the transaction or batch has been executed.
+	Response *fhir.BundleEntryResponse
+
+	resource         json.RawMessage
+	responseResource json.RawMessage
+	result           any
+}
+
+// Into sets the target into which the resource returned for this entry is unmarshaled, after the transaction or batch has been executed.
+func (e *TransactionEntry) Into(result any) *TransactionEntry {
+	e.result = result
+	return e
+}
+
+// WithFullUrl overrides the fullUrl of the entry.
+func (e *TransactionEntry) WithFullUrl(fullUrl string) *TransactionEntry {
+	e.FullUrl = fullUrl
+	return e
+}
+
+// StatusCode returns the HTTP status code of the entry's response, or 0 if there is no (valid) response.
+func (e *TransactionEntry) StatusCode() int {
+	if e.Response == nil {
+		return 0
+	}
+	return parseEntryStatus(e.Response.Status)
+}
+
+// parseEntryStatus parses the status code of a Bundle entry response, or returns 0 if it's invalid.
+func parseEntryStatus(status string) int {
+	// Status is formatted as the HTTP status code, optionally followed by the reason phrase (e.g. "201 Created").
+	code, _, _ := strings.Cut(strings.TrimSpace(status), " ")
+	result, _ := strconv.Atoi(code)
+	return result
+}
+
+// Err returns an error if the entry wasn't executed or failed.
+// If the response contains an OperationOutcome, it is returned as OperationOutcomeError.
+func (e *TransactionEntry) Err() error {
+	if e.Response == nil {
+		return errors.New("FHIR transaction entry has no response")
+	}
+	statusCode := e.StatusCode()
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	if err := checkForOperationOutcomeError(e.Response.Outcome, true, statusCode); err != nil {
+		return err
+	}
+	return fmt.Errorf("FHIR transaction entry failed (%s %s, status=%s)", e.Request.Method.Code(), e.Request.Url, e.Response.Status)
+}
+
+// Resource returns the raw resource returned for this entry, if any.
+func (e *TransactionEntry) Resource() json.RawMessage {
+	return e.responseResource
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,235 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestTransactionBuilder_Bundle(t *testing.T) {
+	t.Run("transaction", func(t *testing.T) {
+		tx := fhirclient.NewTransaction()
+		created := tx.Create(Resource{Id: "1"})
+		tx.ConditionalCreate(Resource{}, url.Values{"identifier": {"sys|123"}})
+		tx.Update("Resource/2", Resource{Id: "2"})
+		tx.ConditionalUpdate("Resource", url.Values{"identifier": {"sys|456"}}, Resource{})
+		tx.Delete("Resource/3")
+		tx.ConditionalDelete("Resource", url.Values{"identifier": {"sys|789"}})
+
+		bundle, err := tx.Bundle()
+
+		require.NoError(t, err)
+		assert.Equal(t, fhir.BundleTypeTransaction, bundle.Type)
+		require.Len(t, bundle.Entry, 6)
+		t.Run("create", func(t *testing.T) {
+			entry := bundle.Entry[0]
+			assert.True(t, strings.HasPrefix(*entry.FullUrl, "urn:uuid:"))
+			assert.Equal(t, created.FullUrl, *entry.FullUrl)
+			assert.Equal(t, fhir.HTTPVerbPOST, entry.Request.Method)
+			assert.Equal(t, "Resource", entry.Request.Url)
+			assert.JSONEq(t, `{"id":"1","resourceType":"Resource"}`, string(entry.Resource))
+		})
+		t.Run("conditional create", func(t *testing.T) {
+			entry := bundle.Entry[1]
+			assert.Equal(t, "identifier=sys%7C123", *entry.Request.IfNoneExist)
+		})
+		t.Run("update", func(t *testing.T) {
+			entry := bundle.Entry[2]
+			assert.Nil(t, entry.FullUrl, "set to the absolute URL when executed")
+			assert.Equal(t, fhir.HTTPVerbPUT, entry.Request.Method)
+			assert.Equal(t, "Resource/2", entry.Request.Url)
+		})
+		t.Run("conditional update", func(t *testing.T) {
+			entry := bundle.Entry[3]
+			assert.True(t, strings.HasPrefix(*entry.FullUrl, "urn:uuid:"))
+			assert.Equal(t, fhir.HTTPVerbPUT, entry.Request.Method)
+			assert.Equal(t, "Resource?identifier=sys%7C456", entry.Request.Url)
+		})
+		t.Run("delete", func(t *testing.T) {
+			entry := bundle.Entry[4]
+			assert.Nil(t, entry.FullUrl)
+			assert.Nil(t, entry.Resource)
+			assert.Equal(t, fhir.HTTPVerbDELETE, entry.Request.Method)
+			assert.Equal(t, "Resource/3", entry.Request.Url)
+		})
+		t.Run("conditional delete", func(t *testing.T) {
+			entry := bundle.Entry[5]
+			assert.Equal(t, "Resource?identifier=sys%7C789", entry.Request.Url)
+		})
+	})
+	t.Run("batch", func(t *testing.T) {
+		bundle, err := fhirclient.NewBatch().Bundle()
+
+		require.NoError(t, err)
+		assert.Equal(t, fhir.BundleTypeBatch, bundle.Type)
+	})
+	t.Run("with full URL", func(t *testing.T) {
+		tx := fhirclient.NewTransaction()
+		tx.Create(Resource{}).WithFullUrl("urn:uuid:custom")
+
+		bundle, err := tx.Bundle()
+
+		require.NoError(t, err)
+		assert.Equal(t, "urn:uuid:custom", *bundle.Entry[0].FullUrl)
+	})
+	t.Run("invalid resource", func(t *testing.T) {
+		tx := fhirclient.NewTransaction()
+		tx.Create(Resource{})
+		tx.Create(map[string]interface{}{"key": "value"})
+
+		_, err := tx.Bundle()
+
+		require.EqualError(t, err, "transaction entry 1: resourceType not present in resource of type map[string]interface {}")
+	})
+}
+
+func TestBaseClient_TransactionWithContext(t *testing.T) {
+	t.Run("update entry has absolute fullUrl", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(fhir.Bundle{
+				Type:  fhir.BundleTypeTransactionResponse,
+				Entry: []fhir.BundleEntry{{Response: &fhir.BundleEntryResponse{Status: "200 OK"}}},
+			}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		tx := fhirclient.NewTransaction()
+		tx.Update("Resource/2", Resource{Id: "2"})
+
+		err := client.TransactionWithContext(context.Background(), tx)
+
+		require.NoError(t, err)
+		requestData, _ := io.ReadAll(stub.request.Body)
+		var requestBundle fhir.Bundle
+		require.NoError(t, json.Unmarshal(requestData, &requestBundle))
+		assert.Equal(t, "http://example.com/fhir/Resource/2", *requestBundle.Entry[0].FullUrl)
+	})
+	t.Run("ok", func(t *testing.T) {
+		createdID := "123"
+		stub := &requestResponder{
+			response: okResponse(fhir.Bundle{
+				Type: fhir.BundleTypeTransactionResponse,
+				Entry: []fhir.BundleEntry{
+					{
+						Resource: json.RawMessage(`{"resourceType":"Resource","id":"123"}`),
+						Response: &fhir.BundleEntryResponse{
+							Status:   "201 Created",
+							Location: ptr("Resource/123/_history/1"),
+							Etag:     ptr(`W/"1"`),
+						},
+					},
+					{
+						Response: &fhir.BundleEntryResponse{
+							Status: "204",
+						},
+					},
+				},
+			}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		tx := fhirclient.NewTransaction()
+		var created Resource
+		createEntry := tx.Create(Resource{}).Into(&created)
+		deleteEntry := tx.Delete("Resource/456")
+
+		err := client.TransactionWithContext(context.Background(), tx)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodPost, stub.request.Method)
+		assert.Equal(t, "http://example.com/fhir", stub.request.URL.String())
+		assert.Equal(t, fhirclient.FhirJsonMediaType, stub.request.Header.Get("Content-Type"))
+		requestData, _ := io.ReadAll(stub.request.Body)
+		var requestBundle fhir.Bundle
+		require.NoError(t, json.Unmarshal(requestData, &requestBundle))
+		assert.Equal(t, fhir.BundleTypeTransaction, requestBundle.Type)
+		assert.Len(t, requestBundle.Entry, 2)
+		t.Run("create entry", func(t *testing.T) {
+			assert.Equal(t, createdID, created.Id)
+			assert.Equal(t, 201, createEntry.StatusCode())
+			assert.Equal(t, "Resource/123/_history/1", *createEntry.Response.Location)
+			assert.Equal(t, `W/"1"`, *createEntry.Response.Etag)
+			assert.NoError(t, createEntry.Err())
+		})
+		t.Run("delete entry", func(t *testing.T) {
+			assert.Equal(t, 204, deleteEntry.StatusCode())
+			assert.NoError(t, deleteEntry.Err())
+		})
+	})
+	t.Run("batch with failed entry", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(fhir.Bundle{
+				Type: fhir.BundleTypeBatchResponse,
+				Entry: []fhir.BundleEntry{
+					{
+						Response: &fhir.BundleEntryResponse{
+							Status:  "400 Bad Request",
+							Outcome: json.RawMessage(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"processing","diagnostics":"invalid resource"}]}`),
+						},
+					},
+					{
+						Response: &fhir.BundleEntryResponse{
+							Status: "404 Not Found",
+						},
+					},
+				},
+			}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		tx := fhirclient.NewBatch()
+		var created Resource
+		createEntry := tx.Create(Resource{}).Into(&created)
+		deleteEntry := tx.Delete("Resource/456")
+
+		err := client.Transaction(tx)
+
+		require.NoError(t, err)
+		assert.Empty(t, created.Id)
+		require.IsType(t, fhirclient.OperationOutcomeError{}, createEntry.Err())
+		assert.Equal(t, http.StatusBadRequest, createEntry.Err().(fhirclient.OperationOutcomeError).HttpStatusCode)
+		assert.EqualError(t, deleteEntry.Err(), "FHIR transaction entry failed (DELETE Resource/456, status=404 Not Found)")
+	})
+	t.Run("response entry count mismatch", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(fhir.Bundle{Type: fhir.BundleTypeTransactionResponse}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+		tx := fhirclient.NewTransaction()
+		tx.Delete("Resource/456")
+
+		err := client.TransactionWithContext(context.Background(), tx)
+
+		require.EqualError(t, err, "FHIR transaction response contains 0 entries, expected 1")
+	})
+	t.Run("entry not executed", func(t *testing.T) {
+		entry := fhirclient.NewTransaction().Delete("Resource/456")
+
+		assert.EqualError(t, entry.Err(), "FHIR transaction entry has no response")
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
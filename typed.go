@@ -0,0 +1,194 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+// ResourceResult contains a resource as returned by the FHIR server after it was created or updated,
+// together with the id and version assigned by the server.
+type ResourceResult[T any] struct {
+	// Resource is the resource as returned by the FHIR server.
+	// If the server didn't return the resource (e.g. Prefer: return=minimal), it's the resource as sent to the server.
+	Resource T
+	// ID is the logical id of the resource, as assigned by the server.
+	ID string
+	// VersionID is the version of the resource, as assigned by the server. It's empty if the server doesn't support versioning.
+	VersionID string
+}
+
+// ReadResource reads the resource of type T with the given id from the FHIR server.
+// The resource type is derived from T, which must be a FHIR resource model (e.g. fhir.Patient).
+func ReadResource[T any](ctx context.Context, client Client, id string, opts ...Option) (*T, error) {
+	resourceType, err := resourceTypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	var result T
+	if err := client.ReadWithContext(ctx, resourceType+"/"+id, &result, opts...); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SearchResources searches for resources of type T on the FHIR server, and returns the matching resources of the first page.
+// Entries of other resource types (e.g. included resources or OperationOutcomes) are skipped.
+// The resource type is derived from T, which must be a FHIR resource model (e.g. fhir.Patient).
+func SearchResources[T any](ctx context.Context, client Client, query url.Values, opts ...Option) ([]T, error) {
+	resourceType, err := resourceTypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	var searchSet fhir.Bundle
+	if err := client.SearchWithContext(ctx, resourceType, query, &searchSet, opts...); err != nil {
+		return nil, err
+	}
+	return unwrapEntries[T](resourceType, searchSet)
+}
+
+// CreateResource creates the given resource of type T on the FHIR server,
+// and returns the created resource together with the id and version assigned by the server.
+func CreateResource[T any](ctx context.Context, client Client, resource T, opts ...Option) (*ResourceResult[T], error) {
+	var data []byte
+	var headers Headers
+	opts = append(opts, ResponseHeaders(&headers))
+	if err := client.CreateWithContext(ctx, resource, &data, opts...); err != nil {
+		return nil, err
+	}
+	return newResourceResult(resource, data, headers)
+}
+
+// UpdateResource updates the resource of type T with the given id on the FHIR server,
+// and returns the updated resource together with the version assigned by the server.
+func UpdateResource[T any](ctx context.Context, client Client, id string, resource T, opts ...Option) (*ResourceResult[T], error) {
+	resourceType, err := resourceTypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	var headers Headers
+	opts = append(opts, ResponseHeaders(&headers))
+	if err := client.UpdateWithContext(ctx, resourceType+"/"+id, resource, &data, opts...); err != nil {
+		return nil, err
+	}
+	result, err := newResourceResult(resource, data, headers)
+	if err != nil {
+		return nil, err
+	}
+	if result.ID == "" {
+		result.ID = id
+	}
+	return result, nil
+}
+
+// DeleteResource deletes the resource of type T with the given id from the FHIR server.
+func DeleteResource[T any](ctx context.Context, client Client, id string, opts ...Option) error {
+	resourceType, err := resourceTypeOf[T]()
+	if err != nil {
+		return err
+	}
+	return client.DeleteWithContext(ctx, resourceType+"/"+id, opts...)
+}
+
+// resourceTypeOf derives the FHIR resource type from T, by marshalling its zero value.
+// This works for the golang-fhir-models types, which always marshal their resourceType.
+func resourceTypeOf[T any]() (string, error) {
+	var zero T
+	desc, err := DescribeResource(zero)
+	if err != nil {
+		return "", fmt.Errorf("unable to derive FHIR resource type from %T: %w", zero, err)
+	}
+	return desc.Type, nil
+}
+
+// unwrapEntries unmarshals the resources of the given type in the Bundle's entries into T.
+func unwrapEntries[T any](resourceType string, bundle fhir.Bundle) ([]T, error) {
+	var results []T
+	for i, entry := range bundle.Entry {
+		if len(entry.Resource) == 0 {
+			continue
+		}
+		desc, err := DescribeResource([]byte(entry.Resource))
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource in Bundle entry %d: %w", i, err)
+		}
+		if desc.Type != resourceType {
+			continue
+		}
+		var result T
+		if err := json.Unmarshal(entry.Resource, &result); err != nil {
+			return nil, fmt.Errorf("invalid %s in Bundle entry %d: %w", resourceType, i, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// newResourceResult creates a ResourceResult from the response of a create or update interaction.
+// The id and version are taken from the returned resource, but the Location and ETag headers take precedence if present.
+func newResourceResult[T any](resource T, data []byte, headers Headers) (*ResourceResult[T], error) {
+	result := ResourceResult[T]{Resource: resource}
+	if len(data) > 0 {
+		if err := detectCodec(data).Unmarshal(data, &result.Resource); err != nil {
+			return nil, fmt.Errorf("FHIR response unmarshal failed: %w", err)
+		}
+		var version struct {
+			ID   string `json:"id"`
+			Meta struct {
+				VersionID string `json:"versionId"`
+			} `json:"meta"`
+		}
+		if err := detectCodec(data).Unmarshal(data, &version); err == nil {
+			result.ID = version.ID
+			result.VersionID = version.Meta.VersionID
+		}
+	}
+	if id, versionID := parseLocation(headers.Get("Location")); id != "" {
+		result.ID = id
+		if versionID != "" {
+			result.VersionID = versionID
+		}
+	}
+	if etag := strings.TrimPrefix(headers.ETag, "W/"); etag != "" {
+		result.VersionID = strings.Trim(etag, `"`)
+	}
+	return &result, nil
+}
+
+// parseLocation parses the id and version from a Location header (e.g. http://example.com/fhir/Patient/123/_history/1).
+func parseLocation(location string) (id string, versionID string) {
+	if location == "" {
+		return "", ""
+	}
+	if u, err := url.Parse(location); err == nil {
+		location = u.Path
+	}
+	segments := strings.Split(strings.Trim(location, "/"), "/")
+	if len(segments) >= 4 && segments[len(segments)-2] == "_history" {
+		return segments[len(segments)-3], segments[len(segments)-1]
+	}
+	if len(segments) >= 2 {
+		return segments[len(segments)-1], ""
+	}
+	return "", ""
+}
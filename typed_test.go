@@ -0,0 +1,142 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	fhirclient "github.com/SanteonNL/go-fhir-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestReadResource(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(fhir.Patient{ID: ptr("123")}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		patient, err := fhirclient.ReadResource[fhir.Patient](context.Background(), client, "123")
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", *patient.ID)
+		assert.Equal(t, "http://example.com/fhir/Patient/123", stub.request.URL.String())
+	})
+	t.Run("not a FHIR resource model", func(t *testing.T) {
+		client := fhirclient.New(baseURL, &requestResponder{}, nil)
+
+		_, err := fhirclient.ReadResource[struct{}](context.Background(), client, "123")
+
+		assert.EqualError(t, err, "unable to derive FHIR resource type from struct {}: resourceType not present in resource of type struct {}")
+	})
+}
+
+func TestSearchResources(t *testing.T) {
+	patient1, _ := json.Marshal(fhir.Patient{ID: ptr("1")})
+	patient2, _ := json.Marshal(fhir.Patient{ID: ptr("2")})
+	organization, _ := json.Marshal(fhir.Organization{ID: ptr("3")})
+	stub := &requestResponder{
+		response: okResponse(fhir.Bundle{
+			Type: fhir.BundleTypeSearchset,
+			Entry: []fhir.BundleEntry{
+				{Resource: patient1},
+				{Resource: organization},
+				{Resource: patient2},
+			},
+		}),
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	patients, err := fhirclient.SearchResources[fhir.Patient](context.Background(), client, url.Values{"_include": {"Patient:organization"}})
+
+	require.NoError(t, err)
+	require.Len(t, patients, 2)
+	assert.Equal(t, "1", *patients[0].ID)
+	assert.Equal(t, "2", *patients[1].ID)
+	assert.Equal(t, "http://example.com/fhir/Patient/_search", stub.request.URL.String())
+}
+
+func TestCreateResource(t *testing.T) {
+	t.Run("id and version from Location header", func(t *testing.T) {
+		stub := &requestResponder{
+			response: &http.Response{
+				StatusCode: http.StatusCreated,
+				Header:     http.Header{"Location": {"http://example.com/fhir/Patient/123/_history/2"}},
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			},
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		result, err := fhirclient.CreateResource(context.Background(), client, fhir.Patient{Active: ptr(true)})
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", result.ID)
+		assert.Equal(t, "2", result.VersionID)
+		assert.True(t, *result.Resource.Active)
+		assert.Equal(t, "http://example.com/fhir/Patient", stub.request.URL.String())
+	})
+	t.Run("id and version from returned resource", func(t *testing.T) {
+		stub := &requestResponder{
+			response: okResponse(fhir.Patient{ID: ptr("123"), Meta: &fhir.Meta{VersionId: ptr("1")}}),
+		}
+		client := fhirclient.New(baseURL, stub, nil)
+
+		result, err := fhirclient.CreateResource(context.Background(), client, fhir.Patient{})
+
+		require.NoError(t, err)
+		assert.Equal(t, "123", result.ID)
+		assert.Equal(t, "1", result.VersionID)
+		assert.Equal(t, "123", *result.Resource.ID)
+	})
+}
+
+func TestUpdateResource(t *testing.T) {
+	response := okResponse(fhir.Patient{ID: ptr("123")})
+	response.Header.Set("ETag", `W/"3"`)
+	stub := &requestResponder{
+		response: response,
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	result, err := fhirclient.UpdateResource(context.Background(), client, "123", fhir.Patient{ID: ptr("123")})
+
+	require.NoError(t, err)
+	assert.Equal(t, "123", result.ID)
+	assert.Equal(t, "3", result.VersionID)
+	assert.Equal(t, http.MethodPut, stub.request.Method)
+	assert.Equal(t, "http://example.com/fhir/Patient/123", stub.request.URL.String())
+}
+
+func TestDeleteResource(t *testing.T) {
+	stub := &requestResponder{
+		response: &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))},
+	}
+	client := fhirclient.New(baseURL, stub, nil)
+
+	err := fhirclient.DeleteResource[fhir.Patient](context.Background(), client, "123")
+
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodDelete, stub.request.Method)
+	assert.Equal(t, "http://example.com/fhir/Patient/123", stub.request.URL.String())
+}
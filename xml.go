@@ -0,0 +1,668 @@
+/*
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fhirclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/zorgbijjou/golang-fhir-models/fhir-models/fhir"
+)
+
+const (
+	fhirXMLNamespace  = "http://hl7.org/fhir"
+	xhtmlXMLNamespace = "http://www.w3.org/1999/xhtml"
+)
+
+var (
+	rawMessageType      = reflect.TypeFor[json.RawMessage]()
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	// primitiveExtensionType describes the extensions of a primitive element, which is represented as _name in JSON.
+	primitiveExtensionType = reflect.TypeFor[struct {
+		Extension []fhir.Extension `json:"extension"`
+	}]()
+)
+
+// marshalXML encodes the resource in FHIR XML, by converting its JSON representation.
+// The order of the elements is the order of the JSON properties, which for the FHIR models is the order defined by the specification.
+func marshalXML(resource any) ([]byte, error) {
+	data, err := JSONCodec.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource of type %T: %w", resource, err)
+	}
+	object, ok := value.(jsonObject)
+	if !ok || object.resourceType() == "" {
+		return nil, fmt.Errorf("resourceType not present in resource of type %T", resource)
+	}
+	var buf bytes.Buffer
+	writeXMLResource(&buf, object, true)
+	return buf.Bytes(), nil
+}
+
+// unmarshalXML decodes the FHIR XML data into the target, by converting it to its JSON representation.
+// The target's type determines which elements are lists and how primitive values are represented (string, number or boolean).
+// Resources nested in json.RawMessage fields (e.g. Bundle.entry.resource) are converted using the FHIR model of their resource type.
+func unmarshalXML(data []byte, target any) error {
+	targetType := reflect.TypeOf(target)
+	if targetType == nil || targetType.Kind() != reflect.Pointer {
+		return errors.New("target must be a pointer")
+	}
+	root, err := parseXML(data)
+	if err != nil {
+		return fmt.Errorf("invalid FHIR XML: %w", err)
+	}
+	resourceType := derefType(targetType.Elem())
+	if resourceType.Kind() != reflect.Struct {
+		// e.g. json.RawMessage or map[string]interface{}
+		resourceType = xmlResourceTypes[root.name]
+	}
+	jsonData, err := json.Marshal(xmlResourceToJSON(root, resourceType))
+	if err != nil {
+		return fmt.Errorf("invalid FHIR XML: %w", err)
+	}
+	return json.Unmarshal(jsonData, target)
+}
+
+// jsonObject is a JSON object that retains the order of its members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	name  string
+	value any
+}
+
+func (o jsonObject) get(name string) (any, bool) {
+	for _, member := range o {
+		if member.name == name {
+			return member.value, true
+		}
+	}
+	return nil, false
+}
+
+func (o jsonObject) resourceType() string {
+	value, _ := o.get("resourceType")
+	resourceType, _ := value.(string)
+	return resourceType
+}
+
+// decodeOrderedJSON decodes the next JSON value, decoding objects as jsonObject.
+func decodeOrderedJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '{':
+		result := jsonObject{}
+		for decoder.More() {
+			name, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, jsonMember{name: name.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return result, err
+	case '[':
+		result := []any{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		_, err = decoder.Token()
+		return result, err
+	}
+	return nil, fmt.Errorf("unexpected JSON delimiter: %v", delim)
+}
+
+func writeXMLResource(buf *bytes.Buffer, resource jsonObject, root bool) {
+	resourceType := resource.resourceType()
+	buf.WriteString("<" + resourceType)
+	if root {
+		writeXMLAttr(buf, "xmlns", fhirXMLNamespace)
+	}
+	buf.WriteString(">")
+	writeXMLChildren(buf, resource, true, false)
+	buf.WriteString("</" + resourceType + ">")
+}
+
+// writeXMLChildren writes the members of the JSON object as child elements.
+// The id of elements (as opposed to the id of resources) and the url of extensions are attributes in XML, so they're skipped.
+func writeXMLChildren(buf *bytes.Buffer, object jsonObject, isResource bool, isExtension bool) {
+	for _, member := range object {
+		name := member.name
+		switch {
+		case name == "resourceType",
+			name == "id" && !isResource,
+			name == "url" && isExtension:
+			continue
+		case strings.HasPrefix(name, "_"):
+			// Extensions of a primitive element are written together with its value, unless it has no value.
+			if _, hasValue := object.get(name[1:]); !hasValue {
+				writeXMLMember(buf, name[1:], nil, member.value)
+			}
+			continue
+		}
+		extensions, _ := object.get("_" + name)
+		writeXMLMember(buf, name, member.value, extensions)
+	}
+}
+
+// writeXMLMember writes a JSON object member as element(s). The extensions are the value of the _name member (if any).
+func writeXMLMember(buf *bytes.Buffer, name string, value any, extensions any) {
+	if values, ok := value.([]any); ok {
+		elementExtensions, _ := extensions.([]any)
+		for i, curr := range values {
+			var elementExtension any
+			if i < len(elementExtensions) {
+				elementExtension = elementExtensions[i]
+			}
+			writeXMLMember(buf, name, curr, elementExtension)
+		}
+		return
+	}
+	if elementExtensions, ok := extensions.([]any); ok && value == nil {
+		for _, curr := range elementExtensions {
+			writeXMLMember(buf, name, nil, curr)
+		}
+		return
+	}
+	switch v := value.(type) {
+	case jsonObject:
+		if v.resourceType() != "" {
+			buf.WriteString("<" + name + ">")
+			writeXMLResource(buf, v, false)
+			buf.WriteString("</" + name + ">")
+			return
+		}
+		isExtension := name == "extension" || name == "modifierExtension"
+		buf.WriteString("<" + name)
+		if id, ok := v.get("id"); ok {
+			writeXMLAttr(buf, "id", id)
+		}
+		if url, ok := v.get("url"); ok && isExtension {
+			writeXMLAttr(buf, "url", url)
+		}
+		buf.WriteString(">")
+		writeXMLChildren(buf, v, false, isExtension)
+		buf.WriteString("</" + name + ">")
+	case string:
+		if name == "div" {
+			// Narrative XHTML is embedded as-is
+			buf.WriteString(v)
+			return
+		}
+		writeXMLPrimitive(buf, name, v, extensions)
+	case json.Number, bool:
+		writeXMLPrimitive(buf, name, v, extensions)
+	case nil:
+		if extensions != nil {
+			writeXMLPrimitive(buf, name, nil, extensions)
+		}
+	}
+}
+
+// writeXMLPrimitive writes a primitive element, with its value in the value attribute.
+func writeXMLPrimitive(buf *bytes.Buffer, name string, value any, extensions any) {
+	buf.WriteString("<" + name)
+	if value != nil {
+		writeXMLAttr(buf, "value", value)
+	}
+	extensionObject, _ := extensions.(jsonObject)
+	if id, ok := extensionObject.get("id"); ok {
+		writeXMLAttr(buf, "id", id)
+	}
+	extension, ok := extensionObject.get("extension")
+	if !ok {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	writeXMLMember(buf, "extension", extension, nil)
+	buf.WriteString("</" + name + ">")
+}
+
+func writeXMLAttr(buf *bytes.Buffer, name string, value any) {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case json.Number:
+		str = v.String()
+	case bool:
+		str = strconv.FormatBool(v)
+	default:
+		return
+	}
+	buf.WriteString(" " + name + `="`)
+	_ = xml.EscapeText(buf, []byte(str))
+	buf.WriteString(`"`)
+}
+
+// xmlNode is an element of a FHIR XML document.
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xmlNode
+	// xhtml is the raw XHTML of a Narrative's div element.
+	xhtml string
+}
+
+func parseXML(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	var stack []*xmlNode
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" && attr.Name.Local != "xmlns" {
+					node.attrs[attr.Name.Local] = attr.Value
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			} else {
+				return nil, errors.New("multiple root elements")
+			}
+			if t.Name.Space == xhtmlXMLNamespace {
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+				node.xhtml = string(data[offset:decoder.InputOffset()])
+			} else {
+				stack = append(stack, node)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+// xmlResourceToJSON converts the XML element of a resource to its JSON representation.
+// If the resource type is nil, the conversion is schema-less: repeating elements become lists and primitive values strings.
+func xmlResourceToJSON(node *xmlNode, resourceType reflect.Type) map[string]any {
+	result := xmlChildrenToJSON(node.children, resourceType)
+	result["resourceType"] = node.name
+	return result
+}
+
+func xmlChildrenToJSON(children []*xmlNode, parentType reflect.Type) map[string]any {
+	result := map[string]any{}
+	fields := jsonFields(parentType)
+	var names []string
+	elements := map[string][]*xmlNode{}
+	for _, child := range children {
+		if _, seen := elements[child.name]; !seen {
+			names = append(names, child.name)
+		}
+		elements[child.name] = append(elements[child.name], child)
+	}
+	for _, name := range names {
+		nodes := elements[name]
+		var fieldType reflect.Type
+		if fields != nil {
+			var known bool
+			if fieldType, known = fields[name]; !known {
+				// Would be ignored when unmarshalling the JSON anyway
+				continue
+			}
+		}
+		if isListType(fieldType) || (fieldType == nil && len(nodes) > 1) {
+			var elementType reflect.Type
+			if fieldType != nil {
+				elementType = derefType(fieldType).Elem()
+			}
+			values := make([]any, len(nodes))
+			extensions := make([]any, len(nodes))
+			hasExtensions := false
+			for i, node := range nodes {
+				values[i], extensions[i] = xmlElementToJSON(node, elementType)
+				hasExtensions = hasExtensions || extensions[i] != nil
+			}
+			result[name] = values
+			if hasExtensions {
+				result["_"+name] = extensions
+			}
+		} else {
+			value, extensions := xmlElementToJSON(nodes[len(nodes)-1], fieldType)
+			if value != nil {
+				result[name] = value
+			}
+			if extensions != nil {
+				result["_"+name] = extensions
+			}
+		}
+	}
+	return result
+}
+
+// xmlElementToJSON converts an element to its JSON value, and for primitive elements with an id or extensions, their _name value.
+func xmlElementToJSON(node *xmlNode, elementType reflect.Type) (any, any) {
+	elementType = derefType(elementType)
+	value, hasValue := node.attrs["value"]
+	switch {
+	case node.xhtml != "":
+		return node.xhtml, nil
+	case elementType == rawMessageType || (elementType == nil && !hasValue && len(node.children) == 1 && isResourceName(node.children[0].name)):
+		if len(node.children) == 0 {
+			return nil, nil
+		}
+		resource := node.children[0]
+		return xmlResourceToJSON(resource, xmlResourceTypes[resource.name]), nil
+	case hasValue || (elementType != nil && !isComplexType(elementType)):
+		var extensions map[string]any
+		if id, ok := node.attrs["id"]; ok {
+			extensions = map[string]any{"id": id}
+		}
+		if len(node.children) > 0 {
+			if extensions == nil {
+				extensions = map[string]any{}
+			}
+			extensions["extension"] = xmlChildrenToJSON(node.children, primitiveExtensionType)["extension"]
+		}
+		var extensionsValue any
+		if extensions != nil {
+			extensionsValue = extensions
+		}
+		if !hasValue {
+			return nil, extensionsValue
+		}
+		return xmlPrimitiveToJSON(value, elementType), extensionsValue
+	}
+	result := xmlChildrenToJSON(node.children, elementType)
+	if id, ok := node.attrs["id"]; ok {
+		result["id"] = id
+	}
+	if url, ok := node.attrs["url"]; ok {
+		result["url"] = url
+	}
+	return result, nil
+}
+
+// xmlPrimitiveToJSON converts the value attribute of a primitive element to the JSON value the Go type expects.
+func xmlPrimitiveToJSON(value string, elementType reflect.Type) any {
+	if elementType == nil || reflect.PointerTo(elementType).Implements(jsonUnmarshalerType) {
+		// Codes are unmarshalled from JSON strings
+		return value
+	}
+	switch elementType.Kind() {
+	case reflect.Bool:
+		return value == "true"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return json.Number(value)
+	}
+	return value
+}
+
+var jsonFieldsCache sync.Map
+
+// jsonFields returns the JSON property names of the given struct type, mapped to their Go type.
+// It returns nil if the type is not a struct.
+func jsonFields(structType reflect.Type) map[string]reflect.Type {
+	structType = derefType(structType)
+	if structType == nil || structType.Kind() != reflect.Struct {
+		return nil
+	}
+	if cached, ok := jsonFieldsCache.Load(structType); ok {
+		return cached.(map[string]reflect.Type)
+	}
+	result := map[string]reflect.Type{}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			// Fields of embedded structs are promoted, unless shadowed
+			for embeddedName, embeddedType := range jsonFields(field.Type) {
+				if _, exists := result[embeddedName]; !exists {
+					result[embeddedName] = embeddedType
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		result[name] = field.Type
+	}
+	jsonFieldsCache.Store(structType, result)
+	return result
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func isListType(t reflect.Type) bool {
+	t = derefType(t)
+	return t != nil && t.Kind() == reflect.Slice && t != rawMessageType && t.Elem().Kind() != reflect.Uint8
+}
+
+func isComplexType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// isResourceName returns true if the element name is a resource type: element names start with a lowercase letter, resource types with an uppercase letter.
+func isResourceName(name string) bool {
+	return name != "" && unicode.IsUpper(rune(name[0]))
+}
+
+// xmlResourceTypes maps resource types to their FHIR model, used to convert resources of which the Go type isn't known in advance.
+var xmlResourceTypes = map[string]reflect.Type{
+	"Account":                           reflect.TypeFor[fhir.Account](),
+	"ActivityDefinition":                reflect.TypeFor[fhir.ActivityDefinition](),
+	"AdverseEvent":                      reflect.TypeFor[fhir.AdverseEvent](),
+	"AllergyIntolerance":                reflect.TypeFor[fhir.AllergyIntolerance](),
+	"Appointment":                       reflect.TypeFor[fhir.Appointment](),
+	"AppointmentResponse":               reflect.TypeFor[fhir.AppointmentResponse](),
+	"AuditEvent":                        reflect.TypeFor[fhir.AuditEvent](),
+	"Basic":                             reflect.TypeFor[fhir.Basic](),
+	"Binary":                            reflect.TypeFor[fhir.Binary](),
+	"BiologicallyDerivedProduct":        reflect.TypeFor[fhir.BiologicallyDerivedProduct](),
+	"BodyStructure":                     reflect.TypeFor[fhir.BodyStructure](),
+	"Bundle":                            reflect.TypeFor[fhir.Bundle](),
+	"CapabilityStatement":               reflect.TypeFor[fhir.CapabilityStatement](),
+	"CarePlan":                          reflect.TypeFor[fhir.CarePlan](),
+	"CareTeam":                          reflect.TypeFor[fhir.CareTeam](),
+	"CatalogEntry":                      reflect.TypeFor[fhir.CatalogEntry](),
+	"ChargeItem":                        reflect.TypeFor[fhir.ChargeItem](),
+	"ChargeItemDefinition":              reflect.TypeFor[fhir.ChargeItemDefinition](),
+	"Claim":                             reflect.TypeFor[fhir.Claim](),
+	"ClaimResponse":                     reflect.TypeFor[fhir.ClaimResponse](),
+	"ClinicalImpression":                reflect.TypeFor[fhir.ClinicalImpression](),
+	"CodeSystem":                        reflect.TypeFor[fhir.CodeSystem](),
+	"Communication":                     reflect.TypeFor[fhir.Communication](),
+	"CommunicationRequest":              reflect.TypeFor[fhir.CommunicationRequest](),
+	"CompartmentDefinition":             reflect.TypeFor[fhir.CompartmentDefinition](),
+	"Composition":                       reflect.TypeFor[fhir.Composition](),
+	"ConceptMap":                        reflect.TypeFor[fhir.ConceptMap](),
+	"Condition":                         reflect.TypeFor[fhir.Condition](),
+	"Consent":                           reflect.TypeFor[fhir.Consent](),
+	"Contract":                          reflect.TypeFor[fhir.Contract](),
+	"Coverage":                          reflect.TypeFor[fhir.Coverage](),
+	"CoverageEligibilityRequest":        reflect.TypeFor[fhir.CoverageEligibilityRequest](),
+	"CoverageEligibilityResponse":       reflect.TypeFor[fhir.CoverageEligibilityResponse](),
+	"DetectedIssue":                     reflect.TypeFor[fhir.DetectedIssue](),
+	"Device":                            reflect.TypeFor[fhir.Device](),
+	"DeviceDefinition":                  reflect.TypeFor[fhir.DeviceDefinition](),
+	"DeviceMetric":                      reflect.TypeFor[fhir.DeviceMetric](),
+	"DeviceRequest":                     reflect.TypeFor[fhir.DeviceRequest](),
+	"DeviceUseStatement":                reflect.TypeFor[fhir.DeviceUseStatement](),
+	"DiagnosticReport":                  reflect.TypeFor[fhir.DiagnosticReport](),
+	"DocumentManifest":                  reflect.TypeFor[fhir.DocumentManifest](),
+	"DocumentReference":                 reflect.TypeFor[fhir.DocumentReference](),
+	"EffectEvidenceSynthesis":           reflect.TypeFor[fhir.EffectEvidenceSynthesis](),
+	"Encounter":                         reflect.TypeFor[fhir.Encounter](),
+	"Endpoint":                          reflect.TypeFor[fhir.Endpoint](),
+	"EnrollmentRequest":                 reflect.TypeFor[fhir.EnrollmentRequest](),
+	"EnrollmentResponse":                reflect.TypeFor[fhir.EnrollmentResponse](),
+	"EpisodeOfCare":                     reflect.TypeFor[fhir.EpisodeOfCare](),
+	"EventDefinition":                   reflect.TypeFor[fhir.EventDefinition](),
+	"Evidence":                          reflect.TypeFor[fhir.Evidence](),
+	"EvidenceVariable":                  reflect.TypeFor[fhir.EvidenceVariable](),
+	"ExampleScenario":                   reflect.TypeFor[fhir.ExampleScenario](),
+	"ExplanationOfBenefit":              reflect.TypeFor[fhir.ExplanationOfBenefit](),
+	"FamilyMemberHistory":               reflect.TypeFor[fhir.FamilyMemberHistory](),
+	"Flag":                              reflect.TypeFor[fhir.Flag](),
+	"Goal":                              reflect.TypeFor[fhir.Goal](),
+	"GraphDefinition":                   reflect.TypeFor[fhir.GraphDefinition](),
+	"Group":                             reflect.TypeFor[fhir.Group](),
+	"GuidanceResponse":                  reflect.TypeFor[fhir.GuidanceResponse](),
+	"HealthcareService":                 reflect.TypeFor[fhir.HealthcareService](),
+	"ImagingStudy":                      reflect.TypeFor[fhir.ImagingStudy](),
+	"Immunization":                      reflect.TypeFor[fhir.Immunization](),
+	"ImmunizationEvaluation":            reflect.TypeFor[fhir.ImmunizationEvaluation](),
+	"ImmunizationRecommendation":        reflect.TypeFor[fhir.ImmunizationRecommendation](),
+	"ImplementationGuide":               reflect.TypeFor[fhir.ImplementationGuide](),
+	"InsurancePlan":                     reflect.TypeFor[fhir.InsurancePlan](),
+	"Invoice":                           reflect.TypeFor[fhir.Invoice](),
+	"Library":                           reflect.TypeFor[fhir.Library](),
+	"Linkage":                           reflect.TypeFor[fhir.Linkage](),
+	"List":                              reflect.TypeFor[fhir.List](),
+	"Location":                          reflect.TypeFor[fhir.Location](),
+	"Measure":                           reflect.TypeFor[fhir.Measure](),
+	"MeasureReport":                     reflect.TypeFor[fhir.MeasureReport](),
+	"Media":                             reflect.TypeFor[fhir.Media](),
+	"Medication":                        reflect.TypeFor[fhir.Medication](),
+	"MedicationAdministration":          reflect.TypeFor[fhir.MedicationAdministration](),
+	"MedicationDispense":                reflect.TypeFor[fhir.MedicationDispense](),
+	"MedicationKnowledge":               reflect.TypeFor[fhir.MedicationKnowledge](),
+	"MedicationRequest":                 reflect.TypeFor[fhir.MedicationRequest](),
+	"MedicationStatement":               reflect.TypeFor[fhir.MedicationStatement](),
+	"MedicinalProduct":                  reflect.TypeFor[fhir.MedicinalProduct](),
+	"MedicinalProductAuthorization":     reflect.TypeFor[fhir.MedicinalProductAuthorization](),
+	"MedicinalProductContraindication":  reflect.TypeFor[fhir.MedicinalProductContraindication](),
+	"MedicinalProductIndication":        reflect.TypeFor[fhir.MedicinalProductIndication](),
+	"MedicinalProductIngredient":        reflect.TypeFor[fhir.MedicinalProductIngredient](),
+	"MedicinalProductInteraction":       reflect.TypeFor[fhir.MedicinalProductInteraction](),
+	"MedicinalProductManufactured":      reflect.TypeFor[fhir.MedicinalProductManufactured](),
+	"MedicinalProductPackaged":          reflect.TypeFor[fhir.MedicinalProductPackaged](),
+	"MedicinalProductPharmaceutical":    reflect.TypeFor[fhir.MedicinalProductPharmaceutical](),
+	"MedicinalProductUndesirableEffect": reflect.TypeFor[fhir.MedicinalProductUndesirableEffect](),
+	"MessageDefinition":                 reflect.TypeFor[fhir.MessageDefinition](),
+	"MessageHeader":                     reflect.TypeFor[fhir.MessageHeader](),
+	"MolecularSequence":                 reflect.TypeFor[fhir.MolecularSequence](),
+	"NamingSystem":                      reflect.TypeFor[fhir.NamingSystem](),
+	"NutritionOrder":                    reflect.TypeFor[fhir.NutritionOrder](),
+	"Observation":                       reflect.TypeFor[fhir.Observation](),
+	"ObservationDefinition":             reflect.TypeFor[fhir.ObservationDefinition](),
+	"OperationDefinition":               reflect.TypeFor[fhir.OperationDefinition](),
+	"OperationOutcome":                  reflect.TypeFor[fhir.OperationOutcome](),
+	"Organization":                      reflect.TypeFor[fhir.Organization](),
+	"OrganizationAffiliation":           reflect.TypeFor[fhir.OrganizationAffiliation](),
+	"Parameters":                        reflect.TypeFor[fhir.Parameters](),
+	"Patient":                           reflect.TypeFor[fhir.Patient](),
+	"PaymentNotice":                     reflect.TypeFor[fhir.PaymentNotice](),
+	"PaymentReconciliation":             reflect.TypeFor[fhir.PaymentReconciliation](),
+	"Person":                            reflect.TypeFor[fhir.Person](),
+	"PlanDefinition":                    reflect.TypeFor[fhir.PlanDefinition](),
+	"Practitioner":                      reflect.TypeFor[fhir.Practitioner](),
+	"PractitionerRole":                  reflect.TypeFor[fhir.PractitionerRole](),
+	"Procedure":                         reflect.TypeFor[fhir.Procedure](),
+	"Provenance":                        reflect.TypeFor[fhir.Provenance](),
+	"Questionnaire":                     reflect.TypeFor[fhir.Questionnaire](),
+	"QuestionnaireResponse":             reflect.TypeFor[fhir.QuestionnaireResponse](),
+	"RelatedPerson":                     reflect.TypeFor[fhir.RelatedPerson](),
+	"RequestGroup":                      reflect.TypeFor[fhir.RequestGroup](),
+	"ResearchDefinition":                reflect.TypeFor[fhir.ResearchDefinition](),
+	"ResearchElementDefinition":         reflect.TypeFor[fhir.ResearchElementDefinition](),
+	"ResearchStudy":                     reflect.TypeFor[fhir.ResearchStudy](),
+	"ResearchSubject":                   reflect.TypeFor[fhir.ResearchSubject](),
+	"RiskAssessment":                    reflect.TypeFor[fhir.RiskAssessment](),
+	"RiskEvidenceSynthesis":             reflect.TypeFor[fhir.RiskEvidenceSynthesis](),
+	"Schedule":                          reflect.TypeFor[fhir.Schedule](),
+	"SearchParameter":                   reflect.TypeFor[fhir.SearchParameter](),
+	"ServiceRequest":                    reflect.TypeFor[fhir.ServiceRequest](),
+	"Slot":                              reflect.TypeFor[fhir.Slot](),
+	"Specimen":                          reflect.TypeFor[fhir.Specimen](),
+	"SpecimenDefinition":                reflect.TypeFor[fhir.SpecimenDefinition](),
+	"StructureDefinition":               reflect.TypeFor[fhir.StructureDefinition](),
+	"StructureMap":                      reflect.TypeFor[fhir.StructureMap](),
+	"Subscription":                      reflect.TypeFor[fhir.Subscription](),
+	"Substance":                         reflect.TypeFor[fhir.Substance](),
+	"SubstanceNucleicAcid":              reflect.TypeFor[fhir.SubstanceNucleicAcid](),
+	"SubstancePolymer":                  reflect.TypeFor[fhir.SubstancePolymer](),
+	"SubstanceProtein":                  reflect.TypeFor[fhir.SubstanceProtein](),
+	"SubstanceReferenceInformation":     reflect.TypeFor[fhir.SubstanceReferenceInformation](),
+	"SubstanceSourceMaterial":           reflect.TypeFor[fhir.SubstanceSourceMaterial](),
+	"SubstanceSpecification":            reflect.TypeFor[fhir.SubstanceSpecification](),
+	"SupplyDelivery":                    reflect.TypeFor[fhir.SupplyDelivery](),
+	"SupplyRequest":                     reflect.TypeFor[fhir.SupplyRequest](),
+	"Task":                              reflect.TypeFor[fhir.Task](),
+	"TerminologyCapabilities":           reflect.TypeFor[fhir.TerminologyCapabilities](),
+	"TestReport":                        reflect.TypeFor[fhir.TestReport](),
+	"TestScript":                        reflect.TypeFor[fhir.TestScript](),
+	"ValueSet":                          reflect.TypeFor[fhir.ValueSet](),
+	"VerificationResult":                reflect.TypeFor[fhir.VerificationResult](),
+	"VisionPrescription":                reflect.TypeFor[fhir.VisionPrescription](),
+}